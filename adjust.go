@@ -0,0 +1,66 @@
+package gincage
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Key of final cost of request in gin context, see SetCost
+const costContextKey = "gincage-cost"
+
+// Sets final cost of request handled by gin, e.g. by count of returned
+// rows or streamed bytes. After handlers return, WalkThrough reconciles
+// tokens taken for request with it, see Limiter.Adjust
+func SetCost(ctx *gin.Context, n int) {
+	ctx.Set(costContextKey, n)
+}
+
+// Reconciles tokens taken for allowed decision d with final cost of request:
+// the rest is taken without limit check (tokens don't go below zero),
+// overpaid tokens are returned (up to capacity), so quotas follow actual usage.
+//
+// Requires bucket implementing Syncer. Does nothing for other buckets and
+// for decisions which took no tokens (e.g. allowed by fail policy)
+func (l *Limiter) Adjust(ctx context.Context, d Decision, cost int) error {
+	if len(d.taken) == 0 {
+		return nil
+	}
+	s, ok := BucketAs[Syncer](l.bucket)
+	if !ok {
+		return nil
+	}
+
+	now := time.Now()
+	updates := make([]SyncUpdate, 0, len(d.taken))
+	for _, k := range d.taken {
+		if diff := max(cost, 0) - k.Cost; diff != 0 {
+			updates = append(updates, SyncUpdate{Object: k.Key, Tokens: diff, Timestamp: now, Rate: k.Rate})
+		}
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+
+	ctx, cancel := l.storageContext(ctx)
+	defer cancel()
+	_, err := s.Sync(ctx, updates)
+	return err
+}
+
+// Adjusts tokens of request handled by gin if handlers set its final cost
+func (l *Limiter) settle(ctx *gin.Context, req Request, d Decision) {
+	v, ok := ctx.Get(costContextKey)
+	if !ok {
+		return
+	}
+	cost, ok := v.(int)
+	if !ok {
+		return
+	}
+	// response is written, request context may be done
+	if err := l.Adjust(context.WithoutCancel(requestContext(ctx)), d, cost); err != nil {
+		l.log(req.RequestID).Error("failed to adjust cost of request", F("key", req.Key), F("error", err))
+	}
+}
@@ -0,0 +1,373 @@
+package gincage
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAuth: returns true if request is allowed to use admin routes
+type AdminAuth func(ctx *gin.Context) bool
+
+// Sets authentication of admin routes.
+// Without it admin routes reject every request
+func WithAdminAuth(auth AdminAuth) Option {
+	return func(l *Limiter) {
+		l.adminAuth = auth
+	}
+}
+
+// Allows requests with "Authorization: Bearer <token>" header
+func AdminBearerToken(token string) AdminAuth {
+	return func(ctx *gin.Context) bool {
+		got, ok := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+	}
+}
+
+type adminError struct {
+	Error string `json:"error"`
+}
+
+type adminBanPolicy struct {
+	Threshold int    `json:"threshold"`
+	Window    string `json:"window"`
+	Duration  string `json:"duration"`
+}
+
+type adminGreylist struct {
+	Penalties []string `json:"penalties"`
+	Memory    string   `json:"memory"`
+	Tarpit    string   `json:"tarpit,omitempty"`
+}
+
+type adminLimits struct {
+	Rate      Rate            `json:"rate"`
+	Routes    []RouteRule     `json:"routes,omitempty"`
+	Groups    map[string]Rate `json:"groups,omitempty"`
+	Global    *Rate           `json:"global,omitempty"`
+	Shadow    bool            `json:"shadow,omitempty"`
+	BanPolicy *adminBanPolicy `json:"ban_policy,omitempty"`
+	Greylist  *adminGreylist  `json:"greylist,omitempty"`
+
+	// 100 if all keys are enforced
+	EnforcePercent float64            `json:"enforce_percent"`
+	Priorities     map[string]float64 `json:"priorities,omitempty"`
+	Schedules      []Schedule         `json:"schedules,omitempty"`
+	Networks       []NetworkClass     `json:"networks,omitempty"`
+	Scopes         []ScopeRule        `json:"scopes,omitempty"`
+	Rules          []Rule             `json:"rules,omitempty"`
+	// Name of schedule active now
+	ActiveSchedule string `json:"active_schedule,omitempty"`
+}
+
+type adminKey struct {
+	Key         string     `json:"key"`
+	Tokens      int        `json:"tokens"`
+	RefilledAt  time.Time  `json:"refilled_at"`
+	Exists      bool       `json:"exists"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	BannedUntil *time.Time `json:"banned_until,omitempty"`
+}
+
+type adminCredit struct {
+	Tokens int `json:"tokens"`
+}
+
+type adminBan struct {
+	Key   string    `json:"key"`
+	Until time.Time `json:"until"`
+}
+
+// Registers admin endpoints on group:
+//
+// - GET /limits: current limits, route rules and ban policy
+//
+// - GET /keys/:key: remaining tokens of key
+//
+// - DELETE /keys/:key: restores full capacity of key
+//
+// - POST /keys/:key/credit: grants extra tokens of {"tokens": n} body to key (see Credit)
+//
+// - GET /bans: banned keys
+//
+// - DELETE /bans/:key: removes ban of key
+//
+// - GET /top?n=10&by=rejected: top consumers (see WithTopConsumers)
+//
+// - GET /stats: limiter totals and backends health (see Stats)
+//
+// - GET /health: 200 if limiter is healthy, otherwise 503 (see Healthy)
+//
+// - GET /export: state of all keys as JSON lines (see Export)
+//
+// - POST /import: restores keys from JSON lines in body (see Import)
+//
+// - GET /flags: flags stored by bucket (see Flags)
+//
+// - PUT /flags: replaces flags with JSON body (see SetFlags)
+//
+// Requests are authenticated with WithAdminAuth.
+// Endpoints return 501 Not Implemented if bucket doesn't support operation
+func (l *Limiter) AdminRoutes(group *gin.RouterGroup) {
+	g := group.Group("", l.adminAuthenticate)
+	g.GET("/limits", l.adminLimits)
+	g.GET("/keys/:key", l.adminPeek)
+	g.DELETE("/keys/:key", l.adminReset)
+	g.POST("/keys/:key/credit", l.adminCredit)
+	g.GET("/bans", l.adminBans)
+	g.DELETE("/bans/:key", l.adminUnban)
+	g.GET("/top", l.adminTop)
+	g.GET("/stats", l.adminStats)
+	g.GET("/health", l.HealthHandler())
+	g.GET("/export", l.adminExport)
+	g.POST("/import", l.adminImport)
+	g.GET("/flags", l.adminFlags)
+	g.PUT("/flags", l.adminSetFlags)
+}
+
+func (l *Limiter) adminAuthenticate(ctx *gin.Context) {
+	if l.adminAuth == nil || !l.adminAuth(ctx) {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, adminError{Error: "unauthorized"})
+	}
+}
+
+func (l *Limiter) adminLimits(ctx *gin.Context) {
+	cfg := l.Config()
+	resp := adminLimits{
+		Rate:   cfg.Rate,
+		Routes: cfg.Routes,
+		Groups: cfg.Groups,
+		Shadow: cfg.Shadow,
+
+		EnforcePercent: 100,
+		Priorities:     cfg.Priorities,
+		Schedules:      cfg.Schedules,
+		Networks:       cfg.Networks,
+		Scopes:         cfg.Scopes,
+		Rules:          cfg.Rules,
+		ActiveSchedule: cfg.activeSchedule(time.Now()),
+	}
+	if p := cfg.EnforcePercent; p > 0 && p < 100 {
+		resp.EnforcePercent = p
+	}
+	if r, ok := BucketAs[RateReporter](l.bucket); ok {
+		resp.Rate = resp.Rate.withDefaults(r.Rate())
+	}
+	if cfg.Global.Capacity > 0 {
+		resp.Global = &cfg.Global
+	}
+	if p := l.banPolicy; p != nil {
+		resp.BanPolicy = &adminBanPolicy{
+			Threshold: p.Threshold,
+			Window:    p.Window.String(),
+			Duration:  p.Duration.String(),
+		}
+	}
+	if p := l.greylist; p != nil {
+		resp.Greylist = &adminGreylist{Memory: p.Memory.String()}
+		for _, d := range p.Penalties {
+			resp.Greylist.Penalties = append(resp.Greylist.Penalties, d.String())
+		}
+		if p.Tarpit > 0 {
+			resp.Greylist.Tarpit = p.Tarpit.String()
+		}
+	}
+	ctx.JSON(http.StatusOK, resp)
+}
+
+func (l *Limiter) adminPeek(ctx *gin.Context) {
+	peeker, ok := BucketAs[Peeker](l.bucket)
+	if !ok {
+		adminNotImplemented(ctx)
+		return
+	}
+
+	key := l.StorageKey(ctx.Param("key"))
+	state, err := peeker.Peek(requestContext(ctx), key)
+	if err != nil {
+		l.adminFailure(ctx, err)
+		return
+	}
+	resp := adminKey{
+		Key:        state.Key,
+		Tokens:     state.Tokens,
+		RefilledAt: state.RefilledAt,
+		Exists:     state.Exists,
+	}
+	if !state.ExpiresAt.IsZero() {
+		resp.ExpiresAt = &state.ExpiresAt
+	}
+	if l.banner != nil {
+		until, _, err := l.bannedUntil(requestContext(ctx), key)
+		if err != nil {
+			l.adminFailure(ctx, err)
+			return
+		}
+		if !until.IsZero() {
+			resp.BannedUntil = &until
+		}
+	}
+	ctx.JSON(http.StatusOK, resp)
+}
+
+func (l *Limiter) adminReset(ctx *gin.Context) {
+	resetter, ok := BucketAs[Resetter](l.bucket)
+	if !ok {
+		adminNotImplemented(ctx)
+		return
+	}
+
+	key := l.StorageKey(ctx.Param("key"))
+	if err := resetter.Reset(requestContext(ctx), key); err != nil {
+		l.adminFailure(ctx, err)
+		return
+	}
+	l.logger.Info("key reset by admin", F("key", key))
+	ctx.Status(http.StatusNoContent)
+}
+
+func (l *Limiter) adminCredit(ctx *gin.Context) {
+	if _, ok := BucketAs[Crediter](l.bucket); !ok {
+		adminNotImplemented(ctx)
+		return
+	}
+
+	var body adminCredit
+	if err := json.NewDecoder(ctx.Request.Body).Decode(&body); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, adminError{Error: err.Error()})
+		return
+	}
+	if body.Tokens <= 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, adminError{Error: "tokens: should be positive"})
+		return
+	}
+	state, err := l.Credit(requestContext(ctx), ctx.Param("key"), body.Tokens)
+	if err != nil {
+		l.adminFailure(ctx, err)
+		return
+	}
+	resp := adminKey{
+		Key:        state.Key,
+		Tokens:     state.Tokens,
+		RefilledAt: state.RefilledAt,
+		Exists:     state.Exists,
+	}
+	if !state.ExpiresAt.IsZero() {
+		resp.ExpiresAt = &state.ExpiresAt
+	}
+	ctx.JSON(http.StatusOK, resp)
+}
+
+func (l *Limiter) adminBans(ctx *gin.Context) {
+	if _, ok := BucketAs[Banner](l.bucket); !ok {
+		adminNotImplemented(ctx)
+		return
+	}
+
+	bans, err := l.Bans(requestContext(ctx))
+	if err != nil {
+		l.adminFailure(ctx, err)
+		return
+	}
+	resp := make([]adminBan, len(bans))
+	for i, b := range bans {
+		resp[i] = adminBan{Key: b.Key, Until: b.Until}
+	}
+	ctx.JSON(http.StatusOK, resp)
+}
+
+func (l *Limiter) adminUnban(ctx *gin.Context) {
+	if _, ok := BucketAs[Banner](l.bucket); !ok {
+		adminNotImplemented(ctx)
+		return
+	}
+
+	key := ctx.Param("key")
+	if err := l.Unban(requestContext(ctx), key); err != nil {
+		l.adminFailure(ctx, err)
+		return
+	}
+	l.logger.Info("key unbanned by admin", F("key", l.StorageKey(key)))
+	ctx.Status(http.StatusNoContent)
+}
+
+func (l *Limiter) adminStats(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, l.Stats())
+}
+
+func (l *Limiter) adminExport(ctx *gin.Context) {
+	if _, ok := BucketAs[Scanner](l.bucket); !ok {
+		adminNotImplemented(ctx)
+		return
+	}
+
+	ctx.Header("Content-Type", "application/x-ndjson")
+	ctx.Status(http.StatusOK)
+	if err := l.Export(requestContext(ctx), ctx.Writer); err != nil {
+		// status is already sent, client gets truncated snapshot
+		l.logger.Error("admin request failed", F("error", err), F("path", ctx.FullPath()))
+	}
+}
+
+func (l *Limiter) adminImport(ctx *gin.Context) {
+	if _, ok := BucketAs[Restorer](l.bucket); !ok {
+		adminNotImplemented(ctx)
+		return
+	}
+
+	if err := l.Import(requestContext(ctx), ctx.Request.Body); err != nil {
+		l.adminFailure(ctx, err)
+		return
+	}
+	l.logger.Info("keys imported by admin")
+	ctx.Status(http.StatusNoContent)
+}
+
+func (l *Limiter) adminFlags(ctx *gin.Context) {
+	if _, ok := BucketAs[FlagStore](l.bucket); !ok {
+		adminNotImplemented(ctx)
+		return
+	}
+
+	flags, err := l.Flags(requestContext(ctx))
+	if err != nil {
+		l.adminFailure(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, flags)
+}
+
+func (l *Limiter) adminSetFlags(ctx *gin.Context) {
+	if _, ok := BucketAs[FlagStore](l.bucket); !ok {
+		adminNotImplemented(ctx)
+		return
+	}
+
+	var flags Flags
+	if err := json.NewDecoder(ctx.Request.Body).Decode(&flags); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, adminError{Error: err.Error()})
+		return
+	}
+	if err := l.SetFlags(requestContext(ctx), flags); err != nil {
+		l.adminFailure(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (l *Limiter) adminFailure(ctx *gin.Context, err error) {
+	l.logger.Error("admin request failed", F("error", err), F("path", ctx.FullPath()))
+	ctx.AbortWithStatusJSON(http.StatusInternalServerError, adminError{Error: err.Error()})
+}
+
+func adminNotImplemented(ctx *gin.Context) {
+	ctx.AbortWithStatusJSON(http.StatusNotImplemented, adminError{Error: "not supported by bucket"})
+}
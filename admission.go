@@ -0,0 +1,191 @@
+package gincage
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+var (
+	// Default rejection ratio of key enabling admission filter
+	DefaultAdmissionThreshold = 0.9
+	// Default min requests of key checked by storage before filter is enabled
+	DefaultAdmissionMinRequests = 20
+	// Default window of rejection counters
+	DefaultAdmissionWindow = time.Duration(10 * time.Second)
+	// Default max share of requests rejected locally
+	DefaultAdmissionMaxReject = 0.9
+	// Default max count of keys tracked by admission filter
+	DefaultAdmissionMaxKeys = 10000
+)
+
+// AdmissionPolicy: local pre-filter for keys rejected most of the time.
+//
+// When storage rejects most requests of key, part of its next requests
+// is rejected locally without storage calls, so single abusive key
+// can't hammer storage. Other requests are still checked by storage,
+// so recovery of key is noticed
+type AdmissionPolicy struct {
+	// Rejection ratio of key (0..1) enabling filter.
+	// If <= 0, uses DefaultAdmissionThreshold
+	Threshold float64
+	// Min requests of key checked by storage in window before filter
+	// is enabled. If <= 0, uses DefaultAdmissionMinRequests
+	MinRequests int
+	// Window of counters. Ratio is counted over current and previous
+	// windows. If <= 0, uses DefaultAdmissionWindow
+	Window time.Duration
+	// Max share of requests rejected locally (0..1), request is rejected
+	// with probability of rejection ratio cut by MaxReject.
+	// If <= 0, uses DefaultAdmissionMaxReject
+	MaxReject float64
+	// Max count of tracked keys, new keys aren't filtered when it's reached.
+	// If <= 0, uses DefaultAdmissionMaxKeys
+	MaxKeys int
+}
+
+// AdmissionMetrics can be implemented by Metrics to count
+// requests rejected by admission filter
+type AdmissionMetrics interface {
+	AdmissionRejected()
+}
+
+// Enables admission filter rejecting requests of mostly rejected keys
+// locally, see AdmissionPolicy
+func WithAdmissionFilter(p AdmissionPolicy) Option {
+	return func(l *Limiter) {
+		if p.Threshold <= 0 {
+			p.Threshold = DefaultAdmissionThreshold
+		}
+		if p.MinRequests <= 0 {
+			p.MinRequests = DefaultAdmissionMinRequests
+		}
+		if p.Window <= 0 {
+			p.Window = DefaultAdmissionWindow
+		}
+		if p.MaxReject <= 0 {
+			p.MaxReject = DefaultAdmissionMaxReject
+		}
+		if p.MaxKeys <= 0 {
+			p.MaxKeys = DefaultAdmissionMaxKeys
+		}
+		l.admission = &admissionFilter{
+			policy: p,
+			keys:   make(map[string]*admissionCounter),
+		}
+	}
+}
+
+type admissionFilter struct {
+	policy AdmissionPolicy
+
+	mu   sync.Mutex
+	keys map[string]*admissionCounter
+}
+
+// Requests of key checked by storage in current and previous windows
+type admissionCounter struct {
+	start              time.Time
+	requests, rejected int
+	prevRequests       int
+	prevRejected       int
+}
+
+// Moves counters of c to window of now
+func (c *admissionCounter) roll(now time.Time, window time.Duration) {
+	elapsed := now.Sub(c.start)
+	if elapsed < window {
+		return
+	}
+	if elapsed < 2*window {
+		c.prevRequests, c.prevRejected = c.requests, c.rejected
+	} else {
+		c.prevRequests, c.prevRejected = 0, 0
+	}
+	c.requests, c.rejected = 0, 0
+	c.start = now.Truncate(window)
+}
+
+// Returns true if request of key should be rejected locally
+func (f *admissionFilter) reject(key string) bool {
+	if f == nil {
+		return false
+	}
+	f.mu.Lock()
+	c, ok := f.keys[key]
+	if !ok {
+		f.mu.Unlock()
+		return false
+	}
+	c.roll(time.Now(), f.policy.Window)
+	requests := c.requests + c.prevRequests
+	rejected := c.rejected + c.prevRejected
+	f.mu.Unlock()
+
+	if requests < f.policy.MinRequests {
+		return false
+	}
+	ratio := float64(rejected) / float64(requests)
+	if ratio < f.policy.Threshold {
+		return false
+	}
+	return rand.Float64() < min(ratio, f.policy.MaxReject)
+}
+
+// Records storage verdict of request of key
+func (f *admissionFilter) record(key string, limited bool) {
+	if f == nil {
+		return
+	}
+	now := time.Now()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.keys[key]
+	if !ok {
+		if !limited {
+			// keys are tracked from first rejection
+			return
+		}
+		if len(f.keys) >= f.policy.MaxKeys && !f.sweep(now) {
+			return
+		}
+		c = &admissionCounter{start: now.Truncate(f.policy.Window)}
+		f.keys[key] = c
+	}
+	c.roll(now, f.policy.Window)
+	c.requests++
+	if limited {
+		c.rejected++
+	}
+}
+
+// Drops keys without requests in last two windows.
+// Returns true if keys were dropped. Should be called with lock held
+func (f *admissionFilter) sweep(now time.Time) bool {
+	n := len(f.keys)
+	for key, c := range f.keys {
+		if now.Sub(c.start) >= 2*f.policy.Window {
+			delete(f.keys, key)
+		}
+	}
+	return len(f.keys) < n
+}
+
+// Rejects request of key filtered by admission filter
+func (l *Limiter) admissionRejected(req Request, opts WalkOptions) Decision {
+	l.rejected(req)
+	l.stats.admission.Add(1)
+	if m, ok := l.metrics.(AdmissionMetrics); ok {
+		m.AdmissionRejected()
+	}
+	retryAfter := opts.Rate.Refill
+	if r, ok := BucketAs[RefillIntervaler](l.bucket); ok && retryAfter <= 0 {
+		retryAfter = r.RefillInterval()
+	}
+	return Decision{
+		Err:        &LimitExceededError{Key: opts.Key, Limit: opts.Rate.Capacity},
+		RetryAfter: retryAfter,
+		Limit:      opts.Rate.Capacity,
+	}
+}
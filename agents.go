@@ -0,0 +1,104 @@
+package gincage
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// AgentRule: limit of clients with matching User-Agent (scrapers,
+// headless browsers, clients without User-Agent, ...)
+type AgentRule struct {
+	// Rule name reported in metrics and stats.
+	// If empty, "agent_rules[i]" is used
+	Name string `json:"name,omitempty"`
+	// Case-insensitive substrings of User-Agent ("curl/", "python-requests")
+	// or regular expressions with "re:" prefix
+	Patterns []string `json:"patterns,omitempty"`
+	// Matches requests without User-Agent
+	Missing bool `json:"missing,omitempty"`
+	// Rejects matching requests without storage calls
+	Deny bool `json:"deny,omitempty"`
+	// Limit of matching clients. Zero fields are taken from geo rules,
+	// key limits and plan. Route rules override it on their routes
+	Rate Rate `json:"rate"`
+
+	// lower-cased Patterns without "re:" prefix
+	substrings []string
+	res        []*regexp.Regexp
+}
+
+// AgentRuleMetrics can be implemented by Metrics to count
+// requests matched by agent rules
+type AgentRuleMetrics interface {
+	AgentRuleMatched(rule string, denied bool)
+}
+
+// Returns first agent rule matching user agent
+func (c *Config) matchAgent(ua string) (AgentRule, bool) {
+	for _, r := range c.AgentRules {
+		if r.match(ua) {
+			return r, true
+		}
+	}
+	return AgentRule{}, false
+}
+
+// Counts request matched by agent rule
+func (l *Limiter) agentMatched(r AgentRule) {
+	l.stats.agentRule(r.Name)
+	if m, ok := l.metrics.(AgentRuleMetrics); ok {
+		m.AgentRuleMatched(r.Name, r.Deny)
+	}
+}
+
+func (r AgentRule) match(ua string) bool {
+	if ua == "" {
+		return r.Missing
+	}
+	lower := strings.ToLower(ua)
+	for _, s := range r.substrings {
+		if strings.Contains(lower, s) {
+			return true
+		}
+	}
+	for _, re := range r.res {
+		if re.MatchString(ua) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses patterns of r
+func (r *AgentRule) compile() error {
+	r.substrings, r.res = nil, nil
+	for i, p := range r.Patterns {
+		if expr, ok := strings.CutPrefix(p, regexpPrefix); ok {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return fmt.Errorf("patterns[%d]: %w", i, err)
+			}
+			r.res = append(r.res, re)
+			continue
+		}
+		if p == "" {
+			return fmt.Errorf("patterns[%d]: should not be empty", i)
+		}
+		r.substrings = append(r.substrings, strings.ToLower(p))
+	}
+	return nil
+}
+
+func (r AgentRule) validate() error {
+	if len(r.Patterns) == 0 && !r.Missing {
+		return fmt.Errorf("patterns or missing should be set")
+	}
+	if err := r.compile(); err != nil {
+		return err
+	}
+	if err := r.Rate.validate(); err != nil {
+		return fmt.Errorf("rate.%w", err)
+	}
+	return nil
+}
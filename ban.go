@@ -0,0 +1,161 @@
+package gincage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// Default count of rejections after which key is banned
+	DefaultBanThreshold = 50
+	// Default window for counting rejections
+	DefaultBanWindow = time.Duration(time.Minute)
+	// Default ban duration
+	DefaultBanDuration = time.Duration(15 * time.Minute)
+)
+
+// Banner can be implemented by Bucket to store bans and violations.
+//
+// Required by WithBanPolicy
+type Banner interface {
+	// Registers violation (rejected request) of key
+	// and returns count of violations in current window
+	AddViolation(ctx context.Context, key string, window time.Duration) (int, error)
+	// Bans key for d
+	Ban(ctx context.Context, key string, d time.Duration) error
+	// Removes ban of key
+	Unban(ctx context.Context, key string) error
+	// Returns time when ban of key ends. Returns zero time if key isn't banned
+	BannedUntil(ctx context.Context, key string) (time.Time, error)
+	// Returns list of banned keys
+	Bans(ctx context.Context) ([]BanInfo, error)
+}
+
+// BanInfo: banned key
+type BanInfo struct {
+	Key   string
+	Until time.Time
+}
+
+// BanPolicy: bans keys which are rejected too often.
+// Banned keys are rejected without touching tokens
+type BanPolicy struct {
+	// Count of rejections in Window after which key is banned.
+	// If <= 0, uses DefaultBanThreshold
+	Threshold int
+	// Window for counting rejections. If <= 0, uses DefaultBanWindow
+	Window time.Duration
+	// Ban duration. If <= 0, uses DefaultBanDuration
+	Duration time.Duration
+}
+
+// Enables bans. Bucket has to implement Banner, otherwise option is ignored
+func WithBanPolicy(p BanPolicy) Option {
+	return func(l *Limiter) {
+		if p.Threshold <= 0 {
+			p.Threshold = DefaultBanThreshold
+		}
+		if p.Window <= 0 {
+			p.Window = DefaultBanWindow
+		}
+		if p.Duration <= 0 {
+			p.Duration = DefaultBanDuration
+		}
+		l.banPolicy = &p
+	}
+}
+
+// Lifts ban and greylist penalty of client key.
+// Returns errors.ErrUnsupported if bucket doesn't implement Banner
+func (l *Limiter) Unban(ctx context.Context, key string) error {
+	banner, ok := BucketAs[Banner](l.bucket)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	key = l.StorageKey(key)
+	ctx, cancel := l.storageContext(ctx)
+	defer cancel()
+	if err := banner.Unban(ctx, key); err != nil {
+		return err
+	}
+	return banner.Unban(ctx, greylistedKeyPrefix+key)
+}
+
+// Returns banned client keys. Greylist penalties are listed as bans
+// of their keys, challenge marks (see WithChallenge) aren't listed.
+// Returns errors.ErrUnsupported if bucket doesn't implement Banner
+func (l *Limiter) Bans(ctx context.Context) ([]BanInfo, error) {
+	banner, ok := BucketAs[Banner](l.bucket)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	list, err := banner.Bans(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	bans := make([]BanInfo, 0, len(list))
+	idx := make(map[string]int, len(list))
+	for _, b := range list {
+		if strings.HasPrefix(b.Key, challengeKeyPrefix) || strings.HasPrefix(b.Key, verifiedKeyPrefix) {
+			continue
+		}
+		b.Key = strings.TrimPrefix(b.Key, greylistedKeyPrefix)
+		// key has ban and penalty, later one is listed
+		if i, ok := idx[b.Key]; ok {
+			if b.Until.After(bans[i].Until) {
+				bans[i].Until = b.Until
+			}
+			continue
+		}
+		idx[b.Key] = len(bans)
+		bans = append(bans, b)
+	}
+	return bans, nil
+}
+
+// Returns end of ban of key, or end of its greylist penalty if it's later.
+// penalty is true if end of greylist penalty is returned
+func (l *Limiter) bannedUntil(ctx context.Context, key string) (until time.Time, penalty bool, err error) {
+	until, err = l.banner.BannedUntil(ctx, key)
+	if err != nil || l.greylist == nil {
+		return until, false, err
+	}
+	p, err := l.banner.BannedUntil(ctx, greylistedKeyPrefix+key)
+	if err != nil || !p.After(until) {
+		return until, false, err
+	}
+	return p, true, nil
+}
+
+// Registers violation of key and bans it when threshold is reached.
+// Returns ban duration if key was banned
+func (l *Limiter) registerViolation(ctx context.Context, req Request) time.Duration {
+	key := req.Key
+	rctx, cancel := l.storageContext(ctx)
+	defer cancel()
+	n, err := l.banner.AddViolation(rctx, key, l.banPolicy.Window)
+	if err != nil {
+		l.log(req.RequestID).Error("failed to register violation", F("error", err), F("key", key))
+		return 0
+	}
+	if n < l.banPolicy.Threshold {
+		return 0
+	}
+
+	if err := l.banner.Ban(rctx, key, l.banPolicy.Duration); err != nil {
+		l.log(req.RequestID).Error("failed to ban key", F("error", err), F("key", key))
+		return 0
+	}
+	l.log(req.RequestID).Warn("key banned",
+		F("key", key),
+		F("violations", n),
+		F("duration", l.banPolicy.Duration),
+	)
+	e := l.newEvent(req)
+	e.BanDuration = l.banPolicy.Duration
+	l.hooks.emit(hookBan, e)
+	return l.banPolicy.Duration
+}
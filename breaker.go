@@ -0,0 +1,138 @@
+package gincage
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	// Default count of consecutive storage failures opening circuit
+	DefaultBreakerThreshold = 5
+	// Default time while storage isn't called after circuit opened
+	DefaultBreakerCooldown = time.Duration(10 * time.Second)
+)
+
+// CircuitBreaker: stops calling storage after consecutive failures.
+//
+// After Threshold consecutive failures storage isn't called for Cooldown
+// and requests are handled with fail policy. Then one request probes
+// storage: success closes circuit, failure opens it for another Cooldown
+type CircuitBreaker struct {
+	// If <= 0, uses DefaultBreakerThreshold
+	Threshold int
+	// If <= 0, uses DefaultBreakerCooldown
+	Cooldown time.Duration
+}
+
+// Wraps storage calls in circuit breaker
+func WithCircuitBreaker(cb CircuitBreaker) Option {
+	return func(l *Limiter) {
+		if cb.Threshold <= 0 {
+			cb.Threshold = DefaultBreakerThreshold
+		}
+		if cb.Cooldown <= 0 {
+			cb.Cooldown = DefaultBreakerCooldown
+		}
+		l.breaker = &circuitBreaker{
+			threshold: cb.Threshold,
+			cooldown:  cb.Cooldown,
+		}
+	}
+}
+
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	open     bool
+	openedAt time.Time
+	probing  bool
+	// count of probes, identifies probe released by release
+	probes uint64
+}
+
+// Returns true if storage can be called, and number of probe
+// if request probes storage (zero otherwise), which should be
+// passed to release. Nil breaker always allows calls
+func (b *circuitBreaker) allow() (bool, uint64) {
+	if b == nil {
+		return true, 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return true, 0
+	}
+	// only one request probes storage after cooldown
+	if b.probing || time.Since(b.openedAt) < b.cooldown {
+		return false, 0
+	}
+	b.probing = true
+	b.probes++
+	return true, b.probes
+}
+
+// Ends probe which returned before storage call decided it
+// (e.g. key is banned), so next request probes storage
+func (b *circuitBreaker) release(probe uint64) {
+	if b == nil || probe == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.probing && b.probes == probe {
+		b.probing = false
+	}
+}
+
+// Records successful storage call. Returns true if circuit was closed by it
+func (b *circuitBreaker) success() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasOpen := b.open
+	b.failures = 0
+	b.open = false
+	b.probing = false
+	return wasOpen
+}
+
+// Records failed storage call. Returns true if circuit was opened by it
+func (b *circuitBreaker) failure() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.open {
+		// failed probe
+		b.openedAt = time.Now()
+		b.probing = false
+		return false
+	}
+	if b.failures < b.threshold {
+		return false
+	}
+	b.open = true
+	b.openedAt = time.Now()
+	return true
+}
+
+func (b *circuitBreaker) isOpen() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open
+}
@@ -0,0 +1,270 @@
+package gincage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// Default redis channel of broadcast buckets
+	DefaultBroadcastChannel = "gincage-sync"
+	// Max count of updates in one message, keeps messages small
+	// enough for datagram transports
+	broadcastChunk = 256
+)
+
+var errNegativeTokens = errors.New("update has negative tokens")
+
+// Broadcaster: transport delivering messages to all instances
+// (redis pub/sub, NATS, ...)
+type Broadcaster interface {
+	Publish(ctx context.Context, msg []byte) error
+	// Calls handler for every message until ctx is canceled
+	Subscribe(ctx context.Context, handler func(msg []byte)) error
+}
+
+type redisBroadcaster struct {
+	core    *redis.Client
+	channel string
+}
+
+// Implements Broadcaster with redis pub/sub.
+// If channel is empty, DefaultBroadcastChannel is used
+func NewRedisBroadcaster(c *redis.Client, channel string) Broadcaster {
+	if channel == "" {
+		channel = DefaultBroadcastChannel
+	}
+	return redisBroadcaster{core: c, channel: channel}
+}
+
+func (b redisBroadcaster) Publish(ctx context.Context, msg []byte) error {
+	return b.core.Publish(ctx, b.channel, msg).Err()
+}
+
+func (b redisBroadcaster) Subscribe(ctx context.Context, handler func(msg []byte)) error {
+	sub := b.core.Subscribe(ctx, b.channel)
+	defer sub.Close()
+	// wait for subscription, so connection errors are returned
+	if _, err := sub.Receive(ctx); err != nil {
+		return err
+	}
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case m, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			handler([]byte(m.Payload))
+		}
+	}
+}
+
+// BroadcastConfigs: settings of broadcast bucket
+type BroadcastConfigs struct {
+	// Time between publishes of taken tokens. If <= 0, uses DefaultFlushInterval
+	FlushInterval time.Duration
+	// Logs failed publishes and bad messages. If nil, logs are discarded
+	Logger Logger
+}
+
+type broadcastMessage struct {
+	Instance string       `json:"instance"`
+	Updates  []SyncUpdate `json:"updates"`
+}
+
+type broadcastBucket struct {
+	mem *MemoryBucket
+	bc  Broadcaster
+	cfg BroadcastConfigs
+	id  string
+
+	mu      sync.Mutex
+	pending map[string]*SyncUpdate
+
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	// closed with bucket if broadcaster is owned by it
+	closer io.Closer
+}
+
+// Creates in-memory bucket with limits of cfg which shares taken tokens
+// with other instances through bc.
+//
+// Tokens are taken locally without storage calls. Taken tokens are published
+// every flush interval and taken from local buckets of other instances,
+// so instances converge on shared counts. Key can exceed its limit by tokens
+// taken on other instances during one flush interval.
+//
+// Close stops broadcasting, bc isn't closed
+func NewBroadcastBucket(cfg BucketConfigs, bc Broadcaster, bcfg BroadcastConfigs) Bucket {
+	return newBroadcastBucket(cfg, bc, bcfg)
+}
+
+func newBroadcastBucket(cfg BucketConfigs, bc Broadcaster, bcfg BroadcastConfigs) *broadcastBucket {
+	if bcfg.FlushInterval <= 0 {
+		bcfg.FlushInterval = DefaultFlushInterval
+	}
+	if bcfg.Logger == nil {
+		bcfg.Logger = NopLogger{}
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &broadcastBucket{
+		mem:     newMemoryBucket(cfg),
+		bc:      bc,
+		cfg:     bcfg,
+		id:      hex.EncodeToString(id),
+		pending: make(map[string]*SyncUpdate),
+		cancel:  cancel,
+	}
+	b.wg.Add(2)
+	go b.subscribe(ctx)
+	go b.run(ctx)
+	return b
+}
+
+// Returns underlying memory bucket
+func (b *broadcastBucket) Unwrap() Bucket {
+	return b.mem
+}
+
+// Takes n tokens of key locally if awailable
+func (b *broadcastBucket) Take(ctx context.Context, key string, n int) (Result, error) {
+	res, err := b.mem.Take(ctx, key, n)
+	if err != nil || !res.Allowed {
+		return res, err
+	}
+
+	opts, _ := WalkOptionsFromContext(ctx)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	u, ok := b.pending[key]
+	if !ok {
+		u = &SyncUpdate{Object: key, Rate: opts.Rate}
+		b.pending[key] = u
+	}
+	u.Tokens += takeCount(n)
+	u.Timestamp = b.mem.clock.Now()
+	return res, nil
+}
+
+// Publishes taken tokens and stops broadcasting
+func (b *broadcastBucket) Close() error {
+	var err error
+	b.closeOnce.Do(func() {
+		b.cancel()
+		b.wg.Wait()
+		if b.closer != nil {
+			err = b.closer.Close()
+		}
+	})
+	return errors.Join(err, b.mem.Close())
+}
+
+func (b *broadcastBucket) run(ctx context.Context) {
+	defer b.wg.Done()
+
+	t := time.NewTicker(b.cfg.FlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			b.publish(ctx)
+		case <-ctx.Done():
+			b.publish(context.WithoutCancel(ctx))
+			return
+		}
+	}
+}
+
+// Publishes tokens taken since last publish
+func (b *broadcastBucket) Flush(ctx context.Context) error {
+	return b.publish(ctx)
+}
+
+// Publishes tokens taken since last publish, publish takes at most flush interval
+func (b *broadcastBucket) publish(ctx context.Context) error {
+	b.mu.Lock()
+	updates := make([]SyncUpdate, 0, len(b.pending))
+	for _, u := range b.pending {
+		updates = append(updates, *u)
+	}
+	clear(b.pending)
+	b.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, b.cfg.FlushInterval)
+	defer cancel()
+	var errs []error
+	for len(updates) > 0 {
+		n := min(len(updates), broadcastChunk)
+		msg := broadcastMessage{Instance: b.id, Updates: updates[:n]}
+		updates = updates[n:]
+
+		data, err := json.Marshal(msg)
+		if err != nil {
+			b.cfg.Logger.Error("failed to encode taken tokens", F("error", err))
+			return err
+		}
+		if err := b.bc.Publish(ctx, data); err != nil {
+			// other instances miss these tokens, it is fine for approximate limits
+			b.cfg.Logger.Error("failed to publish taken tokens", F("error", err), F("keys", n))
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Takes tokens published by other instances until ctx is canceled
+func (b *broadcastBucket) subscribe(ctx context.Context) {
+	defer b.wg.Done()
+
+	for {
+		err := b.bc.Subscribe(ctx, func(data []byte) {
+			var msg broadcastMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				b.cfg.Logger.Warn("bad broadcast message", F("error", err))
+				return
+			}
+			if msg.Instance == b.id {
+				return
+			}
+			// negative tokens would refill keys of other instances
+			if slices.ContainsFunc(msg.Updates, func(u SyncUpdate) bool { return u.Tokens < 0 }) {
+				b.cfg.Logger.Warn("bad broadcast message", F("error", errNegativeTokens))
+				return
+			}
+			b.mem.Sync(ctx, msg.Updates)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			b.cfg.Logger.Error("broadcast subscription failed", F("error", err))
+		}
+
+		// resubscribe after pause
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(b.cfg.FlushInterval):
+		}
+	}
+}
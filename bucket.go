@@ -9,14 +9,13 @@ package gincage
 
 import (
 	"context"
-	"errors"
-	"net"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand/v2"
 	"strconv"
 	"strings"
 	"time"
-
-	"github.com/gin-gonic/gin"
-	"github.com/redis/go-redis/v9"
 )
 
 var (
@@ -26,29 +25,378 @@ var (
 	DefaultTokensAppendDuration = time.Duration(10 * time.Second)
 	// Default time for tokens exist in storage
 	DefaultTokensExist = time.Duration(30 * time.Minute)
+	// Default max count of transaction retries on concurrent updates
+	DefaultMaxRetries = 10
 )
 
+// SyncUpdate: tokens taken from key locally since last sync
 type SyncUpdate struct {
-	Object    string
-	Tokens    int
+	// Key
+	Object string
+	// Count of taken tokens, negative count returns tokens
+	Tokens int
+	// Time of last take
 	Timestamp time.Time
+	// Limit of key. Zero fields are taken from bucket configs
+	Rate Rate
+}
+
+// Syncer can be implemented by Bucket to apply tokens taken by
+// local caches (see NewWriteBehindBucket). Tokens of key don't go below zero,
+// returned tokens don't go above capacity.
+// Returns states of keys after update in order of updates
+type Syncer interface {
+	Sync(ctx context.Context, updates []SyncUpdate) ([]KeyState, error)
 }
 
-// Bucket: simple collection of ips with their awailable tokens.
+// Bucket: simple collection of keys (ips, users, ...) with their awailable tokens.
 //
 // Should be implemented by real storage under the hood.
-// 
+//
 // Every Bucket implementation have to be closed after use
 type Bucket interface {
-	// Try to get token and walk through.
-	// If no tokens awailable or error occured while connecting to bucket, returns (false, error).
-	// Otherwise returns (true, nil).
-	Walk(ctx *gin.Context) error
+	// Takes n tokens of key (one if n <= 0). If key has fewer tokens,
+	// nothing is taken and result isn't allowed.
+	// Error is returned only if storage failed.
+	// Limit of key is taken from WalkOptions of ctx, if they are attached.
+	// Buckets ignoring WalkOptions.Reserve are checked by limiter with Result.Remaining
+	Take(ctx context.Context, key string, n int) (Result, error)
 
 	// Closes connection to bucket
 	Close() error
 }
 
+// Result: outcome of Bucket.Take
+type Result struct {
+	// True if tokens were taken
+	Allowed bool
+	// Tokens left in bucket of key. -1 if unknown
+	Remaining int
+	// Capacity of key. Zero if unknown
+	Limit int
+	// Time till next token is appended if result isn't allowed. Zero if unknown
+	RetryAfter time.Duration
+}
+
+// Returns allowed result with tokens left
+func allowed(rate Rate, tokens int) Result {
+	return Result{Allowed: true, Remaining: tokens, Limit: rate.Capacity}
+}
+
+// Returns denied result of key having tokens refilled at t
+func denied(rate Rate, tokens int, t, now time.Time) Result {
+	r := Result{Remaining: max(tokens, 0), Limit: rate.Capacity}
+	if rate.Refill > 0 {
+		r.RetryAfter = max(t.Add(rate.Refill).Sub(now), 0)
+	}
+	return r
+}
+
+// Returns count of tokens to take, at least one
+func takeCount(n int) int {
+	return max(n, 1)
+}
+
+// Rate: limit applied to keys of bucket
+type Rate struct {
+	// Max count of tokens
+	Capacity int
+	// Time after new token append
+	Refill time.Duration
+	// Time after object will expire
+	TTL time.Duration
+}
+
+// Returns rate of capacity tokens refilled at tokens per period,
+// e.g. RatePer(100, 50, time.Second) or RatePer(1, 0.5, time.Second).
+//
+// Refill is rounded to nanoseconds. Time left after appended tokens is kept
+// till next take, so fractional rates don't lose tokens.
+// If tokens <= 0, Refill is zero (taken from defaults)
+func RatePer(capacity int, tokens float64, per time.Duration) Rate {
+	r := Rate{Capacity: capacity}
+	if tokens > 0 {
+		r.Refill = max(time.Duration(math.Round(float64(per)/tokens)), 1)
+	}
+	return r
+}
+
+// Parses refill interval: duration ("20ms") or count of tokens per
+// period ("50/s", "0.5/s", "100/m", "3/500ms")
+func ParseRefill(s string) (time.Duration, error) {
+	num, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return time.ParseDuration(s)
+	}
+	tokens, err := strconv.ParseFloat(num, 64)
+	if err != nil || tokens <= 0 || math.IsInf(tokens, 0) {
+		return 0, fmt.Errorf("invalid count of tokens in %q", s)
+	}
+	if per != "" && (per[0] < '0' || per[0] > '9') && per[0] != '.' {
+		per = "1" + per
+	}
+	period, err := time.ParseDuration(per)
+	if err != nil {
+		return 0, err
+	}
+	if period <= 0 {
+		return 0, fmt.Errorf("invalid period in %q", s)
+	}
+	refill := time.Duration(math.Round(float64(period) / tokens))
+	if refill <= 0 {
+		return 0, fmt.Errorf("rate %q is too high", s)
+	}
+	return refill, nil
+}
+
+// Returns r with zero fields replaced by fields of def
+func (r Rate) withDefaults(def Rate) Rate {
+	if r.Capacity <= 0 {
+		r.Capacity = def.Capacity
+	}
+	if r.Refill <= 0 {
+		r.Refill = def.Refill
+	}
+	if r.TTL <= 0 {
+		r.TTL = def.TTL
+	}
+	return r
+}
+
+type rateJSON struct {
+	Capacity int    `json:"capacity,omitempty"`
+	Refill   string `json:"refill,omitempty"`
+	TTL      string `json:"ttl,omitempty"`
+}
+
+// Encodes durations as strings like "10s"
+func (r Rate) MarshalJSON() ([]byte, error) {
+	j := rateJSON{Capacity: r.Capacity}
+	if r.Refill != 0 {
+		j.Refill = r.Refill.String()
+	}
+	if r.TTL != 0 {
+		j.TTL = r.TTL.String()
+	}
+	return json.Marshal(j)
+}
+
+// Decodes durations from strings like "10s".
+// Refill can be set as rate like "50/s" (see ParseRefill)
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	var j rateJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	rate := Rate{Capacity: j.Capacity}
+	var err error
+	if j.Refill != "" {
+		if rate.Refill, err = ParseRefill(j.Refill); err != nil {
+			return err
+		}
+	}
+	if j.TTL != "" {
+		if rate.TTL, err = time.ParseDuration(j.TTL); err != nil {
+			return err
+		}
+	}
+	*r = rate
+	return nil
+}
+
+// KeyState: tokens of key at the moment
+type KeyState struct {
+	Key string
+	// Tokens awailable right now (with refill)
+	Tokens int
+	// Time of last tokens append
+	RefilledAt time.Time
+	// False if key isn't stored, such key has full capacity
+	Exists bool
+	// Time key expires unless tokens are taken again (see Rate.TTL).
+	// Zero if key isn't stored or bucket doesn't report it
+	ExpiresAt time.Time
+}
+
+// Peeker can be implemented by Bucket to inspect keys without taking tokens
+type Peeker interface {
+	Peek(ctx context.Context, key string) (KeyState, error)
+}
+
+// Resetter can be implemented by Bucket to restore full capacity of key
+type Resetter interface {
+	Reset(ctx context.Context, key string) error
+}
+
+// HealthChecker can be implemented by Bucket using remote storage
+// to check it answers, see Limiter.Healthy
+type HealthChecker interface {
+	// Returns error if storage can't be used now
+	Ping(ctx context.Context) error
+}
+
+// Crediter can be implemented by Bucket to grant extra tokens to keys,
+// e.g. paid burst packs or compensation by support
+type Crediter interface {
+	// Adds n (> 0) tokens to key on top of its tokens, even above capacity,
+	// and returns state of key. Tokens above capacity aren't refilled,
+	// they last until spent or until key expires.
+	// Rate of key is taken from walk options of ctx (see ContextWithWalkOptions)
+	Credit(ctx context.Context, key string, n int) (KeyState, error)
+}
+
+// Returns tokens of key after sync update taking n tokens (negative n
+// returns them). Returned tokens don't go above capacity, but tokens
+// credited above it are kept
+func syncTokens(tokens, n int, rate Rate) int {
+	if n < 0 {
+		return min(tokens-n, max(tokens, rate.Capacity))
+	}
+	return max(tokens-n, 0)
+}
+
+// Returns tokens of key with n credited tokens.
+// Full key starts refill interval anew, as refill of stored key does
+func credit(tokens int, t time.Time, n int, rate Rate, now time.Time) (int, time.Time) {
+	tokens += n
+	if tokens >= rate.Capacity {
+		t = now
+	}
+	return tokens, t
+}
+
+// Booker can be implemented by Bucket to book tokens ahead of time
+// (see Limiter.Reserve)
+type Booker interface {
+	// Takes n (> 0) tokens which key has by time at, so tokens of key
+	// go below zero if they are booked before refill. Returns time
+	// booked tokens may be used, not before at. If key doesn't have them
+	// by at, nothing is taken and *LimitExceededError is returned with
+	// time key has them (zero if n is above capacity).
+	// Rate of key is taken from walk options of ctx (see ContextWithWalkOptions)
+	Book(ctx context.Context, key string, n int, at time.Time) (time.Time, error)
+}
+
+// Books n tokens of key having tokens refilled at t. Returns tokens
+// left and time booked tokens may be used, or limit error
+// if key doesn't have n tokens by time at
+func book(key string, tokens int, t time.Time, n int, rate Rate, now, at time.Time) (int, time.Time, error) {
+	if at.Before(now) {
+		at = now
+	}
+	if projected, _ := refill(tokens, t, rate, at); projected < n {
+		e := &LimitExceededError{Key: key, Limit: rate.Capacity, Remaining: max(tokens, 0)}
+		if n <= rate.Capacity && rate.Refill > 0 {
+			e.Reset = t.Add(time.Duration(n-tokens) * rate.Refill)
+		}
+		return tokens, time.Time{}, e
+	}
+	tokens -= n
+	// tokens booked before refill may be used when key is back to zero
+	if ready := t.Add(time.Duration(-tokens) * rate.Refill); tokens < 0 && ready.After(at) {
+		at = ready
+	}
+	return tokens, at, nil
+}
+
+// RateReporter can be implemented by Bucket to report its limits
+type RateReporter interface {
+	Rate() Rate
+}
+
+// KeyedCost: count of tokens taken from key
+type KeyedCost struct {
+	Key string
+	// If <= 0, one token is taken
+	Cost int
+	// Limit of key. Zero fields are taken from bucket configs
+	Rate Rate
+}
+
+// BatchWalker can be implemented by Bucket to take tokens of several
+// keys at once (e.g. per-ip and global limit of request).
+// Tokens are taken from all keys or from none of them
+type BatchWalker interface {
+	WalkMany(ctx context.Context, keys []KeyedCost) error
+}
+
+// Merges costs of same keys, so every key is taken once.
+// Rate of first occurrence is used
+func mergeKeyedCosts(keys []KeyedCost) []KeyedCost {
+	merged := make([]KeyedCost, 0, len(keys))
+	idx := make(map[string]int, len(keys))
+	for _, k := range keys {
+		k.Cost = max(k.Cost, 1)
+		if i, ok := idx[k.Key]; ok {
+			merged[i].Cost += k.Cost
+			continue
+		}
+		idx[k.Key] = len(merged)
+		merged = append(merged, k)
+	}
+	return merged
+}
+
+// BucketWrapper can be implemented by Bucket decorators
+// to give access to underlying bucket
+type BucketWrapper interface {
+	Unwrap() Bucket
+}
+
+// Looks for T in b and buckets wrapped by it.
+//
+// Should be used instead of type assertion to find optional
+// bucket extensions (KeyCounter, RefillIntervaler, ...)
+func BucketAs[T any](b Bucket) (T, bool) {
+	for b != nil {
+		if t, ok := b.(T); ok {
+			return t, true
+		}
+		w, ok := b.(BucketWrapper)
+		if !ok {
+			break
+		}
+		b = w.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// WalkOptions: per-request parameters passed by limiter to bucket
+// through request context
+type WalkOptions struct {
+	// Storage key of request, passed by limiter to Walk
+	Key string
+	// Limit of key. Zero fields are taken from bucket configs
+	Rate Rate
+	// Share of capacity (0..1) request can't take, so it's left for
+	// requests of higher priority, see Config.Priorities
+	Reserve float64
+	// Tokens taken by request. If <= 0, one token is taken
+	Cost int
+}
+
+// Returns count of tokens of rate request can't take
+func (o WalkOptions) reserved(rate Rate) int {
+	if o.Reserve <= 0 {
+		return 0
+	}
+	return int(o.Reserve * float64(rate.Capacity))
+}
+
+type walkOptionsKey struct{}
+
+// Returns copy of ctx carrying o
+func ContextWithWalkOptions(ctx context.Context, o WalkOptions) context.Context {
+	return context.WithValue(ctx, walkOptionsKey{}, o)
+}
+
+// Returns options attached to ctx
+func WalkOptionsFromContext(ctx context.Context) (WalkOptions, bool) {
+	o, ok := ctx.Value(walkOptionsKey{}).(WalkOptions)
+	return o, ok
+}
+
 type BucketConfigs struct {
 	// Bucket host
 	Host string
@@ -57,152 +405,127 @@ type BucketConfigs struct {
 	// Bucket network (tcp, udp). Omit empty for tcp
 	Network string
 
+	// Username for ACL authentication. Omit empty for default user
+	Username string
+	// Password of user
+	Password string
+	// Database index
+	DB int
+
+	// Max count of connections. If <= 0, uses 10 per CPU
+	PoolSize int
+	// Count of idle connections kept open
+	MinIdleConns int
+	// Timeout for establishing connection. If <= 0, uses 5s
+	DialTimeout time.Duration
+	// Timeout for socket reads. If <= 0, uses 3s
+	ReadTimeout time.Duration
+	// Timeout for socket writes. If <= 0, uses ReadTimeout
+	WriteTimeout time.Duration
+
+	// Enables TLS if not nil
+	TLS *TLSConfigs
+	// Read-only replica serving Peek, ActiveKeys, Scan and Bans
+	// (admin and stats queries), so they don't load primary.
+	// Takes and ban checks of requests always go to primary
+	Replica *ReplicaConfigs
+
 	// Max count of tokens. If <= 0, uses MaxTokensCapDefault
 	Capability int
 	// Time after object will expire. If <= 0, uses DurationDefault
 	TokensExist time.Duration
 	// Time after new tokens append. If <= 0, uses NewTokenAppendDefault
 	TokensAppendDuration time.Duration
+	// Max random time added to TokensExist on every write, so keys
+	// created at the same time don't expire at the same time
+	TTLJitter time.Duration
+	// Max count of transaction retries when key is updated concurrently.
+	// If <= 0, uses DefaultMaxRetries
+	MaxRetries int
+	// Source of time for refill math. If nil, uses SystemClock
+	Clock Clock
+	// Count of lock-striped shards of in-memory bucket, rounded up
+	// to power of two. If <= 0, uses 4 * GOMAXPROCS
+	Shards int
+	// Max count of keys kept by in-memory bucket, approximately least
+	// recently used keys are evicted to make room for new ones
+	// (see EvictionCounter). Bound is split between shards.
+	// If <= 0, count of keys isn't bounded
+	MaxKeys int
+	// Encoding of key state stored as single value (TextCodec, BinaryCodec).
+	// If nil, state is stored in hash fields
+	Codec Codec
+	// Schema version of written keys (SchemaV1, SchemaV2, ...).
+	// Keys of all known versions are read. If <= 0, uses StorageSchema (SchemaV1).
+	// Set newer version after every instance runs release reading it,
+	// so instances of old release don't get keys they can't read
+	Schema int
+	// Registers take script of redis bucket as redis function (redis 7+)
+	// on first batch walk and calls it with FCALL. Falls back to EVALSHA
+	// if server doesn't support functions
+	Functions bool
 }
 
-type RedisBucket struct {
-	core *redis.Client
+// ReplicaConfigs: address of read-only replica. Credentials, database,
+// pool, timeouts and TLS are taken from primary settings
+type ReplicaConfigs struct {
+	Host string
+	Port int
+}
 
-	cap             int
-	dur             time.Duration
-	tokenAppendTime time.Duration
+// TLSConfigs: TLS settings of connection to bucket
+type TLSConfigs struct {
+	// PEM file with CA certificates. If empty, system pool is used
+	CAFile string
+	// PEM files with client certificate and its key, for mutual TLS
+	CertFile string
+	KeyFile  string
+	// Server name checked in certificate (SNI). If empty, Host is used
+	ServerName string
+	// Skips certificate verification. Use only for testing
+	InsecureSkipVerify bool
 }
 
-// Implements Bucket interface and allows to use redis as tokens bucket.
-//
-// Allows to use existing redis connection
-func NewRedisBucketWithClient(cfg BucketConfigs, c *redis.Client) Bucket {
-	if cfg.Capability <= 0 {
-		cfg.Capability = DefaultTokensCap
+// Returns ttl with random time up to j added
+func withJitter(ttl, j time.Duration) time.Duration {
+	if j <= 0 {
+		return ttl
 	}
+	return ttl + rand.N(j)
+}
 
-	if cfg.TokensExist <= 0 {
-		cfg.TokensExist = DefaultTokensExist
+// Appends tokens earned from t till now
+func refill(tokens int, t time.Time, rate Rate, now time.Time) (int, time.Time) {
+	// t was written by instance with clock ahead of ours
+	if t.After(now) {
+		t = now
 	}
+	// if we can append tokens
+	if tokens < rate.Capacity {
+		p := now.Sub(t)
+		// if we can append tokens right now
+		if p >= rate.Refill {
+			// check how many tokens we can add to bucket
+			add := int(p / rate.Refill)
 
-	if cfg.TokensAppendDuration <= 0 {
-		cfg.TokensAppendDuration = DefaultTokensAppendDuration
-	}
+			// get number of tokens we can add to bucket under cap
+			add = min(add, rate.Capacity-tokens)
 
-	return &RedisBucket{
-		core:            c,
-		cap:             cfg.Capability,
-		dur:             cfg.TokensExist,
-		tokenAppendTime: cfg.TokensAppendDuration,
-	}
-}
+			// add tokens
+			tokens += add
 
-// Implements Bucket interface and allows to use redis as tokens bucket.
-//
-// Creates new redis client and returns error if it was broken
-func NewRedisBucket(cfg BucketConfigs) (Bucket, error) {
-	if cfg.Network == "" {
-		cfg.Network = "tcp"
-	}
-	c := redis.NewClient(&redis.Options{
-		Network: cfg.Network,
-		Addr:    net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
-	})
-	if err := c.Ping(context.Background()).Err(); err != nil {
-		return nil, err
-	}
-
-	return NewRedisBucketWithClient(cfg, c), nil
-}
-
-// Closes connection to redis
-func (b RedisBucket) Close() error {
-	return b.core.Close()
-}
-
-// Try to get token and walk through.
-// If no tokens awailable or error occured while connecting to redis, returns (false, error).
-// Otherwise returns (true, nil).
-func (b RedisBucket) Walk(ctx *gin.Context) error {
-	if b.core == nil {
-		return errors.New("redis core is nil")
-	}
-
-	ip := ctx.ClientIP()
-	var tokens int
-	var t time.Time
-	for {
-		err := b.core.Watch(ctx, func(tx *redis.Tx) error {
-			r, err := tx.Get(ctx, "gincage:"+ip).Result()
-			if err != nil {
-				if !errors.Is(err, redis.Nil) {
-					return err
-				}
-				tokens = b.cap
-				t = time.Now()
+			// time shift
+			//
+			// we try to leave extra time when we have it,
+			// but also avoid situations where there is too much time left
+			// when we fulfill tokens.
+			if tokens == rate.Capacity {
+				t = now
 			} else {
-				d := strings.Split(r, "|")
-				if len(d) != 2 {
-					return ErrBadSyntaxInStorage
-				}
-				tokens, err = strconv.Atoi(d[0])
-				if err != nil {
-					return err
-				}
-
-				t, err = time.Parse(time.RFC3339, d[1])
-				if err != nil {
-					return err
-				}
-
-				// if we can append tokens
-				if tokens < b.cap {
-					p := time.Since(t)
-					// if we can append tokens right now
-					if p >= b.tokenAppendTime {
-						// check how many tokens we can add to bucket
-						add := int(p / b.tokenAppendTime)
-
-						// get number of tokens we can add to bucket under cap
-						add = min(add, b.cap-tokens)
-
-						// add tokens
-						tokens += add
-
-						// time shift
-						//
-						// we try to leave extra time when we have it,
-						// but also avoid situations where there is too much time left
-						// when we fulfill tokens.
-						if tokens == b.cap {
-							t = time.Now()
-						} else {
-							t = t.Add(time.Duration(add) * b.tokenAppendTime)
-						}
-
-					}
-				}
-
-				if tokens <= 0 {
-					return ErrNoTokensAwailable
-				}
+				t = t.Add(time.Duration(add) * rate.Refill)
 			}
 
-			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
-				return pipe.Set(ctx, "gincage:"+ip, strconv.Itoa(tokens-1)+"|"+t.Format(time.RFC3339), b.dur).Err()
-			})
-
-			return err
-		}, "gincage:"+ip)
-
-		if err != nil {
-			if !errors.Is(err, redis.TxFailedErr) {
-				return err
-			}
-			continue
 		}
-		break
 	}
-
-	return nil
+	return tokens, t
 }
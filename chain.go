@@ -0,0 +1,151 @@
+package gincage
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LimiterChain: independent limiters checked as one middleware, see Chain
+type LimiterChain struct {
+	limiters []*Limiter
+}
+
+// Combines independent limiters (e.g. per-ip burst, per-user daily quota
+// and global limit) into one middleware. Every limiter checks request
+// with its own key, options and bucket.
+//
+// Limiters are checked in order, first rejection stops the chain:
+// tokens taken by previous limiters are returned (if their buckets
+// implement Syncer) and response is rendered by rejecting limiter.
+// Allowed responses get combined headers: RateLimit-Limit and
+// RateLimit-Remaining of limiter with fewest tokens left
+// and policies of all limiters
+func Chain(limiters ...*Limiter) *LimiterChain {
+	return &LimiterChain{limiters: limiters}
+}
+
+// Returns limiters of chain in order of checks
+func (c *LimiterChain) Limiters() []*Limiter {
+	return c.limiters
+}
+
+// Decision of one limiter of chain
+type chainedDecision struct {
+	limiter *Limiter
+	req     Request
+	d       Decision
+}
+
+// Checks request with limiters until one rejects it. request builds
+// Request for limiter, false skips limiter. Returns decisions of
+// limiters which checked request, the last one rejects it if result is false
+func (c *LimiterChain) allow(ctx context.Context, request func(l *Limiter) (Request, bool)) ([]chainedDecision, bool) {
+	checked := make([]chainedDecision, 0, len(c.limiters))
+	for _, l := range c.limiters {
+		req, ok := request(l)
+		if !ok {
+			continue
+		}
+		d := l.Allow(ctx, req)
+		checked = append(checked, chainedDecision{limiter: l, req: req, d: d})
+		if !d.Allowed {
+			refund(ctx, checked[:len(checked)-1])
+			return checked, false
+		}
+	}
+	return checked, true
+}
+
+// Returns tokens taken by limiters for request rejected by later limiter
+func refund(ctx context.Context, checked []chainedDecision) {
+	for _, c := range checked {
+		if err := c.limiter.Adjust(ctx, c.d, 0); err != nil {
+			c.limiter.logger.Error("failed to return tokens of request rejected by chain", F("key", c.req.Key), F("error", err))
+		}
+	}
+}
+
+// Combines headers of allowed decisions
+func chainHeader(checked []chainedDecision) http.Header {
+	h := http.Header{}
+	var policies []string
+	var tightest *chainedDecision
+	for i := range checked {
+		c := &checked[i]
+		lh := c.limiter.Header(c.d)
+		if lh.Get(RateLimitLimitHeader) != "" && (tightest == nil || c.d.Remaining < tightest.d.Remaining) {
+			tightest = c
+		}
+		policies = append(policies, lh.Values(RateLimitPolicyHeader)...)
+		lh.Del(RateLimitLimitHeader)
+		lh.Del(RateLimitRemainingHeader)
+		lh.Del(RateLimitPolicyHeader)
+		for k, v := range lh {
+			h[k] = v
+		}
+	}
+	if tightest != nil {
+		lh := tightest.limiter.Header(tightest.d)
+		h.Set(RateLimitLimitHeader, lh.Get(RateLimitLimitHeader))
+		h.Set(RateLimitRemainingHeader, lh.Get(RateLimitRemainingHeader))
+	}
+	if len(policies) > 0 {
+		h.Set(RateLimitPolicyHeader, strings.Join(policies, ", "))
+	}
+	return h
+}
+
+// Returns gin middleware checking requests with all limiters of chain,
+// see Chain. Final costs set with SetCost are reconciled by every limiter
+func (c *LimiterChain) WalkThrough() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		checked, ok := c.allow(requestContext(ctx), func(l *Limiter) (Request, bool) {
+			return l.ginRequest(ctx)
+		})
+		if !ok {
+			last := checked[len(checked)-1]
+			ctx.Abort()
+			last.limiter.Render(last.d).Write(ctx.Writer)
+			return
+		}
+		for k, v := range chainHeader(checked) {
+			ctx.Writer.Header()[k] = v
+		}
+
+		var taken bool
+		for _, c := range checked {
+			taken = taken || len(c.d.taken) > 0
+		}
+		if !taken {
+			return
+		}
+		ctx.Next()
+		for _, c := range checked {
+			if len(c.d.taken) > 0 {
+				c.limiter.settle(ctx, c.req, c.d)
+			}
+		}
+	}
+}
+
+// Returns net/http middleware checking requests with all limiters
+// of chain, see Chain
+func (c *LimiterChain) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checked, ok := c.allow(r.Context(), func(l *Limiter) (Request, bool) {
+			return l.httpRequest(r)
+		})
+		if !ok {
+			last := checked[len(checked)-1]
+			last.limiter.Render(last.d).Write(w)
+			return
+		}
+		for k, v := range chainHeader(checked) {
+			w.Header()[k] = v
+		}
+		next.ServeHTTP(w, r)
+	})
+}
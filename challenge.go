@@ -0,0 +1,136 @@
+package gincage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// Default count of rejections after which key is challenged
+	DefaultChallengeThreshold = 20
+	// Default window for counting rejections of challenge policy
+	DefaultChallengeWindow = time.Duration(time.Minute)
+	// Default time key stays challenged unless it's verified
+	DefaultChallengeDuration = time.Duration(time.Hour)
+	// Default time verified key isn't challenged again
+	DefaultChallengeVerified = time.Duration(24 * time.Hour)
+)
+
+// Prefixes of ban keys marking challenged and verified keys
+const (
+	challengeKeyPrefix = "challenge|"
+	verifiedKeyPrefix  = "verified|"
+)
+
+// ChallengeFunc: builds response challenging client (captcha page,
+// proof-of-work header, ...) of decision rejecting challenged key
+type ChallengeFunc func(d Decision) Response
+
+// ChallengePolicy: challenge instead of rejections for keys which are
+// rejected too often, e.g. humans behind shared ip.
+//
+// Challenged key gets challenge response for all its requests
+// until application verifies it with MarkVerified. States are stored
+// as bans of bucket with "challenge|" and "verified|" key prefixes,
+// so they are shared by instances
+type ChallengePolicy struct {
+	// Count of rejections in Window after which key is challenged.
+	// If <= 0, uses DefaultChallengeThreshold
+	Threshold int
+	// Window for counting rejections. If <= 0, uses DefaultChallengeWindow
+	Window time.Duration
+	// Time key stays challenged unless it's verified.
+	// If <= 0, uses DefaultChallengeDuration
+	Duration time.Duration
+	// Time verified key isn't challenged again, its requests are limited
+	// as usual. If <= 0, uses DefaultChallengeVerified
+	Verified time.Duration
+	// Builds challenge response. Required, policy is ignored without it
+	Challenge ChallengeFunc
+}
+
+// Enables challenges. Bucket has to implement Banner, otherwise option is ignored
+func WithChallenge(p ChallengePolicy) Option {
+	return func(l *Limiter) {
+		if p.Challenge == nil {
+			return
+		}
+		if p.Threshold <= 0 {
+			p.Threshold = DefaultChallengeThreshold
+		}
+		if p.Window <= 0 {
+			p.Window = DefaultChallengeWindow
+		}
+		if p.Duration <= 0 {
+			p.Duration = DefaultChallengeDuration
+		}
+		if p.Verified <= 0 {
+			p.Verified = DefaultChallengeVerified
+		}
+		l.challenge = &p
+	}
+}
+
+// Restores normal limits of client key which passed challenge,
+// so it isn't challenged for ChallengePolicy.Verified.
+// Returns errors.ErrUnsupported if challenges aren't enabled
+func (l *Limiter) MarkVerified(ctx context.Context, key string) error {
+	if l.challenge == nil {
+		return errors.ErrUnsupported
+	}
+	key = l.hashKey(key)
+	ctx, cancel := l.storageContext(ctx)
+	defer cancel()
+	if err := l.banner.Ban(ctx, verifiedKeyPrefix+key, l.challenge.Verified); err != nil {
+		return err
+	}
+	if err := l.banner.Unban(ctx, challengeKeyPrefix+key); err != nil {
+		return err
+	}
+	l.log(RequestIDFromContext(ctx)).Info("key verified", F("key", key))
+	return nil
+}
+
+// Returns time when challenge of key ends, zero time if key isn't challenged
+func (l *Limiter) challengedUntil(ctx context.Context, req Request) (time.Time, error) {
+	ctx, cancel := l.storageContext(ctx)
+	defer cancel()
+	return l.banner.BannedUntil(ctx, challengeKeyPrefix+req.Key)
+}
+
+// Registers rejection of key and challenges it when threshold is reached.
+// Returns true if key was challenged
+func (l *Limiter) registerChallenge(ctx context.Context, req Request) bool {
+	key := req.Key
+	rctx, cancel := l.storageContext(ctx)
+	defer cancel()
+	verified, err := l.banner.BannedUntil(rctx, verifiedKeyPrefix+key)
+	if err != nil {
+		l.log(req.RequestID).Error("failed to check verification", F("error", err), F("key", key))
+		return false
+	}
+	if !verified.IsZero() {
+		return false
+	}
+	n, err := l.banner.AddViolation(rctx, challengeKeyPrefix+key, l.challenge.Window)
+	if err != nil {
+		l.log(req.RequestID).Error("failed to register violation", F("error", err), F("key", key))
+		return false
+	}
+	if n < l.challenge.Threshold {
+		return false
+	}
+
+	if err := l.banner.Ban(rctx, challengeKeyPrefix+key, l.challenge.Duration); err != nil {
+		l.log(req.RequestID).Error("failed to challenge key", F("error", err), F("key", key))
+		return false
+	}
+	l.log(req.RequestID).Warn("key challenged",
+		F("key", key),
+		F("violations", n),
+		F("duration", l.challenge.Duration),
+	)
+	l.hooks.emit(hookChallenge, l.newEvent(req))
+	return true
+}
@@ -0,0 +1,29 @@
+package gincage
+
+import "time"
+
+// Clock: source of time used by buckets for refill math.
+// Can be replaced to write deterministic tests
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+}
+
+// SystemClock: Clock backed by time package. Default
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (SystemClock) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+// Returns c or SystemClock if c is nil
+func clockOrDefault(c Clock) Clock {
+	if c == nil {
+		return SystemClock{}
+	}
+	return c
+}
@@ -0,0 +1,69 @@
+package gincage
+
+import (
+	"context"
+	"errors"
+)
+
+// Flusher can be implemented by Bucket buffering taken tokens
+// (see NewWriteBehindBucket, NewBroadcastBucket) to send them on demand
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// Stops background goroutines of limiter (config watchers),
+// flushes tokens buffered by bucket and closes bucket, so taken tokens
+// aren't lost on shutdown.
+//
+// Goroutines and flush are awaited until ctx is done, bucket is closed anyway.
+// Limiter shouldn't be used after Close. Next calls return result of first one
+func (l *Limiter) Close(ctx context.Context) error {
+	l.closeOnce.Do(func() {
+		l.closeCancel()
+
+		var errs []error
+		done := make(chan struct{})
+		go func() {
+			l.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			errs = append(errs, ctx.Err())
+		}
+
+		if f, ok := BucketAs[Flusher](l.bucket); ok && ctx.Err() == nil {
+			if err := f.Flush(ctx); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		errs = append(errs, l.bucket.Close())
+		if l.fallback != nil {
+			errs = append(errs, l.fallback.Close())
+		}
+		if l.secondary != nil {
+			errs = append(errs, l.secondary.Close())
+		}
+		if c, ok := l.conns.semaphoreCloser(); ok {
+			errs = append(errs, c.Close())
+		}
+		l.closeErr = errors.Join(errs...)
+		l.logger.Info("limiter closed")
+	})
+	return l.closeErr
+}
+
+// Runs f in background goroutine awaited by Close.
+// ctx of f is canceled when limiter is closed
+func (l *Limiter) background(ctx context.Context, f func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(l.closeCtx, cancel)
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		defer stop()
+		defer cancel()
+		f(ctx)
+	}()
+}
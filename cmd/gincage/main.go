@@ -0,0 +1,284 @@
+// Command gincage operates buckets of limiter built from config file
+// (see gincage.LoadConfig), so operators don't need redis-cli and
+// knowledge of storage key format.
+//
+// Usage:
+//
+//	gincage -config gincage.yaml inspect <key>
+//	gincage -config gincage.yaml reset <key>
+//	gincage -config gincage.yaml credit <key> <tokens>
+//	gincage -config gincage.yaml top [n]
+//	gincage -config gincage.yaml ban <key> <duration>
+//	gincage -config gincage.yaml unban <key>
+//	gincage -config gincage.yaml bans
+//	gincage -config gincage.yaml config
+//	gincage -config gincage.yaml health
+//	gincage -config gincage.yaml flags ['{"maintenance": true}']
+//
+// Keys are storage keys of limiter: client key (ip by default),
+// prefixed with route path and "|" for route rules, e.g. "/login|10.0.0.1".
+// Client part of keys is hashed if config sets key_hash_secret
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	gincage "github.com/fyx1t/gin-cage"
+)
+
+const usage = `usage: gincage [-config path] [-timeout d] <command> [args]
+
+commands:
+  inspect <key>          tokens and ban of key
+  reset <key>            restores full capacity of key
+  credit <key> <tokens>  grants extra tokens to key
+  top [n]                n keys with fewest tokens left (default 10)
+  ban <key> <duration>   bans key
+  unban <key>            removes ban of key
+  bans                   banned keys
+  config                 effective limits
+  health                 checks storage, exits with 1 if it fails
+  flags [json]           prints flags or replaces them with json
+`
+
+var errUsage = errors.New("invalid arguments")
+
+func main() {
+	path := flag.String("config", "gincage.yaml", "config file (yaml or json)")
+	timeout := flag.Duration("timeout", 30*time.Second, "timeout of command")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage, "\nflags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	l, err := gincage.LoadConfig(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	err = run(ctx, l, flag.Arg(0), flag.Args()[1:])
+	cancel()
+	l.Close(context.Background())
+
+	if errors.Is(err, errUsage) {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, l *gincage.Limiter, cmd string, args []string) error {
+	switch {
+	case cmd == "inspect" && len(args) == 1:
+		return inspect(ctx, l.Bucket(), l.StorageKey(args[0]))
+	case cmd == "reset" && len(args) == 1:
+		r, err := bucketAs[gincage.Resetter](l.Bucket(), "reset")
+		if err != nil {
+			return err
+		}
+		return r.Reset(ctx, l.StorageKey(args[0]))
+	case cmd == "credit" && len(args) == 2:
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			return errUsage
+		}
+		st, err := l.Credit(ctx, args[0], n)
+		if errors.Is(err, errors.ErrUnsupported) {
+			return fmt.Errorf("backend doesn't support credits")
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %d tokens\n", st.Key, st.Tokens)
+		return nil
+	case cmd == "top" && len(args) <= 1:
+		n := 10
+		if len(args) == 1 {
+			var err error
+			if n, err = strconv.Atoi(args[0]); err != nil || n <= 0 {
+				return errUsage
+			}
+		}
+		return top(ctx, l.Bucket(), n)
+	case cmd == "ban" && len(args) == 2:
+		d, err := time.ParseDuration(args[1])
+		if err != nil || d <= 0 {
+			return errUsage
+		}
+		b, err := bucketAs[gincage.Banner](l.Bucket(), "bans")
+		if err != nil {
+			return err
+		}
+		return b.Ban(ctx, l.StorageKey(args[0]), d)
+	case cmd == "unban" && len(args) == 1:
+		if _, err := bucketAs[gincage.Banner](l.Bucket(), "bans"); err != nil {
+			return err
+		}
+		return l.Unban(ctx, args[0])
+	case cmd == "bans" && len(args) == 0:
+		return bans(ctx, l)
+	case cmd == "config" && len(args) == 0:
+		return printConfig(l)
+	case cmd == "health" && len(args) == 0:
+		if err := l.Healthy(ctx); err != nil {
+			return fmt.Errorf("unhealthy: %w", err)
+		}
+		fmt.Println("healthy")
+		return nil
+	case cmd == "flags" && len(args) == 0:
+		f, err := l.Flags(ctx)
+		if err != nil {
+			return flagsError(err)
+		}
+		return printJSON(f)
+	case cmd == "flags" && len(args) == 1:
+		var f gincage.Flags
+		if err := json.Unmarshal([]byte(args[0]), &f); err != nil {
+			return fmt.Errorf("flags: %w", err)
+		}
+		return flagsError(l.SetFlags(ctx, f))
+	default:
+		return errUsage
+	}
+}
+
+// Returns extension T of bucket or error naming unsupported feature
+func bucketAs[T any](b gincage.Bucket, feature string) (T, error) {
+	t, ok := gincage.BucketAs[T](b)
+	if !ok {
+		return t, fmt.Errorf("backend doesn't support %s", feature)
+	}
+	return t, nil
+}
+
+func inspect(ctx context.Context, b gincage.Bucket, key string) error {
+	p, err := bucketAs[gincage.Peeker](b, "inspection")
+	if err != nil {
+		return err
+	}
+	st, err := p.Peek(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "key\t%s\n", st.Key)
+	fmt.Fprintf(w, "stored\t%t\n", st.Exists)
+	fmt.Fprintf(w, "tokens\t%d\n", st.Tokens)
+	if st.Exists {
+		fmt.Fprintf(w, "refilled at\t%s\n", st.RefilledAt.Format(time.RFC3339))
+	}
+	if !st.ExpiresAt.IsZero() {
+		fmt.Fprintf(w, "expires at\t%s\n", st.ExpiresAt.Format(time.RFC3339))
+	}
+	if banner, ok := gincage.BucketAs[gincage.Banner](b); ok {
+		until, err := banner.BannedUntil(ctx, key)
+		if err != nil {
+			return err
+		}
+		if !until.IsZero() {
+			fmt.Fprintf(w, "banned until\t%s\n", until.Format(time.RFC3339))
+		}
+	}
+	return w.Flush()
+}
+
+func top(ctx context.Context, b gincage.Bucket, n int) error {
+	s, err := bucketAs[gincage.Scanner](b, "scans")
+	if err != nil {
+		return err
+	}
+	// keys with fewest tokens are kept in ascending order
+	var entries []gincage.SnapshotEntry
+	err = s.Scan(ctx, func(e gincage.SnapshotEntry) error {
+		i, _ := slices.BinarySearchFunc(entries, e, compareEntries)
+		if i < n {
+			entries = slices.Insert(entries, i, e)
+			entries = entries[:min(len(entries), n)]
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tTOKENS\tREFILLED AT")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", e.Key, e.Tokens, e.RefilledAt.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+// Orders entries by tokens, then by time of refill
+func compareEntries(a, b gincage.SnapshotEntry) int {
+	return cmp.Or(cmp.Compare(a.Tokens, b.Tokens), a.RefilledAt.Compare(b.RefilledAt))
+}
+
+func bans(ctx context.Context, l *gincage.Limiter) error {
+	if _, err := bucketAs[gincage.Banner](l.Bucket(), "bans"); err != nil {
+		return err
+	}
+	list, err := l.Bans(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tBANNED UNTIL")
+	for _, ban := range list {
+		fmt.Fprintf(w, "%s\t%s\n", ban.Key, ban.Until.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+// Prints config of limiter with limits of bucket filled in
+func printConfig(l *gincage.Limiter) error {
+	cfg := l.Config()
+	if r, ok := gincage.BucketAs[gincage.RateReporter](l.Bucket()); ok {
+		rate := r.Rate()
+		if cfg.Rate.Capacity <= 0 {
+			cfg.Rate.Capacity = rate.Capacity
+		}
+		if cfg.Rate.Refill <= 0 {
+			cfg.Rate.Refill = rate.Refill
+		}
+		if cfg.Rate.TTL <= 0 {
+			cfg.Rate.TTL = rate.TTL
+		}
+	}
+	return printJSON(cfg)
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// Names unsupported feature in err
+func flagsError(err error) error {
+	if errors.Is(err, errors.ErrUnsupported) {
+		return fmt.Errorf("backend doesn't support flags")
+	}
+	return err
+}
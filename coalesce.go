@@ -0,0 +1,196 @@
+package gincage
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Default timeout of storage calls serving queued takes
+// if first take of key had no deadline
+var DefaultCoalesceTimeout = time.Duration(5 * time.Second)
+
+type coalescingBucket struct {
+	bucket Bucket
+	walker BatchWalker
+
+	mu     sync.Mutex
+	groups map[string]*coalesceGroup
+}
+
+// Requests of key waiting for storage call in flight
+type coalesceGroup struct {
+	waiting []*coalesceWaiter
+}
+
+type coalesceWaiter struct {
+	stats *WalkStats
+	n     int
+	done  chan coalesceResult
+}
+
+type coalesceResult struct {
+	res Result
+	err error
+	// copy of stats of storage call, waiter may stop waiting
+	// before result is sent, so flush can't write its stats
+	stats WalkStats
+}
+
+// Wraps bucket, so concurrent walks of same key are collapsed.
+//
+// While storage call of key is in flight, next walks of key are queued.
+// When call is done, queued takes get their tokens in one call and
+// its result. It reduces round trips and transaction conflicts
+// when one client sends bursts of requests to one instance.
+// Queued takes return ctx.Err() when their context is done, their calls
+// are bounded by deadline of first take or DefaultCoalesceTimeout.
+//
+// Bucket should implement BatchWalker, otherwise it is returned as is
+func NewCoalescingBucket(b Bucket) Bucket {
+	walker, ok := BucketAs[BatchWalker](b)
+	if !ok {
+		return b
+	}
+	return &coalescingBucket{
+		bucket: b,
+		walker: walker,
+		groups: make(map[string]*coalesceGroup),
+	}
+}
+
+// Returns wrapped bucket
+func (b *coalescingBucket) Unwrap() Bucket {
+	return b.bucket
+}
+
+func (b *coalescingBucket) Close() error {
+	return b.bucket.Close()
+}
+
+func (b *coalescingBucket) Take(ctx context.Context, key string, n int) (Result, error) {
+	opts, _ := WalkOptionsFromContext(ctx)
+	n = takeCount(n)
+
+	b.mu.Lock()
+	if g, ok := b.groups[key]; ok {
+		w := &coalesceWaiter{
+			stats: WalkStatsFromContext(ctx),
+			n:     n,
+			done:  make(chan coalesceResult, 1),
+		}
+		g.waiting = append(g.waiting, w)
+		b.mu.Unlock()
+		select {
+		case r := <-w.done:
+			if w.stats != nil {
+				w.stats.Backend = r.stats.Backend
+				w.stats.Retries = r.stats.Retries
+			}
+			return r.res, r.err
+		case <-ctx.Done():
+			b.leave(g, w)
+			return Result{}, ctx.Err()
+		}
+	}
+	g := &coalesceGroup{}
+	b.groups[key] = g
+	b.mu.Unlock()
+
+	// queued takes get timeout of this one (storage timeout of limiter)
+	timeout := DefaultCoalesceTimeout
+	if d, ok := ctx.Deadline(); ok && time.Until(d) > 0 {
+		timeout = time.Until(d)
+	}
+	res, err := resultOf(b.walker.WalkMany(ctx, []KeyedCost{{Key: key, Cost: n, Rate: opts.Rate}}))
+
+	if b.done(key, g) {
+		return res, err
+	}
+	// queued takes are served after request of this one is done,
+	// so request context can't be used
+	go b.flush(context.WithoutCancel(ctx), key, opts.Rate, timeout, g)
+	return res, err
+}
+
+// Removes group of key if nothing is queued
+func (b *coalescingBucket) done(key string, g *coalesceGroup) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(g.waiting) > 0 {
+		return false
+	}
+	delete(b.groups, key)
+	return true
+}
+
+// Removes take which stopped waiting from queue of group.
+// Tokens are taken anyway if its batch is served already
+func (b *coalescingBucket) leave(g *coalesceGroup, w *coalesceWaiter) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, q := range g.waiting {
+		if q == w {
+			g.waiting = append(g.waiting[:i], g.waiting[i+1:]...)
+			return
+		}
+	}
+}
+
+// Serves queued takes until queue of key is empty
+func (b *coalescingBucket) flush(ctx context.Context, key string, rate Rate, timeout time.Duration, g *coalesceGroup) {
+	for {
+		b.mu.Lock()
+		batch := g.waiting
+		g.waiting = nil
+		b.mu.Unlock()
+
+		b.serve(ctx, key, rate, timeout, batch)
+		if b.done(key, g) {
+			return
+		}
+	}
+}
+
+// Takes tokens for whole batch in one call. If there are not enough tokens,
+// takes are served one by one until tokens end, then rest of batch
+// is denied without storage calls
+func (b *coalescingBucket) serve(ctx context.Context, key string, rate Rate, timeout time.Duration, batch []*coalesceWaiter) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	stats := &WalkStats{}
+	ctx = ContextWithWalkStats(ctx, stats)
+
+	var cost int
+	for _, w := range batch {
+		cost += w.n
+	}
+	res, err := resultOf(b.walker.WalkMany(ctx, []KeyedCost{{Key: key, Cost: cost, Rate: rate}}))
+	if err != nil || res.Allowed || len(batch) == 1 {
+		for _, w := range batch {
+			w.finish(stats, res, err)
+		}
+		return
+	}
+
+	for i, w := range batch {
+		res, err := resultOf(b.walker.WalkMany(ctx, []KeyedCost{{Key: key, Cost: w.n, Rate: rate}}))
+		if err != nil {
+			for _, w := range batch[i:] {
+				w.finish(stats, Result{}, err)
+			}
+			return
+		}
+		w.finish(stats, res, nil)
+		if !res.Allowed {
+			for _, w := range batch[i+1:] {
+				w.finish(stats, res, nil)
+			}
+			return
+		}
+	}
+}
+
+func (w *coalesceWaiter) finish(stats *WalkStats, res Result, err error) {
+	w.done <- coalesceResult{res: res, err: err, stats: *stats}
+}
@@ -0,0 +1,110 @@
+package gincage_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gincage "github.com/fyx1t/gin-cage"
+)
+
+// slowBucket: memory bucket with slow batch walks, so concurrent takes queue
+type slowBucket struct {
+	gincage.Bucket
+	walker gincage.BatchWalker
+	delay  time.Duration
+	calls  atomic.Int64
+}
+
+func newSlowBucket(capacity int, delay time.Duration) *slowBucket {
+	b := gincage.NewMemoryBucket(gincage.BucketConfigs{Capability: capacity, TokensAppendDuration: time.Hour})
+	walker, _ := gincage.BucketAs[gincage.BatchWalker](b)
+	return &slowBucket{Bucket: b, walker: walker, delay: delay}
+}
+
+func (b *slowBucket) WalkMany(ctx context.Context, keys []gincage.KeyedCost) error {
+	b.calls.Add(1)
+	time.Sleep(b.delay)
+	return b.walker.WalkMany(ctx, keys)
+}
+
+func TestCoalescingBucket(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		takes    int
+		cost     int
+		// every cancel-th take gives up waiting while storage call
+		// is in flight, zero if none
+		cancel int
+	}{
+		{name: "enough tokens", capacity: 100, takes: 50, cost: 1},
+		{name: "tokens end", capacity: 30, takes: 100, cost: 1},
+		{name: "cost above one", capacity: 25, takes: 40, cost: 2},
+		{name: "cancelled waiters", capacity: 1000, takes: 200, cost: 1, cancel: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay := 2 * time.Millisecond
+			slow := newSlowBucket(tt.capacity, delay)
+			b := gincage.NewCoalescingBucket(slow)
+			defer b.Close()
+
+			var allowed, cancelled atomic.Int64
+			var mu sync.Mutex
+			var gaveUp []*gincage.WalkStats
+			var wg sync.WaitGroup
+			for i := range tt.takes {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					timeout := time.Minute
+					if tt.cancel > 0 && i%tt.cancel == 0 {
+						timeout = time.Duration(i%4) * delay / 2
+					}
+					ctx, cancel := context.WithTimeout(context.Background(), timeout)
+					defer cancel()
+					stats := &gincage.WalkStats{}
+					res, err := b.Take(gincage.ContextWithWalkStats(ctx, stats), "k", tt.cost)
+					switch {
+					case err != nil && ctx.Err() != nil:
+						cancelled.Add(1)
+						mu.Lock()
+						gaveUp = append(gaveUp, stats)
+						mu.Unlock()
+					case err != nil:
+						t.Errorf("Take() = %v", err)
+					case res.Allowed:
+						allowed.Add(1)
+						if stats.Backend != "memory" {
+							t.Errorf("stats.Backend = %q, want memory", stats.Backend)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			// queued batches are served in background,
+			// they shouldn't touch stats of takes which gave up
+			time.Sleep(20 * time.Millisecond)
+			for _, stats := range gaveUp {
+				if stats.Backend != "" {
+					t.Errorf("stats.Backend = %q of cancelled take, want empty", stats.Backend)
+				}
+			}
+
+			want := int64(min(tt.capacity/tt.cost, tt.takes))
+			if tt.cancel > 0 {
+				// cancelled takes may be served anyway
+				want -= cancelled.Load()
+			}
+			if got := allowed.Load(); got < want || got > int64(tt.capacity/tt.cost) {
+				t.Errorf("allowed %d takes, want %d", got, want)
+			}
+			if calls := slow.calls.Load(); calls >= int64(tt.takes) && tt.cancel == 0 {
+				t.Errorf("%d storage calls for %d takes, want fewer", calls, tt.takes)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package gincage
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format byte of BinaryCodec values
+const binaryCodecV1 = 1
+
+var (
+	// Encodes state as "tokens|RFC3339Nano|version" text.
+	// Decodes values of older versions without version too
+	TextCodec Codec = textCodec{}
+	// Encodes state as format byte, varint tokens, varint unix nanoseconds
+	// and varint version. Values of current times are about 12-21 bytes,
+	// including "v2:" prefix written with SchemaV2
+	BinaryCodec Codec = binaryCodec{}
+)
+
+// StoredState: state of key kept in storage
+type StoredState struct {
+	Tokens int
+	// Time of last tokens append
+	RefilledAt time.Time
+	// Incremented on every write
+	Version int64
+}
+
+// Codec: encoding of key state stored as single value
+type Codec interface {
+	Encode(st StoredState) []byte
+	// Returns ErrBadSyntaxInStorage if data can't be decoded
+	Decode(data []byte) (StoredState, error)
+}
+
+type textCodec struct{}
+
+func (textCodec) Encode(st StoredState) []byte {
+	b := make([]byte, 0, 64)
+	b = strconv.AppendInt(b, int64(st.Tokens), 10)
+	b = append(b, '|')
+	b = st.RefilledAt.UTC().AppendFormat(b, time.RFC3339Nano)
+	b = append(b, '|')
+	return strconv.AppendInt(b, st.Version, 10)
+}
+
+func (textCodec) Decode(data []byte) (StoredState, error) {
+	d := strings.Split(string(data), "|")
+	if len(d) != 2 && len(d) != 3 {
+		return StoredState{}, ErrBadSyntaxInStorage
+	}
+
+	var st StoredState
+	var err error
+	if st.Tokens, err = strconv.Atoi(d[0]); err != nil {
+		return StoredState{}, ErrBadSyntaxInStorage
+	}
+	// RFC3339Nano layout accepts time without fraction too
+	if st.RefilledAt, err = time.Parse(time.RFC3339Nano, d[1]); err != nil {
+		return StoredState{}, ErrBadSyntaxInStorage
+	}
+	if len(d) == 3 {
+		if st.Version, err = strconv.ParseInt(d[2], 10, 64); err != nil {
+			return StoredState{}, ErrBadSyntaxInStorage
+		}
+	}
+	return st, nil
+}
+
+type binaryCodec struct{}
+
+func (binaryCodec) Encode(st StoredState) []byte {
+	b := make([]byte, 0, 1+3*binary.MaxVarintLen64)
+	b = append(b, binaryCodecV1)
+	b = binary.AppendVarint(b, int64(st.Tokens))
+	b = binary.AppendVarint(b, st.RefilledAt.UnixNano())
+	return binary.AppendVarint(b, st.Version)
+}
+
+func (binaryCodec) Decode(data []byte) (StoredState, error) {
+	if len(data) == 0 || data[0] != binaryCodecV1 {
+		return StoredState{}, ErrBadSyntaxInStorage
+	}
+	data = data[1:]
+
+	var v [3]int64
+	for i := range v {
+		n, size := binary.Varint(data)
+		if size <= 0 {
+			return StoredState{}, ErrBadSyntaxInStorage
+		}
+		v[i] = n
+		data = data[size:]
+	}
+	if len(data) != 0 {
+		return StoredState{}, ErrBadSyntaxInStorage
+	}
+	return StoredState{
+		Tokens:     int(v[0]),
+		RefilledAt: time.Unix(0, v[1]),
+		Version:    v[2],
+	}, nil
+}
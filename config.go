@@ -0,0 +1,493 @@
+package gincage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Default interval of config file checks
+var DefaultConfigWatchInterval = time.Duration(5 * time.Second)
+
+// Config: limits applied by limiter.
+//
+// Can be changed at runtime with UpdateConfig
+type Config struct {
+	// Limit of all routes without rule. Zero fields are taken from bucket
+	Rate Rate `json:"rate"`
+	// Per-route limits. First matching rule wins.
+	// Every rule has its own tokens for every client,
+	// rules of one group share them
+	Routes []RouteRule `json:"routes,omitempty"`
+	// Limits of route groups by group name, see RouteRule.Group.
+	// Zero fields are taken from Rate
+	Groups map[string]Rate `json:"groups,omitempty"`
+	// Client ips and CIDRs bypassing limiter
+	Allowlist []string `json:"allowlist,omitempty"`
+	// Request paths bypassing limiter, see WithSkipPaths
+	SkipPaths []string `json:"skip_paths,omitempty"`
+	// Limits of client plans by plan name, see WithPlanFunc.
+	// Zero fields are taken from Rate
+	Plans map[string]Rate `json:"plans,omitempty"`
+	// Plan of clients with unknown or empty plan. If empty, such clients
+	// get Rate
+	DefaultPlan string `json:"default_plan,omitempty"`
+	// Limits of clients by location, see WithGeoResolver.
+	// First matching rule wins
+	GeoRules []GeoRule `json:"geo_rules,omitempty"`
+	// Limits of clients by User-Agent. First matching rule wins
+	AgentRules []AgentRule `json:"agent_rules,omitempty"`
+	// Limit of all requests of service, applied along with limits
+	// of clients, e.g. to protect fragile dependency.
+	// If Capacity <= 0, service isn't limited
+	Global Rate `json:"global,omitzero"`
+	// Shadow mode: requests are checked against limits, but never rejected.
+	// Would-be rejections are logged, counted (Stats.Shadowed, ShadowMetrics)
+	// and marked with header set by WithShadowHeader.
+	// Keys aren't penalized by ban policy and greylist
+	Shadow bool `json:"shadow,omitempty"`
+	// Percent of keys limits are enforced for, others are shadowed
+	// (see Shadow). Keys are assigned by hash, so every instance enforces
+	// same keys and raising percent keeps enforced ones.
+	// Zero enforces all keys
+	EnforcePercent float64 `json:"enforce_percent,omitempty"`
+	// Share of capacity (0..1) requests of priority class can't take,
+	// by class name (see Request.Priority). E.g. {"background": 0.3}
+	// rejects background requests when less than 30% of tokens are left,
+	// while interactive ones (without reserve) go on.
+	// Requests of unknown or empty class take all tokens
+	Priorities map[string]float64 `json:"priorities,omitempty"`
+	// Limits differing by time of day, see Schedule.
+	// First active schedule wins
+	Schedules []Schedule `json:"schedules,omitempty"`
+	// Limits of client networks (e.g. internal services vs internet),
+	// see NetworkClass. First matching class wins
+	Networks []NetworkClass `json:"networks,omitempty"`
+	// Limits of clients by OAuth scopes of their tokens, see Request.Scopes.
+	// First rule matching any scope wins, so stricter rules go first
+	Scopes []ScopeRule `json:"scopes,omitempty"`
+	// Policies of requests (limit, skip or deny) matched by path, method,
+	// headers, ip and plan, see Rule. Checked in order after allowlist
+	// and skip paths, first matching rule wins
+	Rules []Rule `json:"rules,omitempty"`
+
+	// parsed Allowlist
+	allow []netip.Prefix
+	// parsed SkipPaths
+	skip *pathMatcher
+}
+
+// RouteRule: limit of routes matching Path and Methods
+type RouteRule struct {
+	// gin route pattern like "/users/:id".
+	// Pattern ending with "*" matches every route with such prefix
+	Path string `json:"path"`
+	// HTTP methods. Empty matches all methods
+	Methods []string `json:"methods,omitempty"`
+	// Limit of route. Zero fields are taken from LimitProvider,
+	// then from Config.Rate and then from bucket
+	Rate Rate `json:"rate"`
+	// Name of group from Config.Groups. Routes of group share one
+	// bucket of client (e.g. /login, /register and /password-reset
+	// drawing from one abuse budget) limited by rate of group,
+	// so Rate of rule should be empty
+	Group string `json:"group,omitempty"`
+	// Route params keying rule instead of client (e.g. "org_id" of
+	// "/orgs/:org_id/*"), so all clients of resource share its tokens.
+	// If request has no such params (see Request.Param), client key is used
+	KeyParams []string `json:"key_params,omitempty"`
+}
+
+// Sets initial config. Invalid config is ignored with error log
+func WithConfig(cfg Config) Option {
+	return func(l *Limiter) {
+		l.initialConfig = &cfg
+	}
+}
+
+// Replaces limits of limiter. Safe for concurrent use with requests processing
+func (l *Limiter) UpdateConfig(cfg Config) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	cfg = cfg.clone()
+	l.config.Store(&cfg)
+	l.logger.Info("limiter config updated", F("routes", len(cfg.Routes)))
+	return nil
+}
+
+// Returns current config
+func (l *Limiter) Config() Config {
+	return l.config.Load().clone()
+}
+
+func (c Config) validate() error {
+	if err := c.Rate.validate(); err != nil {
+		return fmt.Errorf("rate.%w", err)
+	}
+	if err := c.Global.validate(); err != nil {
+		return fmt.Errorf("global.%w", err)
+	}
+	if c.EnforcePercent < 0 || c.EnforcePercent > 100 {
+		return fmt.Errorf("enforce_percent: should be in [0, 100]")
+	}
+	for i, r := range c.Routes {
+		if r.Path == "" {
+			return fmt.Errorf("routes[%d].path: should not be empty", i)
+		}
+		if err := r.Rate.validate(); err != nil {
+			return fmt.Errorf("routes[%d].rate.%w", i, err)
+		}
+		if slices.Contains(r.KeyParams, "") {
+			return fmt.Errorf("routes[%d].key_params: should not contain empty names", i)
+		}
+		if r.Group == "" {
+			continue
+		}
+		if _, ok := c.Groups[r.Group]; !ok {
+			return fmt.Errorf("routes[%d].group: unknown group %q", i, r.Group)
+		}
+		if r.Rate != (Rate{}) {
+			return fmt.Errorf("routes[%d].rate: should be empty, limit of group %q is used", i, r.Group)
+		}
+	}
+	for name, rate := range c.Groups {
+		if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, "|") {
+			return fmt.Errorf("groups.%s: name should not be empty, start with / or contain |", name)
+		}
+		if err := rate.validate(); err != nil {
+			return fmt.Errorf("groups.%s.%w", name, err)
+		}
+	}
+	for i, a := range c.Allowlist {
+		if _, err := parsePrefix(a); err != nil {
+			return fmt.Errorf("allowlist[%d]: %w", i, err)
+		}
+	}
+	if _, err := newPathMatcher(c.SkipPaths); err != nil {
+		return fmt.Errorf("skip_paths%w", err)
+	}
+	for i, r := range c.GeoRules {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("geo_rules[%d]: %w", i, err)
+		}
+	}
+	for i, r := range c.AgentRules {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("agent_rules[%d]: %w", i, err)
+		}
+	}
+	for class, reserve := range c.Priorities {
+		if reserve < 0 || reserve >= 1 {
+			return fmt.Errorf("priorities.%s: should be in [0, 1)", class)
+		}
+	}
+	for i, s := range c.Schedules {
+		if err := s.compile(); err != nil {
+			return fmt.Errorf("schedules[%d].%w", i, err)
+		}
+	}
+	for i, n := range c.Networks {
+		if err := n.validate(); err != nil {
+			return fmt.Errorf("networks[%d]: %w", i, err)
+		}
+	}
+	for i, r := range c.Scopes {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("scopes[%d].%w", i, err)
+		}
+	}
+	for i, r := range c.Rules {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("rules[%d].%w", i, err)
+		}
+	}
+	return c.validatePlans()
+}
+
+// Parses ip or CIDR. Ip is treated as single address prefix
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Returns true if client ip is in allowlist
+func (c *Config) allowed(ip string) bool {
+	if len(c.allow) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range c.allow {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Config) clone() Config {
+	routes := make([]RouteRule, len(c.Routes))
+	for i, r := range c.Routes {
+		r.Methods = make([]string, len(r.Methods))
+		for j, m := range c.Routes[i].Methods {
+			r.Methods[j] = strings.ToUpper(m)
+		}
+		r.KeyParams = slices.Clone(r.KeyParams)
+		routes[i] = r
+	}
+	c.Routes = routes
+
+	c.Allowlist = append([]string(nil), c.Allowlist...)
+	c.allow = make([]netip.Prefix, 0, len(c.Allowlist))
+	for _, a := range c.Allowlist {
+		// validated before clone
+		if p, err := parsePrefix(a); err == nil {
+			c.allow = append(c.allow, p)
+		}
+	}
+
+	c.Plans = maps.Clone(c.Plans)
+	c.Groups = maps.Clone(c.Groups)
+	c.Priorities = maps.Clone(c.Priorities)
+	geo := make([]GeoRule, len(c.GeoRules))
+	for i, r := range c.GeoRules {
+		r.Countries = make([]string, len(r.Countries))
+		for j, country := range c.GeoRules[i].Countries {
+			r.Countries[j] = strings.ToUpper(country)
+		}
+		r.ASNs = slices.Clone(r.ASNs)
+		geo[i] = r
+	}
+	c.GeoRules = geo
+	agents := make([]AgentRule, len(c.AgentRules))
+	for i, r := range c.AgentRules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("agent_rules[%d]", i)
+		}
+		r.Patterns = slices.Clone(r.Patterns)
+		// validated before clone
+		r.compile()
+		agents[i] = r
+	}
+	c.AgentRules = agents
+	schedules := make([]Schedule, len(c.Schedules))
+	for i, s := range c.Schedules {
+		if s.Name == "" {
+			s.Name = fmt.Sprintf("schedules[%d]", i)
+		}
+		s.Days = slices.Clone(s.Days)
+		// validated before clone
+		s.compile()
+		schedules[i] = s
+	}
+	c.Schedules = schedules
+	networks := make([]NetworkClass, len(c.Networks))
+	for i, n := range c.Networks {
+		if n.Name == "" {
+			n.Name = fmt.Sprintf("networks[%d]", i)
+		}
+		n.CIDRs = slices.Clone(n.CIDRs)
+		// validated before clone
+		n.compile()
+		networks[i] = n
+	}
+	c.Networks = networks
+	c.Scopes = slices.Clone(c.Scopes)
+	rules := make([]Rule, len(c.Rules))
+	for i, r := range c.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rules[%d]", i)
+		}
+		r.Paths = slices.Clone(r.Paths)
+		r.Methods = make([]string, len(r.Methods))
+		for j, m := range c.Rules[i].Methods {
+			r.Methods[j] = strings.ToUpper(m)
+		}
+		r.Headers = maps.Clone(r.Headers)
+		r.CIDRs = slices.Clone(r.CIDRs)
+		r.Plans = slices.Clone(r.Plans)
+		// validated before clone
+		r.compile()
+		rules[i] = r
+	}
+	c.Rules = rules
+	c.SkipPaths = append([]string(nil), c.SkipPaths...)
+	c.skip = nil
+	if len(c.SkipPaths) > 0 {
+		// validated before clone
+		c.skip, _ = newPathMatcher(c.SkipPaths)
+	}
+	return c
+}
+
+// Returns walk options for request: route-scoped key and effective rate.
+// Zero fields of key limits are taken from config rate
+func (c *Config) walkOptions(req Request, limits Rate) WalkOptions {
+	rate := limits.withDefaults(c.Rate)
+	route := req.Route
+	if route == "" {
+		route = req.Path
+	}
+	opts := WalkOptions{
+		Key:     clientKey(req.Key),
+		Rate:    rate,
+		Reserve: c.Priorities[req.Priority],
+		Cost:    req.Cost,
+	}
+	if r, ok := c.match(req.Method, route); ok {
+		scope, limit := r.Path, r.Rate
+		if r.Group != "" {
+			// group name can't clash with paths of rules
+			scope, limit = r.Group, c.Groups[r.Group]
+		}
+		opts.Key = scope + "|" + r.key(req)
+		opts.Rate = limit.withDefaults(rate)
+	}
+	return opts
+}
+
+// Returns key of request in rule: values of key params
+// (e.g. "org_id=acme") or client key if some param is missing
+func (r RouteRule) key(req Request) string {
+	if len(r.KeyParams) == 0 || req.Param == nil {
+		return req.Key
+	}
+	var b strings.Builder
+	for i, name := range r.KeyParams {
+		v := req.Param(name)
+		if v == "" {
+			return req.Key
+		}
+		if i > 0 {
+			b.WriteByte('&')
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(url.QueryEscape(v))
+	}
+	return b.String()
+}
+
+func (c *Config) match(method, path string) (RouteRule, bool) {
+	for _, r := range c.Routes {
+		if !r.matchPath(path) || !r.matchMethod(method) {
+			continue
+		}
+		return r, true
+	}
+	return RouteRule{}, false
+}
+
+func (r RouteRule) matchPath(path string) bool {
+	if prefix, ok := strings.CutSuffix(r.Path, "*"); ok {
+		return strings.HasPrefix(path, prefix)
+	}
+	return r.Path == path
+}
+
+func (r RouteRule) matchMethod(method string) bool {
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, m := range r.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// Loads limits section of config file (see LoadConfig) and reloads
+// it every interval when file modification time changes, until ctx is done
+// or limiter is closed.
+//
+// Returns error if file can't be loaded initially. Later failures are
+// logged and previous config is kept. If interval <= 0, uses DefaultConfigWatchInterval
+func (l *Limiter) WatchConfigFile(ctx context.Context, path string, interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultConfigWatchInterval
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := l.reloadConfigFile(path); err != nil {
+		return err
+	}
+
+	modTime := info.ModTime()
+	l.background(ctx, func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				l.logger.Error("failed to check config file", F("error", err), F("path", path))
+				continue
+			}
+			if info.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+			if err := l.reloadConfigFile(path); err != nil {
+				l.logger.Error("failed to reload config file", F("error", err), F("path", path))
+			}
+		}
+	})
+	return nil
+}
+
+func (l *Limiter) reloadConfigFile(path string) error {
+	fc, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	cfg, err := fc.Limits.config()
+	if err != nil {
+		return fmt.Errorf("%s: limits.%w", path, err)
+	}
+	return l.UpdateConfig(cfg)
+}
+
+var errNegative = errors.New("should not be negative")
+
+func (r Rate) validate() error {
+	if r.Capacity < 0 {
+		return fmt.Errorf("capacity: %w", errNegative)
+	}
+	if r.Refill < 0 {
+		return fmt.Errorf("refill: %w", errNegative)
+	}
+	if r.TTL < 0 {
+		return fmt.Errorf("ttl: %w", errNegative)
+	}
+	return nil
+}
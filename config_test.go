@@ -0,0 +1,146 @@
+package gincage_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	gincage "github.com/fyx1t/gin-cage"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  gincage.Config
+		// prefix of error, empty if config is valid
+		err string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "negative capacity",
+			cfg:  gincage.Config{Rate: gincage.Rate{Capacity: -1}},
+			err:  "rate.capacity:",
+		},
+		{
+			name: "enforce percent above 100",
+			cfg:  gincage.Config{EnforcePercent: 101},
+			err:  "enforce_percent:",
+		},
+		{
+			name: "global refill without capacity",
+			cfg:  gincage.Config{Global: gincage.Rate{Refill: time.Second}},
+			err:  "global.capacity:",
+		},
+		{
+			name: "route without path",
+			cfg:  gincage.Config{Routes: []gincage.RouteRule{{}}},
+			err:  "routes[0].path:",
+		},
+		{
+			name: "route of unknown group",
+			cfg:  gincage.Config{Routes: []gincage.RouteRule{{Path: "/a", Group: "api"}}},
+			err:  "routes[0].group:",
+		},
+		{
+			name: "bad allowlist entry",
+			cfg:  gincage.Config{Allowlist: []string{"10.0.0.0/33"}},
+			err:  "allowlist[0]:",
+		},
+		{
+			name: "unnamed rule",
+			cfg:  gincage.Config{Rules: []gincage.Rule{{Action: gincage.RuleSkip, Paths: []string{"/health"}}}},
+		},
+		{
+			name: "rule name with separator",
+			cfg:  gincage.Config{Rules: []gincage.Rule{{Name: "a|b", Action: gincage.RuleSkip}}},
+			err:  "rules[0].name:",
+		},
+		{
+			name: "rule without action",
+			cfg:  gincage.Config{Rules: []gincage.Rule{{Name: "a"}}},
+			err:  "rules[0].action:",
+		},
+		{
+			name: "unnamed network",
+			cfg:  gincage.Config{Networks: []gincage.NetworkClass{{CIDRs: []string{"10.0.0.0/8"}}}},
+		},
+		{
+			name: "network name starting with slash",
+			cfg:  gincage.Config{Networks: []gincage.NetworkClass{{Name: "/a", Private: true}}},
+			err:  "networks[0]: name:",
+		},
+		{
+			name: "network without cidrs",
+			cfg:  gincage.Config{Networks: []gincage.NetworkClass{{Name: "a"}}},
+			err:  "networks[0]: cidrs",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("Validate() = %v, want nil", err)
+			case tt.err != "" && err == nil:
+				t.Fatalf("Validate() = nil, want %q...", tt.err)
+			case tt.err != "" && !strings.HasPrefix(err.Error(), tt.err):
+				t.Fatalf("Validate() = %v, want %q...", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestUpdateConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     gincage.Config
+		wantErr bool
+		// capacity of current config after update
+		capacity int
+	}{
+		{
+			name:     "valid",
+			cfg:      gincage.Config{Rate: gincage.Rate{Capacity: 5}},
+			capacity: 5,
+		},
+		{
+			name:     "invalid keeps config",
+			cfg:      gincage.Config{Rate: gincage.Rate{Capacity: -5}},
+			wantErr:  true,
+			capacity: 10,
+		},
+		{
+			name: "unnamed rule gets default name",
+			cfg: gincage.Config{
+				Rate:  gincage.Rate{Capacity: 3},
+				Rules: []gincage.Rule{{Action: gincage.RuleDeny, Paths: []string{"/admin"}}},
+			},
+			capacity: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := gincage.NewLimiter(gincage.NewMemoryBucket(gincage.BucketConfigs{}),
+				gincage.WithConfig(gincage.Config{Rate: gincage.Rate{Capacity: 10}}),
+			)
+			defer l.Close(context.Background())
+
+			err := l.UpdateConfig(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UpdateConfig() = %v, want error %v", err, tt.wantErr)
+			}
+			cfg := l.Config()
+			if cfg.Rate.Capacity != tt.capacity {
+				t.Errorf("capacity = %d, want %d", cfg.Rate.Capacity, tt.capacity)
+			}
+			for i, r := range cfg.Rules {
+				if r.Name == "" {
+					t.Errorf("rules[%d] has no name", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package gincage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Semaphore: storage of connection slots of keys, see WithConnectionLimit
+type Semaphore interface {
+	// Takes slot of key if fewer than max slots are taken.
+	// Returns id of taken slot, empty if all slots are taken
+	Acquire(ctx context.Context, key string, max int) (string, error)
+	// Returns slot id of key
+	Release(ctx context.Context, key, id string) error
+}
+
+// ConnPolicy: limit of long-lived connections (WebSocket, SSE) of key.
+//
+// Tokens per request mean nothing for streams living for hours,
+// so connection holds slot of its key until it's closed
+type ConnPolicy struct {
+	// Max open connections of key. If <= 0, connections aren't limited
+	Max int
+	// Storage of slots. If nil, slots are counted by process
+	// (see NewMemorySemaphore), NewRedisSemaphore shares them by instances.
+	// Semaphore implementing io.Closer is closed by Limiter.Close
+	Semaphore Semaphore
+}
+
+// Returns semaphore of policy if it has to be closed
+func (p *ConnPolicy) semaphoreCloser() (io.Closer, bool) {
+	if p == nil {
+		return nil, false
+	}
+	c, ok := p.Semaphore.(io.Closer)
+	return c, ok
+}
+
+// Limits open connections of key, see ConnPolicy and LimitConnections.
+//
+// Rejected connections are counted as rejected requests.
+// If semaphore fails, connections are allowed without slot with FailOpen
+// and FailLocal policies and rejected with FailClosed
+func WithConnectionLimit(p ConnPolicy) Option {
+	return func(l *Limiter) {
+		if p.Max <= 0 {
+			return
+		}
+		if p.Semaphore == nil {
+			p.Semaphore = NewMemorySemaphore()
+		}
+		l.conns = &p
+	}
+}
+
+// Takes connection slot of request key. If decision is allowed,
+// release should be called when connection is closed.
+// Requests skipped by config take no slot
+func (l *Limiter) AcquireConnection(ctx context.Context, req Request) (d Decision, release func()) {
+	release = func() {}
+	if req.RequestID == "" {
+		req.RequestID = RequestIDFromContext(ctx)
+	} else {
+		ctx = ContextWithRequestID(ctx, req.RequestID)
+	}
+	cfg := l.config.Load()
+	if l.conns == nil || l.skip.match(req.Path) || cfg.skip.match(req.Path) ||
+		(req.IP != "" && cfg.allowed(req.IP)) {
+		return Decision{Allowed: true}, release
+	}
+
+	req.Key = l.hashKey(req.Key)
+	sctx, cancel := l.storageContext(ctx)
+	start := time.Now()
+	id, err := l.conns.Semaphore.Acquire(sctx, req.Key, l.conns.Max)
+	cancel()
+	if err != nil {
+		l.storageError(req, "semaphore", time.Since(start), err)
+		if l.failPolicy == FailClosed {
+			return Decision{Err: err}, release
+		}
+		l.metrics.FailedOpen()
+		return Decision{Allowed: true}, release
+	}
+	if id == "" {
+		l.rejected(req)
+		return Decision{
+			Err:   &LimitExceededError{Key: req.Key, Limit: l.conns.Max},
+			Limit: l.conns.Max,
+		}, release
+	}
+
+	var once sync.Once
+	return Decision{Allowed: true}, func() {
+		once.Do(func() {
+			// request context is usually done when connection is closed
+			ctx, cancel := l.storageContext(context.WithoutCancel(ctx))
+			defer cancel()
+			if err := l.conns.Semaphore.Release(ctx, req.Key, id); err != nil {
+				l.log(req.RequestID).Error("connection slot isn't released", F("key", req.Key), F("error", err))
+			}
+		})
+	}
+}
+
+// Returns gin middleware limiting open connections of client key
+// (see WithConnectionLimit). Slot is held while next handlers run,
+// so middleware fits WebSocket and SSE routes, whose handlers return
+// when connection is closed
+func (l *Limiter) LimitConnections() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		req := newRequest(ctx.Request, l.keyFunc(ctx), ctx.ClientIP(), ctx.FullPath())
+		req.RequestID = l.ginRequestID(ctx)
+		d, release := l.AcquireConnection(requestContext(ctx), req)
+		if !d.Allowed {
+			ctx.Abort()
+			l.Render(d).Write(ctx.Writer)
+			return
+		}
+		defer release()
+		ctx.Next()
+	}
+}
+
+// Returns net/http middleware limiting open connections of client key,
+// see LimitConnections
+func (l *Limiter) ConnectionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := newRequest(r, l.httpKeyFunc(r), RemoteIPKey(r), "")
+		req.RequestID = l.headerRequestID(r)
+		d, release := l.AcquireConnection(r.Context(), req)
+		if !d.Allowed {
+			l.Render(d).Write(w)
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// MemorySemaphore: Semaphore counting slots in process memory
+type MemorySemaphore struct {
+	mu    sync.Mutex
+	slots map[string]int
+	next  uint64
+}
+
+// Creates semaphore counting slots in process memory
+func NewMemorySemaphore() *MemorySemaphore {
+	return &MemorySemaphore{slots: make(map[string]int)}
+}
+
+// Takes slot of key if fewer than max slots are taken
+func (s *MemorySemaphore) Acquire(ctx context.Context, key string, max int) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.slots[key] >= max {
+		return "", nil
+	}
+	s.slots[key]++
+	s.next++
+	return strconv.FormatUint(s.next, 10), nil
+}
+
+// Returns slot of key
+func (s *MemorySemaphore) Release(ctx context.Context, key, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.slots[key]--; s.slots[key] <= 0 {
+		delete(s.slots, key)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package gincage
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// Default max size of GraphQL request body read by GraphQLCost
+var DefaultGraphQLMaxBody = int64(1 << 20)
+
+// CostFunc: returns count of tokens taken by request, e.g. by
+// complexity of GraphQL query. If it returns <= 0, one token is taken
+type CostFunc func(r *http.Request) int
+
+// Sets function calculating cost of request in gin and net/http
+// middlewares, so endpoints are limited by actual work rather than
+// count of requests. Other adapters pass cost with Request.Cost.
+// If f is nil, option is ignored
+func WithCostFunc(f CostFunc) Option {
+	return func(l *Limiter) {
+		if f != nil {
+			l.costFunc = f
+		}
+	}
+}
+
+// Returns cost of r, zero if cost function isn't set
+func (l *Limiter) cost(r *http.Request) int {
+	if l.costFunc == nil || r == nil {
+		return 0
+	}
+	return l.costFunc(r)
+}
+
+// Returns CostFunc pricing request by size of body: one token per unit
+// of bytes started, at least one and at most max tokens (if max > 0),
+// so uploads are limited by traffic they make.
+// Bodies of unknown size (chunked) cost max tokens
+func BodySizeCost(unit int64, max int) CostFunc {
+	return func(r *http.Request) int {
+		if r.ContentLength < 0 && max > 0 {
+			return max
+		}
+		if unit <= 0 || r.ContentLength <= 0 {
+			return 1
+		}
+		n := (r.ContentLength + unit - 1) / unit
+		if max > 0 && n > int64(max) {
+			return max
+		}
+		return int(n)
+	}
+}
+
+// GraphQLOperation: operation of GraphQL request
+type GraphQLOperation struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// Returns CostFunc pricing GraphQL operations with calc (e.g. by depth or
+// complexity of query). Operation is read from JSON body of POST request
+// (batches are priced as sum of operations) or from query params of GET.
+//
+// Body is restored for handlers. Bodies over DefaultGraphQLMaxBody
+// and malformed ones cost one token, so server rejects them as usual
+func GraphQLCost(calc func(op GraphQLOperation) int) CostFunc {
+	return func(r *http.Request) int {
+		if r.Method == http.MethodGet {
+			q := r.URL.Query()
+			op := GraphQLOperation{Query: q.Get("query"), OperationName: q.Get("operationName")}
+			if v := q.Get("variables"); v != "" {
+				json.Unmarshal([]byte(v), &op.Variables)
+			}
+			return calc(op)
+		}
+		if r.Body == nil {
+			return 0
+		}
+
+		data, err := io.ReadAll(io.LimitReader(r.Body, DefaultGraphQLMaxBody+1))
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+		if err != nil || int64(len(data)) > DefaultGraphQLMaxBody {
+			return 0
+		}
+
+		data = bytes.TrimSpace(data)
+		if len(data) > 0 && data[0] == '[' {
+			var ops []GraphQLOperation
+			if json.Unmarshal(data, &ops) != nil {
+				return 0
+			}
+			var cost int
+			for _, op := range ops {
+				cost += max(calc(op), 1)
+			}
+			return cost
+		}
+		var op GraphQLOperation
+		if json.Unmarshal(data, &op) != nil {
+			return 0
+		}
+		return calc(op)
+	}
+}
+
+// Body replaced after reading, original one is closed
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,55 @@
+package gincage
+
+import (
+	"context"
+	"errors"
+)
+
+// Grants n extra tokens to storage key (client key or route-scoped key
+// like "/login|10.0.0.1", see StorageKey), even above its capacity,
+// e.g. for paid burst packs. Limit of key is taken from route rule,
+// group, rule or network class of its scope.
+// Returns errors.ErrUnsupported if bucket doesn't implement Crediter
+func (l *Limiter) Credit(ctx context.Context, key string, n int) (KeyState, error) {
+	c, ok := BucketAs[Crediter](l.bucket)
+	if !ok {
+		return KeyState{}, errors.ErrUnsupported
+	}
+	key = l.StorageKey(key)
+	ctx = ContextWithWalkOptions(ctx, WalkOptions{Key: key, Rate: l.config.Load().keyRate(key)})
+	ctx, cancel := l.storageContext(ctx)
+	defer cancel()
+	st, err := c.Credit(ctx, key, n)
+	if err != nil {
+		return KeyState{}, err
+	}
+	l.log(RequestIDFromContext(ctx)).Info("key credited", F("key", key), F("tokens", n))
+	return st, nil
+}
+
+// Returns limit of storage key by its scope
+func (c *Config) keyRate(key string) Rate {
+	scope, _, ok := cutLast(key, "|")
+	if !ok {
+		return c.Rate
+	}
+	if rate, ok := c.Groups[scope]; ok {
+		return rate.withDefaults(c.Rate)
+	}
+	for _, r := range c.Routes {
+		if r.Path == scope && r.Group == "" {
+			return r.Rate.withDefaults(c.Rate)
+		}
+	}
+	for _, r := range c.Rules {
+		if r.Name == scope {
+			return r.Rate.withDefaults(c.Rate)
+		}
+	}
+	for _, n := range c.Networks {
+		if n.Name == scope {
+			return n.Rate.withDefaults(c.Rate)
+		}
+	}
+	return c.Rate
+}
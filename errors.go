@@ -1,8 +1,99 @@
 package gincage
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 var (
 	ErrNoTokensAwailable  = errors.New("no tokens awailable in bucket")
 	ErrBadSyntaxInStorage = errors.New("bad syntax in storage")
+	// Returned when key was changed concurrently on every transaction retry
+	ErrContention = errors.New("too many concurrent updates of key")
+	// Returned by Allow when storage isn't called because of circuit breaker
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+	// Returned by Healthy after limiter was closed
+	ErrLimiterClosed = errors.New("limiter is closed")
 )
+
+// Returned by Credit if count of tokens isn't positive
+var errCreditNotPositive = errors.New("credit should be positive")
+
+// Returned by Book if count of tokens isn't positive
+var errBookNotPositive = errors.New("booked tokens should be positive")
+
+// Returned by Reservation.Commit if reservation was canceled
+var ErrReservationCanceled = errors.New("reservation is canceled")
+
+// LimitExceededError: key has no tokens. Returned in decisions and by
+// BatchWalker instead of ErrNoTokensAwailable, so responses can be filled
+// without extra storage calls.
+//
+// Matches ErrNoTokensAwailable with errors.Is
+type LimitExceededError struct {
+	// Storage key
+	Key string
+	// Capacity of key. Zero if unknown
+	Limit int
+	// Tokens left, fewer than requested
+	Remaining int
+	// Time when next token is appended. Zero if unknown
+	Reset time.Time
+}
+
+func (e *LimitExceededError) Error() string {
+	return ErrNoTokensAwailable.Error()
+}
+
+// Matches ErrNoTokensAwailable, and ErrGlobalLimit if global limit is exceeded
+func (e *LimitExceededError) Is(target error) bool {
+	return target == ErrNoTokensAwailable || (target == ErrGlobalLimit && e.Key == globalKey)
+}
+
+// Returns error of key with tokens left after refill at t
+func limitExceeded(key string, rate Rate, tokens int, t time.Time) *LimitExceededError {
+	e := &LimitExceededError{Key: key, Limit: rate.Capacity, Remaining: max(tokens, 0)}
+	if rate.Refill > 0 {
+		e.Reset = t.Add(rate.Refill)
+	}
+	return e
+}
+
+// Returns limit error of key denied by r
+func (r Result) limitError(key string) *LimitExceededError {
+	e := &LimitExceededError{Key: key, Limit: r.Limit, Remaining: max(r.Remaining, 0)}
+	if r.RetryAfter > 0 {
+		e.Reset = time.Now().Add(r.RetryAfter)
+	}
+	return e
+}
+
+// Returns result of batch walk error: denied result if err is limit error,
+// err if it's storage error
+func resultOf(err error) (Result, error) {
+	if err == nil {
+		return Result{Allowed: true, Remaining: -1}, nil
+	}
+	var e *LimitExceededError
+	if errors.As(err, &e) {
+		r := Result{Remaining: e.Remaining, Limit: e.Limit}
+		if !e.Reset.IsZero() {
+			r.RetryAfter = max(time.Until(e.Reset), 0)
+		}
+		return r, nil
+	}
+	if errors.Is(err, ErrNoTokensAwailable) {
+		return Result{}, nil
+	}
+	return Result{}, err
+}
+
+// Returns time till reset of limit error in err,
+// false if err doesn't carry reset time
+func retryAfterOf(err error) (time.Duration, bool) {
+	var e *LimitExceededError
+	if !errors.As(err, &e) || e.Reset.IsZero() {
+		return 0, false
+	}
+	return max(time.Until(e.Reset), 0), true
+}
@@ -0,0 +1,38 @@
+package gincage
+
+import "time"
+
+// EvictionCounter can be implemented by Bucket and local caches bounded
+// by max keys to report count of keys evicted to make room for new ones
+type EvictionCounter interface {
+	Evictions() uint64
+}
+
+// Count of keys sampled to find eviction victim
+const evictionSamples = 8
+
+// Returns key of m to evict: expired key or key expiring first among
+// sampled ones (keys expire after last use, so it approximates LRU).
+// Keys rejected by evictable are skipped. Returns false if no key is found
+func evictionVictim[V any](m map[string]V, now time.Time, expiresAt func(V) time.Time, evictable func(V) bool) (string, bool) {
+	var victim string
+	var victimAt time.Time
+	var sampled int
+	// map iteration starts at random key
+	for key, v := range m {
+		if evictable != nil && !evictable(v) {
+			continue
+		}
+		at := expiresAt(v)
+		if !now.Before(at) {
+			return key, true
+		}
+		if sampled == 0 || at.Before(victimAt) {
+			victim, victimAt = key, at
+		}
+		if sampled++; sampled >= evictionSamples {
+			break
+		}
+	}
+	return victim, sampled > 0
+}
@@ -0,0 +1,93 @@
+package gincage
+
+import (
+	"expvar"
+	"time"
+)
+
+// Default name of limiter variable published by WithExpvar
+const DefaultExpvarName = "gincage"
+
+// Publishes limiter counters (see Stats) as expvar variable name,
+// so they are served by expvar handler (/debug/vars) without
+// metrics dependencies. If name is empty, DefaultExpvarName is used.
+//
+// expvar variables can't be removed, so name should be published
+// by one limiter of process. Otherwise it isn't replaced, warning is logged
+func WithExpvar(name string) Option {
+	return func(l *Limiter) {
+		if name == "" {
+			name = DefaultExpvarName
+		}
+		l.expvarName = name
+	}
+}
+
+// Publishes variable set by WithExpvar
+func (l *Limiter) publishExpvar() {
+	if l.expvarName == "" {
+		return
+	}
+	if expvar.Get(l.expvarName) != nil {
+		l.logger.Warn("expvar variable is already published, limiter counters aren't", F("name", l.expvarName))
+		return
+	}
+	expvar.Publish(l.expvarName, expvar.Func(l.expvarStats))
+}
+
+type expvarStats struct {
+	Since             time.Time                `json:"since"`
+	Allowed           uint64                   `json:"allowed"`
+	Rejected          uint64                   `json:"rejected"`
+	Errored           uint64                   `json:"errored"`
+	Shadowed          uint64                   `json:"shadowed"`
+	AdmissionRejected uint64                   `json:"admission_rejected"`
+	Fallbacks         uint64                   `json:"fallbacks"`
+	Tarpitted         uint64                   `json:"tarpitted"`
+	CircuitOpen       bool                     `json:"circuit_open"`
+	Overloaded        bool                     `json:"overloaded"`
+	Degraded          bool                     `json:"degraded"`
+	Backends          map[string]expvarBackend `json:"backends"`
+	AgentRules        map[string]uint64        `json:"agent_rules,omitempty"`
+	Rules             map[string]uint64        `json:"rules,omitempty"`
+	Networks          map[string]uint64        `json:"networks,omitempty"`
+}
+
+type expvarBackend struct {
+	Calls    uint64 `json:"calls"`
+	Errors   uint64 `json:"errors"`
+	Retries  uint64 `json:"retries"`
+	Timeouts uint64 `json:"timeouts"`
+	Healthy  bool   `json:"healthy"`
+}
+
+func (l *Limiter) expvarStats() any {
+	st := l.Stats()
+	v := expvarStats{
+		Since:             st.Since,
+		Allowed:           st.Allowed,
+		Rejected:          st.Rejected,
+		Errored:           st.Errored,
+		Shadowed:          st.Shadowed,
+		AdmissionRejected: st.AdmissionRejected,
+		Fallbacks:         st.Fallbacks,
+		Tarpitted:         st.Tarpitted,
+		CircuitOpen:       st.CircuitOpen,
+		Overloaded:        st.Overloaded,
+		Degraded:          st.Degraded,
+		Backends:          make(map[string]expvarBackend, len(st.Backends)),
+		AgentRules:        st.AgentRules,
+		Rules:             st.Rules,
+		Networks:          st.Networks,
+	}
+	for name, b := range st.Backends {
+		v.Backends[name] = expvarBackend{
+			Calls:    b.Calls,
+			Errors:   b.Errors,
+			Retries:  b.Retries,
+			Timeouts: b.Timeouts,
+			Healthy:  b.Healthy,
+		}
+	}
+	return v
+}
@@ -0,0 +1,162 @@
+package gincage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// FailPolicy: limiter behaviour when bucket storage fails
+type FailPolicy int
+
+const (
+	// Rejects request with server error status. Default
+	FailClosed FailPolicy = iota
+	// Allows request
+	FailOpen
+	// Limits request with in-process MemoryBucket
+	FailLocal
+	// Limits request with secondary bucket, see WithSecondary
+	FailSecondary
+)
+
+func (p FailPolicy) String() string {
+	switch p {
+	case FailClosed:
+		return "closed"
+	case FailOpen:
+		return "open"
+	case FailLocal:
+		return "local"
+	case FailSecondary:
+		return "secondary"
+	default:
+		return "unknown"
+	}
+}
+
+// Sets behaviour on storage errors. Errors are logged and
+// counted in metrics with every policy.
+//
+// FailLocal limits keys locally with limits of bucket (see RateReporter).
+// FailSecondary without secondary bucket (see WithSecondary) fails closed
+func WithFailPolicy(p FailPolicy) Option {
+	return func(l *Limiter) {
+		l.failPolicy = p
+	}
+}
+
+// Fails over to secondary bucket (e.g. standby redis or MemoryBucket)
+// while bucket fails, sets FailSecondary policy. Requests go back
+// to bucket after its first successful call (see WithCircuitBreaker
+// to probe it once per cooldown). Switches are reported
+// by OnFailover and OnRecover hooks and Stats.Degraded.
+//
+// Limiter closes secondary bucket on Close
+func WithSecondary(b Bucket) Option {
+	return func(l *Limiter) {
+		l.failPolicy = FailSecondary
+		l.secondary = b
+	}
+}
+
+// FailoverMetrics can be implemented by Metrics to count requests
+// limited by secondary bucket (FailSecondary)
+type FailoverMetrics interface {
+	FailedOver()
+}
+
+// Default max count of keys of local fallback bucket, see WithFallbackMaxKeys
+var DefaultFallbackMaxKeys = 100000
+
+// Sets count of service instances sharing storage. With FailLocal every
+// instance limits keys by itself while storage is down, so local limits
+// are divided by n to keep total rate close to configured one.
+// If n <= 1, local limits aren't scaled
+func WithFallbackInstances(n int) Option {
+	return func(l *Limiter) {
+		l.fallbackInstances = n
+	}
+}
+
+// Sets max count of keys kept by local fallback bucket of FailLocal,
+// least recently used keys are dropped above it.
+// If n <= 0, uses DefaultFallbackMaxKeys
+func WithFallbackMaxKeys(n int) Option {
+	return func(l *Limiter) {
+		l.fallbackMaxKeys = n
+	}
+}
+
+// Creates local fallback bucket with limits of main bucket
+func (l *Limiter) newFallback() *MemoryBucket {
+	cfg := BucketConfigs{MaxKeys: l.fallbackMaxKeys}
+	if cfg.MaxKeys <= 0 {
+		cfg.MaxKeys = DefaultFallbackMaxKeys
+	}
+	if r, ok := BucketAs[RateReporter](l.bucket); ok {
+		rate := r.Rate()
+		cfg.Capability = rate.Capacity
+		cfg.TokensExist = rate.TTL
+		cfg.TokensAppendDuration = rate.Refill
+	}
+	return newMemoryBucket(cfg)
+}
+
+// Walks through local fallback bucket with limits scaled by instance count.
+// cause is error of storage
+func (l *Limiter) walkFallback(ctx context.Context, opts WalkOptions, cause error) (Result, error) {
+	if !l.degraded.Swap(true) {
+		id := RequestIDFromContext(ctx)
+		l.stats.fallbacks.Add(1)
+		l.log(id).Warn("storage unavailable, limiting locally", F("instances", l.fallbackInstances))
+		l.hooks.emit(hookFailover, Event{Time: time.Now(), Err: cause, RequestID: id})
+	}
+
+	opts.Rate = opts.Rate.withDefaults(l.fallback.Rate())
+	if n := l.fallbackInstances; n > 1 {
+		opts.Rate.Capacity = max(opts.Rate.Capacity/n, 1)
+		opts.Rate.Refill *= time.Duration(n)
+	}
+	_, res, _, err := l.walk(ctx, l.fallback, opts)
+	return res, err
+}
+
+// Walks through secondary bucket. Errors of secondary bucket are
+// returned as is, so request is rejected with server error.
+// cause is error of storage
+func (l *Limiter) walkSecondary(ctx context.Context, req Request, opts WalkOptions, cause error) (Result, error) {
+	if !l.degraded.Swap(true) {
+		l.stats.fallbacks.Add(1)
+		l.log(req.RequestID).Warn("storage unavailable, failing over to secondary bucket", F("error", cause))
+		l.hooks.emit(hookFailover, Event{Time: time.Now(), Err: cause, RequestID: req.RequestID})
+	}
+
+	stats, res, latency, err := l.walk(ctx, l.secondary, opts)
+	l.storageCalled(stats, latency, err)
+	if err != nil && !errors.Is(err, ErrNoTokensAwailable) {
+		l.metrics.Errored()
+		l.stats.errored.Add(1)
+		l.log(req.RequestID).Error("secondary storage error", F("error", err), F("key", req.Key), F("backend", stats.Backend))
+		e := l.newEvent(req)
+		e.Err = err
+		l.hooks.emit(hookStorageError, e)
+	}
+	return res, err
+}
+
+// Drops local fallback state after storage recovered,
+// so keys are limited by storage state again.
+// req is first request served by storage
+func (l *Limiter) recovered(req Request) {
+	if !l.degraded.CompareAndSwap(true, false) {
+		return
+	}
+	if l.fallback != nil {
+		l.fallback.Close()
+		l.log(req.RequestID).Info("storage recovered, local limits dropped")
+	} else {
+		l.log(req.RequestID).Info("storage recovered, secondary bucket isn't used")
+	}
+	l.hooks.emit(hookRecover, Event{Time: time.Now(), RequestID: req.RequestID})
+}
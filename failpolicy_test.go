@@ -0,0 +1,83 @@
+package gincage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	gincage "github.com/fyx1t/gin-cage"
+)
+
+var errStorageDown = errors.New("storage is down")
+
+// downBucket: bucket of unreachable storage
+type downBucket struct{}
+
+func (downBucket) Take(ctx context.Context, key string, n int) (gincage.Result, error) {
+	return gincage.Result{}, errStorageDown
+}
+
+func (downBucket) Ping(ctx context.Context) error {
+	return errStorageDown
+}
+
+func (downBucket) Close() error {
+	return nil
+}
+
+func TestFailPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []gincage.Option
+		allowed bool
+		healthy bool
+	}{
+		{
+			name: "closed",
+			opts: []gincage.Option{gincage.WithFailPolicy(gincage.FailClosed)},
+		},
+		{
+			name:    "open",
+			opts:    []gincage.Option{gincage.WithFailPolicy(gincage.FailOpen)},
+			allowed: true,
+		},
+		{
+			name:    "local",
+			opts:    []gincage.Option{gincage.WithFailPolicy(gincage.FailLocal)},
+			allowed: true,
+			healthy: true,
+		},
+		{
+			name:    "secondary",
+			opts:    []gincage.Option{gincage.WithSecondary(gincage.NewMemoryBucket(gincage.BucketConfigs{}))},
+			allowed: true,
+			healthy: true,
+		},
+		{
+			name: "secondary without secondary bucket fails closed",
+			opts: []gincage.Option{gincage.WithFailPolicy(gincage.FailSecondary)},
+		},
+		{
+			name: "down secondary bucket",
+			opts: []gincage.Option{gincage.WithSecondary(downBucket{})},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := gincage.NewLimiter(downBucket{}, tt.opts...)
+			defer l.Close(context.Background())
+
+			ctx := context.Background()
+			d := l.Allow(ctx, gincage.Request{Key: "10.0.0.1", Path: "/"})
+			if d.Allowed != tt.allowed {
+				t.Errorf("Allowed = %v, want %v", d.Allowed, tt.allowed)
+			}
+			if !d.Allowed && !errors.Is(d.Err, errStorageDown) {
+				t.Errorf("Err = %v, want %v", d.Err, errStorageDown)
+			}
+			if err := l.Healthy(ctx); (err == nil) != tt.healthy {
+				t.Errorf("Healthy() = %v, want healthy %v", err, tt.healthy)
+			}
+		})
+	}
+}
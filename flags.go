@@ -0,0 +1,211 @@
+package gincage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis key of flags, see FlagStore
+const flagsKey = "gincage-flags"
+
+// Default time flags are cached by limiter
+var DefaultFlagsTTL = time.Duration(time.Second)
+
+// Returned in decisions of requests rejected by Flags.Maintenance.
+// Such requests are rendered with 503 Service Unavailable
+var ErrMaintenance = errors.New("service is under maintenance")
+
+// Default body of responses rejected by Flags.Maintenance
+var DefaultMaintenanceError = struct {
+	Error string `json:"error"`
+}{
+	Error: "service is under maintenance",
+}
+
+// Flags: emergency switches shared by instances through bucket,
+// see WithFlags and Limiter.SetFlags
+type Flags struct {
+	// Rejects all requests with 503 Service Unavailable
+	Maintenance bool `json:"maintenance,omitempty"`
+	// Allows all requests without limiting
+	Bypass bool `json:"bypass,omitempty"`
+	// Flags of routes, replace global ones on matching routes.
+	// First matching rule wins
+	Routes []RouteFlags `json:"routes,omitempty"`
+}
+
+// RouteFlags: flags of routes matching Path
+type RouteFlags struct {
+	// gin route pattern like "/users/:id".
+	// Pattern ending with "*" matches every route with such prefix
+	Path        string `json:"path"`
+	Maintenance bool   `json:"maintenance,omitempty"`
+	Bypass      bool   `json:"bypass,omitempty"`
+}
+
+// Returns flags of route
+func (f Flags) of(route string) (maintenance, bypass bool) {
+	for _, r := range f.Routes {
+		if (RouteRule{Path: r.Path}).matchPath(route) {
+			return r.Maintenance, r.Bypass
+		}
+	}
+	return f.Maintenance, f.Bypass
+}
+
+func (f Flags) clone() Flags {
+	f.Routes = slices.Clone(f.Routes)
+	return f
+}
+
+// FlagStore can be implemented by Bucket to share Flags by instances
+type FlagStore interface {
+	Flags(ctx context.Context) (Flags, error)
+	SetFlags(ctx context.Context, f Flags) error
+}
+
+// Enables checks of flags stored by bucket (see Flags), bucket should
+// implement FlagStore. Flags are cached for ttl, so storage isn't called
+// on every request and flags set by other instances apply after ttl.
+// If ttl <= 0, uses DefaultFlagsTTL.
+//
+// Skipped paths aren't checked. Maintenance is applied in shadow mode too
+func WithFlags(ttl time.Duration) Option {
+	return func(l *Limiter) {
+		if ttl <= 0 {
+			ttl = DefaultFlagsTTL
+		}
+		l.flags = &flagCache{ttl: ttl}
+	}
+}
+
+type flagCache struct {
+	ttl time.Duration
+
+	mu    sync.Mutex
+	next  time.Time
+	value atomic.Pointer[Flags]
+}
+
+// Returns flags stored by bucket
+func (l *Limiter) Flags(ctx context.Context) (Flags, error) {
+	s, ok := BucketAs[FlagStore](l.bucket)
+	if !ok {
+		return Flags{}, errors.ErrUnsupported
+	}
+	return s.Flags(ctx)
+}
+
+// Stores flags in bucket, so they are applied by all instances
+// checking flags (see WithFlags). Cache of limiter is updated at once
+func (l *Limiter) SetFlags(ctx context.Context, f Flags) error {
+	s, ok := BucketAs[FlagStore](l.bucket)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	f = f.clone()
+	if err := s.SetFlags(ctx, f); err != nil {
+		return err
+	}
+	if l.flags != nil {
+		l.flags.value.Store(&f)
+	}
+	l.logger.Warn("limiter flags changed", F("maintenance", f.Maintenance), F("bypass", f.Bypass), F("routes", len(f.Routes)))
+	return nil
+}
+
+// Returns cached flags, reloading them if ttl passed.
+// Only one request reloads flags, others use previous ones
+func (l *Limiter) cachedFlags(ctx context.Context) Flags {
+	c := l.flags
+	if now := time.Now(); now.After(c.next) && c.mu.TryLock() {
+		if now.After(c.next) {
+			c.next = now.Add(c.ttl)
+			sctx, cancel := l.storageContext(ctx)
+			f, err := l.Flags(sctx)
+			cancel()
+			if err != nil {
+				// previous flags are kept
+				l.logger.Error("failed to load limiter flags", F("error", err))
+			} else {
+				c.value.Store(&f)
+			}
+		}
+		c.mu.Unlock()
+	}
+	if f := c.value.Load(); f != nil {
+		return *f
+	}
+	return Flags{}
+}
+
+// Returns decision forced by flags, false if request is limited as usual
+func (l *Limiter) flagged(ctx context.Context, cfg *Config, req Request) (Decision, bool) {
+	if l.flags == nil || l.skip.match(req.Path) || cfg.skip.match(req.Path) {
+		return Decision{}, false
+	}
+	route := req.Route
+	if route == "" {
+		route = req.Path
+	}
+	switch maintenance, bypass := l.cachedFlags(ctx).of(route); {
+	case maintenance:
+		return Decision{Err: ErrMaintenance}, true
+	case bypass:
+		return Decision{Allowed: true}, true
+	}
+	return Decision{}, false
+}
+
+// Returns flags stored in memory
+func (b *MemoryBucket) Flags(ctx context.Context) (Flags, error) {
+	if f := b.flags.Load(); f != nil {
+		return f.clone(), nil
+	}
+	return Flags{}, nil
+}
+
+// Stores flags in memory
+func (b *MemoryBucket) SetFlags(ctx context.Context, f Flags) error {
+	f = f.clone()
+	b.flags.Store(&f)
+	return nil
+}
+
+// Returns flags stored in redis, zero flags if they aren't set
+func (b RedisBucket) Flags(ctx context.Context) (Flags, error) {
+	if b.core == nil {
+		return Flags{}, errNilCore
+	}
+	data, err := b.core.Get(ctx, flagsKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return Flags{}, nil
+	}
+	if err != nil {
+		return Flags{}, err
+	}
+	var f Flags
+	if err := json.Unmarshal(data, &f); err != nil {
+		return Flags{}, ErrBadSyntaxInStorage
+	}
+	return f, nil
+}
+
+// Stores flags in redis as JSON
+func (b RedisBucket) SetFlags(ctx context.Context, f Flags) error {
+	if b.core == nil {
+		return errNilCore
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return b.core.Set(ctx, flagsKey, data, 0).Err()
+}
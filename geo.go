@@ -0,0 +1,80 @@
+package gincage
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// GeoInfo: location of client ip
+type GeoInfo struct {
+	// ISO 3166-1 alpha-2 country code, e.g. "US"
+	Country string
+	// Autonomous system number of network
+	ASN uint
+}
+
+// GeoResolver: locates client ips (see gincagegeoip for MaxMind databases)
+type GeoResolver interface {
+	Resolve(ip netip.Addr) (GeoInfo, error)
+}
+
+// GeoRule: limit of clients from countries or networks
+type GeoRule struct {
+	// ISO country codes
+	Countries []string `json:"countries,omitempty"`
+	// Autonomous system numbers
+	ASNs []uint `json:"asns,omitempty"`
+	// Limit of matching clients. Zero fields are taken from plan
+	// and key limits. Route rules override it on their routes
+	Rate Rate `json:"rate"`
+}
+
+// Sets resolver used to match clients against Config.GeoRules
+func WithGeoResolver(r GeoResolver) Option {
+	return func(l *Limiter) {
+		l.geo = r
+	}
+}
+
+// Returns limit of first geo rule matching client ip of req,
+// zero rate if no rule matches
+func (l *Limiter) geoLimits(cfg *Config, req Request) Rate {
+	ip := req.IP
+	if l.geo == nil || len(cfg.GeoRules) == 0 || ip == "" {
+		return Rate{}
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Rate{}
+	}
+	info, err := l.geo.Resolve(addr.Unmap())
+	if err != nil {
+		l.log(req.RequestID).Debug("failed to resolve client location", F("error", err), F("ip", ip))
+		return Rate{}
+	}
+	for _, r := range cfg.GeoRules {
+		if r.match(info) {
+			return r.Rate
+		}
+	}
+	return Rate{}
+}
+
+func (r GeoRule) match(info GeoInfo) bool {
+	if info.Country != "" && slices.Contains(r.Countries, strings.ToUpper(info.Country)) {
+		return true
+	}
+	return info.ASN != 0 && slices.Contains(r.ASNs, info.ASN)
+}
+
+func (r GeoRule) validate() error {
+	if len(r.Countries) == 0 && len(r.ASNs) == 0 {
+		return fmt.Errorf("countries or asns should be set")
+	}
+	if err := r.Rate.validate(); err != nil {
+		return fmt.Errorf("rate.%w", err)
+	}
+	return nil
+}
@@ -13,7 +13,8 @@
 //	 if err != nil {
 //		 return err
 //	 }
-//	 limiter := gincage.NewLimiter(
+//	 limiter := gincage.NewLimiter(bucket,
+//		 gincage.WithLogger(logger),
 //		 ...
 //	 )
 //	 router.Use(limiter.WalkThrough())
@@ -36,7 +37,10 @@ package gincage
 import (
 	"context"
 	"errors"
-	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -54,32 +58,607 @@ var (
 	}
 )
 
-type limiter struct {
+// Limiter: gin middleware limiting requests rate of clients with Bucket.
+// Also works with net/http (see Middleware) and other frameworks (see Allow).
+//
+// Should be created with NewLimiter or LoadConfig.
+// Limiter is safe for concurrent use
+type Limiter struct {
 	bucket               Bucket
-	logger               io.Writer
+	logger               Logger
+	keyFunc              KeyFunc
+	httpKeyFunc          HTTPKeyFunc
 	serverError          any
 	tooManyRequestsError any
+
+	serverErrorStatus     int
+	tooManyRequestsStatus int
+
+	problem *problemResponder
+	metrics Metrics
+	hooks   hooks
+
+	rateLimitHeaders bool
+	shadowHeader     string
+
+	banPolicy *BanPolicy
+	admission *admissionFilter
+	load      *loadShedder
+	conns     *ConnPolicy
+	flags     *flagCache
+	greylist  *GreylistPolicy
+	tarpits   *tarpit
+	challenge *ChallengePolicy
+	banner    Banner
+
+	adminAuth AdminAuth
+	top       *topTracker
+	stats     *statsCounter
+	config    atomic.Pointer[Config]
+
+	// config set with WithConfig, applied after all options
+	initialConfig *Config
+	// name of expvar variable, empty if it isn't published
+	expvarName string
+
+	// paths set with WithSkipPaths
+	skip          *pathMatcher
+	skipFuncs     []SkipFunc
+	httpSkipFuncs []HTTPSkipFunc
+
+	limits   LimitProvider
+	planFunc PlanFunc
+	geo      GeoResolver
+
+	priorityFunc   PriorityFunc
+	priorityHeader string
+	costFunc       CostFunc
+	keyHash        *sync.Pool
+
+	// request id sources, see WithRequestIDHeader
+	requestIDHeader string
+	requestIDKey    string
+
+	failPolicy FailPolicy
+	fallback   *MemoryBucket
+	secondary  Bucket
+	breaker    *circuitBreaker
+
+	fallbackInstances int
+	fallbackMaxKeys   int
+	storageTimeout    time.Duration
+	maxWait           time.Duration
+	janitorInterval   time.Duration
+	// true while requests are limited by fallback
+	degraded atomic.Bool
+
+	// canceled by Close to stop background goroutines
+	closeCtx    context.Context
+	closeCancel context.CancelFunc
+	wg          sync.WaitGroup
+	closeOnce   sync.Once
+	closeErr    error
 }
 
-func NewLimiter(ctx context.Context, bucket Bucket, logger io.Writer, serverError, tooManyRequestsError any) limiter {
-	return limiter{
-		bucket:               bucket,
-		logger:               logger,
-		serverError:          serverError,
-		tooManyRequestsError: tooManyRequestsError,
+// Creates limiter on top of bucket.
+//
+// By default clients are keyed by ip, logs are discarded
+// and DefaultServerError, DefaultTooManyRequestsError are used as bodies
+func NewLimiter(bucket Bucket, opts ...Option) *Limiter {
+	l := &Limiter{
+		bucket:                bucket,
+		logger:                NopLogger{},
+		keyFunc:               ClientIPKey,
+		httpKeyFunc:           RemoteIPKey,
+		serverError:           DefaultServerError,
+		tooManyRequestsError:  DefaultTooManyRequestsError,
+		serverErrorStatus:     http.StatusInternalServerError,
+		tooManyRequestsStatus: http.StatusTooManyRequests,
+		metrics:               NopMetrics{},
+		stats:                 newStatsCounter(),
+	}
+	l.closeCtx, l.closeCancel = context.WithCancel(context.Background())
+	l.config.Store(&Config{})
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	if l.failPolicy == FailSecondary && l.secondary == nil {
+		l.logger.Warn("fail policy secondary is set without secondary bucket, failing closed")
+		l.failPolicy = FailClosed
+	}
+	if l.failPolicy == FailLocal {
+		l.fallback = l.newFallback()
+	}
+	l.startJanitor()
+
+	if l.initialConfig != nil {
+		if err := l.UpdateConfig(*l.initialConfig); err != nil {
+			l.logger.Error("invalid limiter config, ignored", F("error", err))
+		}
+		l.initialConfig = nil
 	}
+
+	if l.banPolicy != nil || l.greylist != nil || l.challenge != nil {
+		banner, ok := BucketAs[Banner](bucket)
+		if !ok {
+			l.logger.Warn("bucket doesn't support bans, ban policy, greylist and challenges are ignored")
+			l.banPolicy = nil
+			l.greylist = nil
+			l.challenge = nil
+		}
+		l.banner = banner
+	}
+	l.publishExpvar()
+	return l
+}
+
+// Deprecated: use NewLimiter with WithLogger and WithErrorBody.
+//
+// If logger is nil, logs are discarded
+func NewLegacyLimiter(ctx context.Context, bucket Bucket, logger Logger, serverError, tooManyRequestsError any, opts ...Option) *Limiter {
+	return NewLimiter(bucket, append([]Option{
+		WithLogger(logger),
+		WithErrorBody(serverError, tooManyRequestsError),
+	}, opts...)...)
+}
+
+// Request: framework-agnostic description of request checked by Allow
+type Request struct {
+	// Client key (ip by default)
+	Key string
+	// Client ip checked against allowlist. If empty, allowlist isn't checked
+	IP     string
+	Method string
+	// Request path, reported in hook events
+	Path string
+	// Route pattern matched by route rules (e.g. "/users/:id").
+	// If empty, Path is used
+	Route string
+	// Plan of client, see Config.Plans
+	Plan string
+	// User-Agent header matched by Config.AgentRules
+	UserAgent string
+	// Priority class of request, see Config.Priorities
+	Priority string
+	// OAuth scopes of client token, see Config.Scopes.
+	// If nil, scopes attached to context are used (see ContextWithScopes)
+	Scopes []string
+	// Returns route param of request (e.g. "acme" for "org_id" of
+	// "/orgs/:org_id"), empty if there is no such param.
+	// Used by RouteRule.KeyParams, nil if adapter doesn't provide params
+	Param func(name string) string
+	// Returns header of request, empty if there is no such header.
+	// Used by Rule.Headers, nil if adapter doesn't provide headers
+	Header func(name string) string
+	// Tokens taken by request (e.g. by complexity of query), see WithCostFunc.
+	// If <= 0, one token is taken
+	Cost int
+	// Id correlating log lines, hook events and trace spans of request
+	// (see WithRequestIDHeader). If empty, id attached to context is used
+	// (see ContextWithRequestID)
+	RequestID string
+
+	// request isn't rejected, see Config.Shadow
+	shadow bool
+}
+
+// Decision: result of Allow
+type Decision struct {
+	// True if request can go on
+	Allowed bool
+	// *LimitExceededError (matching ErrNoTokensAwailable) if rate
+	// was limited or key is banned,
+	// storage error if request was rejected by fail policy
+	Err error
+	// Time after request can be retried. Zero if unknown
+	RetryAfter time.Duration
+	// True if request would be rejected, but was allowed in shadow mode
+	Shadow bool
+	// True if key is challenged, Render responds with challenge
+	// (see WithChallenge)
+	Challenged bool
+	// Capacity of key. Zero if unknown
+	Limit int
+	// Tokens of key left, valid if Limit > 0
+	Remaining int
+	// Quotas applied to request (e.g. "10;w=60, 1000;w=1"),
+	// set if WithRateLimitHeaders is used
+	Policy string
+
+	// tokens taken from bucket, see Limiter.Adjust
+	taken []KeyedCost
+}
+
+// Returns true if request was rejected because rate was limited
+func (d Decision) Limited() bool {
+	return errors.Is(d.Err, ErrNoTokensAwailable)
 }
 
 // Returns HTTP 429 Too Many Requests if rate was limited
-func (l limiter) WalkThrough() gin.HandlerFunc {
+// (or status set with WithTooManyRequestsStatus)
+func (l *Limiter) WalkThrough() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		if err := l.bucket.Walk(ctx); err != nil {
-			if errors.Is(err, ErrNoTokensAwailable) {
-				ctx.AbortWithStatusJSON(429, l.tooManyRequestsError)
-				return
+		req, ok := l.ginRequest(ctx)
+		if !ok {
+			return
+		}
+		d := l.Allow(requestContext(ctx), req)
+		if d.Allowed {
+			for k, v := range l.Header(d) {
+				ctx.Writer.Header()[k] = v
 			}
-			l.logger.Write([]byte(err.Error()))
-			ctx.AbortWithStatusJSON(500, l.serverError)
+			if len(d.taken) > 0 {
+				ctx.Next()
+				l.settle(ctx, req, d)
+			}
+			return
 		}
+		ctx.Abort()
+		l.Render(d).Write(ctx.Writer)
+	}
+}
+
+// Builds Request of gin request. Returns false if request is skipped
+// by skip functions
+func (l *Limiter) ginRequest(ctx *gin.Context) (Request, bool) {
+	for _, skip := range l.skipFuncs {
+		if skip(ctx) {
+			return Request{}, false
+		}
+	}
+	req := newRequest(ctx.Request, l.keyFunc(ctx), ctx.ClientIP(), ctx.FullPath())
+	req.Plan = l.plan(ctx)
+	req.Priority = l.priority(ctx)
+	req.Param = ctx.Param
+	req.Cost = l.cost(ctx.Request)
+	req.RequestID = l.ginRequestID(ctx)
+	return req, true
+}
+
+// Returns request context of ctx, so storage calls can be
+// canceled with request and traced
+func requestContext(ctx *gin.Context) context.Context {
+	if ctx.Request == nil {
+		return ctx
+	}
+	return ctx.Request.Context()
+}
+
+// Checks request against limits, so limiter can be used with any framework.
+// Calls storage, updates metrics and emits hooks.
+//
+// Adapters respond to rejected requests with Render
+func (l *Limiter) Allow(ctx context.Context, req Request) Decision {
+	if req.RequestID == "" {
+		req.RequestID = RequestIDFromContext(ctx)
+	} else {
+		ctx = ContextWithRequestID(ctx, req.RequestID)
+	}
+	cfg := l.config.Load()
+	if d, ok := l.flagged(ctx, cfg, req); ok {
+		return d
+	}
+	req.shadow = cfg.Shadow || !cfg.enforced(req.Key)
+	cfg = cfg.at(time.Now())
+	d := l.allow(ctx, cfg, req)
+	if req.shadow && !d.Allowed {
+		return l.shadowed(req, d)
+	}
+	return d
+}
+
+func (l *Limiter) allow(ctx context.Context, cfg *Config, req Request) Decision {
+	if l.skip.match(req.Path) || cfg.skip.match(req.Path) {
+		return Decision{Allowed: true}
+	}
+	if req.IP != "" && cfg.allowed(req.IP) {
+		return Decision{Allowed: true}
+	}
+	key := req.Key
+	req.Key = l.hashKey(req.Key)
+	rule, ruled := cfg.matchRule(req)
+	if ruled {
+		l.ruleMatched(rule)
+		switch rule.Action {
+		case RuleSkip:
+			return Decision{Allowed: true}
+		case RuleDeny:
+			l.rejected(req)
+			return Decision{Err: &LimitExceededError{Key: req.Key}}
+		}
+	}
+	limits := Rate{}
+	if r, ok := cfg.matchAgent(req.UserAgent); ok {
+		l.agentMatched(r)
+		if r.Deny {
+			l.rejected(req)
+			return Decision{Err: &LimitExceededError{Key: req.Key}}
+		}
+		limits = r.Rate
+	}
+	if req.Scopes == nil {
+		req.Scopes = ScopesFromContext(ctx)
+	}
+	scope, scoped := cfg.matchScope(req.Scopes)
+	limits = limits.withDefaults(l.geoLimits(cfg, req)).
+		withDefaults(l.keyLimits(ctx, key)).
+		withDefaults(scope.Rate).
+		withDefaults(cfg.planRate(req.Plan))
+	opts := cfg.walkOptions(req, limits)
+	if n, ok := cfg.matchNetwork(req.IP); ok {
+		l.stats.network(n.Name)
+		opts.Key = n.Name + "|" + req.Key
+		opts.Rate = n.Rate.withDefaults(limits).withDefaults(cfg.Rate)
+	}
+	if ruled {
+		// rule names can't clash with paths of route rules
+		opts.Key = rule.Name + "|" + req.Key
+		opts.Rate = rule.Rate.withDefaults(limits).withDefaults(cfg.Rate)
+	}
+	opts.Rate = l.shed(opts.Rate)
+
+	d := l.check(ctx, cfg, req, opts)
+	if scoped {
+		l.scopeMatched(scope, d.Allowed)
+	}
+	if l.rateLimitHeaders {
+		rates := []Rate{opts.Rate}
+		if cfg.Global.Capacity > 0 {
+			rates = append(rates, cfg.Global)
+		}
+		d.Policy = l.policy(rates...)
+	}
+	return d
+}
+
+// Checks request of key in opts against admission filter, bans and bucket
+func (l *Limiter) check(ctx context.Context, cfg *Config, req Request, opts WalkOptions) Decision {
+	if l.admission.reject(opts.Key) {
+		return l.admissionRejected(req, opts)
+	}
+
+	ok, probe := l.breaker.allow()
+	defer l.breaker.release(probe)
+	if !ok {
+		l.metrics.Errored()
+		l.stats.errored.Add(1)
+		return l.fail(ctx, req, opts, ErrCircuitOpen)
+	}
+
+	if l.banner != nil {
+		sctx, cancel := l.storageContext(ctx)
+		until, penalty, err := l.bannedUntil(sctx, req.Key)
+		cancel()
+		if err != nil {
+			l.storageError(req, "", 0, err)
+			// ban check is skipped when policy allows to go on
+			if l.failPolicy == FailClosed {
+				return Decision{Err: err}
+			}
+		}
+		if !until.IsZero() {
+			l.rejected(req)
+			if penalty && !req.shadow && l.greylist.repeat(until) {
+				l.tarpit(ctx, time.Until(until))
+			} else {
+				l.holdTarpit(ctx, req)
+			}
+			return Decision{Err: &LimitExceededError{Key: req.Key, Reset: until}, RetryAfter: time.Until(until)}
+		}
+	}
+
+	if l.challenge != nil && !req.shadow {
+		until, err := l.challengedUntil(ctx, req)
+		if err != nil {
+			l.storageError(req, "", 0, err)
+			if l.failPolicy == FailClosed {
+				return Decision{Err: err}
+			}
+		}
+		if !until.IsZero() {
+			l.rejected(req)
+			return Decision{
+				Err:        &LimitExceededError{Key: req.Key, Reset: until},
+				RetryAfter: time.Until(until),
+				Challenged: true,
+			}
+		}
+	}
+
+	var stats *WalkStats
+	var res Result
+	var latency time.Duration
+	var err error
+	if cfg.Global.Capacity > 0 {
+		stats, res, latency, err = l.walkGlobal(ctx, opts, cfg.Global)
+	} else {
+		stats, res, latency, err = l.walk(ctx, l.bucket, opts)
+	}
+	if l.maxWait > 0 && !req.shadow && errors.Is(err, ErrNoTokensAwailable) && !errors.Is(err, ErrGlobalLimit) {
+		stats, res, latency, err = l.wait(ctx, opts, stats, res, latency, err)
+	}
+	l.storageCalled(stats, latency, err)
+	if err != nil && !errors.Is(err, ErrNoTokensAwailable) {
+		l.storageError(req, stats.Backend, latency, err)
+		return l.fail(ctx, req, opts, err)
+	}
+	if l.breaker.success() {
+		l.log(req.RequestID).Info("circuit breaker closed")
+	}
+	l.recovered(req)
+	l.admission.record(opts.Key, errors.Is(err, ErrNoTokensAwailable) && !errors.Is(err, ErrGlobalLimit))
+	if errors.Is(err, ErrGlobalLimit) {
+		return l.rejectGlobal(req, cfg.Global, err)
+	}
+	d := l.decide(ctx, req, opts, res, err)
+	if d.Allowed {
+		d.taken = []KeyedCost{{Key: opts.Key, Cost: takeCount(opts.Cost), Rate: opts.Rate}}
+		if cfg.Global.Capacity > 0 {
+			d.taken = append(d.taken, KeyedCost{Key: globalKey, Cost: takeCount(opts.Cost), Rate: cfg.Global})
+		}
+	}
+	return d
+}
+
+// Handles request with fail policy when storage is unavailable
+func (l *Limiter) fail(ctx context.Context, req Request, opts WalkOptions, err error) Decision {
+	switch l.failPolicy {
+	case FailOpen:
+		l.metrics.FailedOpen()
+		return Decision{Allowed: true}
+	case FailLocal:
+		l.metrics.LocalFallback()
+		res, err := l.walkFallback(ctx, opts, err)
+		return l.decide(ctx, req, opts, res, err)
+	case FailSecondary:
+		if m, ok := l.metrics.(FailoverMetrics); ok {
+			m.FailedOver()
+		}
+		res, err := l.walkSecondary(ctx, req, opts, err)
+		if err != nil && !errors.Is(err, ErrNoTokensAwailable) {
+			return Decision{Err: err}
+		}
+		return l.decide(ctx, req, opts, res, err)
+	default:
+		return Decision{Err: err}
+	}
+}
+
+// Allows request if bucket walk succeeded, rejects it otherwise
+func (l *Limiter) decide(ctx context.Context, req Request, opts WalkOptions, res Result, err error) Decision {
+	if err != nil {
+		return l.reject(ctx, req, opts.Rate, err)
+	}
+	l.metrics.Allowed()
+	l.stats.allowed.Add(1)
+	l.track(req.Key, true)
+	l.hooks.emit(hookAllow, l.newEvent(req))
+	d := Decision{Allowed: true}
+	if res.Remaining >= 0 {
+		d.Limit, d.Remaining = res.Limit, res.Remaining
+	}
+	return d
+}
+
+// Takes token of key collecting stats reported by bucket.
+// Denied result is returned with *LimitExceededError
+func (l *Limiter) walk(ctx context.Context, bucket Bucket, opts WalkOptions) (*WalkStats, Result, time.Duration, error) {
+	stats := &WalkStats{}
+	ctx, cancel := l.storageContext(ctx)
+	defer cancel()
+	ctx = ContextWithWalkStats(ctx, stats)
+	ctx = ContextWithWalkOptions(ctx, opts)
+
+	start := time.Now()
+	res, err := bucket.Take(ctx, opts.Key, opts.Cost)
+	latency := time.Since(start)
+	if err == nil && deprioritized(opts, res) {
+		// token is spent, but request is rejected as bucket should have done
+		res.Allowed = false
+	}
+	if err == nil && !res.Allowed {
+		err = res.limitError(opts.Key)
+	}
+	return stats, res, latency, err
+}
+
+// Returns ctx limited by storage timeout if it is set
+func (l *Limiter) storageContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if l.storageTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, l.storageTimeout)
+}
+
+// Rejects request because bucket has no tokens for key.
+// err is returned in decision, its reset time is used for retry after
+func (l *Limiter) reject(ctx context.Context, req Request, rate Rate, err error) Decision {
+	l.rejected(req)
+
+	retryAfter, ok := retryAfterOf(err)
+	if !ok {
+		retryAfter = rate.Refill
+	}
+	if r, ok := BucketAs[RefillIntervaler](l.bucket); ok && retryAfter <= 0 {
+		retryAfter = r.RefillInterval()
+	}
+	if req.shadow {
+		// not enforced requests aren't penalized
+		return l.limitDecision(req, rate, err, retryAfter)
+	}
+	var repeat bool
+	if l.greylist != nil {
+		var d time.Duration
+		if d, repeat = l.registerOffense(ctx, req); d > 0 {
+			retryAfter = d
+		}
+	}
+	if l.banPolicy != nil {
+		if d := l.registerViolation(ctx, req); d > 0 {
+			retryAfter = max(retryAfter, d)
+		}
+	}
+	if l.challenge != nil && l.registerChallenge(ctx, req) {
+		// challenged clients may be humans, they aren't slowed down
+		d := l.limitDecision(req, rate, err, retryAfter)
+		d.Challenged = true
+		return d
+	}
+	if repeat {
+		l.tarpit(ctx, retryAfter)
+	} else {
+		l.holdTarpit(ctx, req)
+	}
+	return l.limitDecision(req, rate, err, retryAfter)
+}
+
+// Returns decision rejecting request with limit error
+func (l *Limiter) limitDecision(req Request, rate Rate, err error, retryAfter time.Duration) Decision {
+	var le *LimitExceededError
+	if !errors.As(err, &le) {
+		le = &LimitExceededError{Key: req.Key, Limit: rate.Capacity}
+	}
+	return Decision{Err: le, RetryAfter: retryAfter, Limit: le.Limit, Remaining: le.Remaining}
+}
+
+// Counts rejected request. Requests in shadow mode are counted when shadowed
+func (l *Limiter) rejected(req Request) {
+	if req.shadow {
+		return
+	}
+	l.metrics.Rejected()
+	l.stats.rejected.Add(1)
+	l.track(req.Key, false)
+	l.hooks.emit(hookReject, l.newEvent(req))
+}
+
+// Counts request of key in top consumers if tracking is enabled
+func (l *Limiter) track(key string, allowed bool) {
+	if l.top == nil {
+		return
+	}
+	if allowed {
+		l.top.add(key, 1, 0)
+		return
+	}
+	l.top.add(key, 0, 1)
+}
+
+// Counts and logs storage error. Response depends on fail policy
+func (l *Limiter) storageError(req Request, backend string, latency time.Duration, err error) {
+	l.metrics.Errored()
+	l.stats.errored.Add(1)
+	l.log(req.RequestID).Error("storage error",
+		F("error", err),
+		F("key", req.Key),
+		F("backend", backend),
+		F("latency", latency),
+	)
+	e := l.newEvent(req)
+	e.Err = err
+	l.hooks.emit(hookStorageError, e)
+	if l.breaker.failure() {
+		l.log(req.RequestID).Warn("circuit breaker opened", F("cooldown", l.breaker.cooldown))
 	}
 }
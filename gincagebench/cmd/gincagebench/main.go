@@ -0,0 +1,98 @@
+// Command gincagebench measures latency added by gincage limiter
+// and storage commands per request.
+//
+// Usage:
+//
+//	gincagebench -backend redis -addr localhost:6379 -concurrency 64 -keys 10000 -mode coalesce
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/alicebob/miniredis/v2"
+	gincage "github.com/fyx1t/gin-cage"
+	"github.com/fyx1t/gin-cage/gincagebench"
+	"github.com/redis/go-redis/v9"
+)
+
+func main() {
+	var (
+		backend     = flag.String("backend", "memory", "bucket backend: memory, redis, miniredis")
+		addr        = flag.String("addr", "localhost:6379", "redis address")
+		password    = flag.String("password", os.Getenv("REDIS_PASSWORD"), "redis password")
+		mode        = flag.String("mode", "plain", "bucket decorator: plain, coalesce, write-behind")
+		concurrency = flag.Int("concurrency", gincagebench.DefaultConcurrency, "concurrent clients")
+		keys        = flag.Int("keys", gincagebench.DefaultKeys, "distinct keys")
+		requests    = flag.Int("requests", gincagebench.DefaultRequests, "requests sent by all clients")
+		duration    = flag.Duration("duration", 0, "stops run early if set")
+		capacity    = flag.Int("capacity", gincage.DefaultTokensCap, "tokens cap of key")
+		refill      = flag.Duration("refill", gincage.DefaultTokensAppendDuration, "time for new token append")
+	)
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, *backend, *addr, *password, *mode, gincage.BucketConfigs{
+		Capability:           *capacity,
+		TokensAppendDuration: *refill,
+	}, gincagebench.Configs{
+		Concurrency: *concurrency,
+		Keys:        *keys,
+		Requests:    *requests,
+		Duration:    *duration,
+	}); err != nil {
+		fmt.Fprintln(os.Stderr, "gincagebench:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, backend, addr, password, mode string, cfg gincage.BucketConfigs, bcfg gincagebench.Configs) error {
+	var bucket gincage.Bucket
+	switch backend {
+	case "memory":
+		bucket = gincage.NewMemoryBucket(cfg)
+	case "redis", "miniredis":
+		if backend == "miniredis" {
+			s, err := miniredis.Run()
+			if err != nil {
+				return err
+			}
+			defer s.Close()
+			addr = s.Addr()
+		}
+		client := redis.NewClient(&redis.Options{Addr: addr, Password: password})
+		if err := client.Ping(ctx).Err(); err != nil {
+			return err
+		}
+		bcfg.Ops = gincagebench.CountOps(client)
+		bucket = gincage.NewRedisBucketWithClient(cfg, client)
+	default:
+		return fmt.Errorf("unknown backend %q", backend)
+	}
+
+	switch mode {
+	case "plain":
+	case "coalesce":
+		bucket = gincage.NewCoalescingBucket(bucket)
+	case "write-behind":
+		bucket = gincage.NewWriteBehindBucket(bucket, gincage.WriteBehindConfigs{})
+	default:
+		bucket.Close()
+		return fmt.Errorf("unknown mode %q", mode)
+	}
+	defer bucket.Close()
+
+	fmt.Printf("backend %s, mode %s, %d clients, %d keys, capacity %d, refill %s\n",
+		backend, mode, bcfg.Concurrency, bcfg.Keys, cfg.Capability, cfg.TokensAppendDuration)
+	report, err := gincagebench.Run(ctx, bucket, bcfg)
+	if err != nil {
+		return err
+	}
+	fmt.Println(report)
+	return nil
+}
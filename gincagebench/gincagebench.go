@@ -0,0 +1,262 @@
+// Load generator measuring overhead of gincage limiter.
+//
+// Drives limiter middleware with concurrent requests of random keys
+// and reports latency added by limiter and storage commands per request,
+// so backends and bucket decorators can be compared:
+//
+//	ops := gincagebench.CountOps(redisClient)
+//	report, err := gincagebench.Run(ctx, bucket, gincagebench.Configs{
+//		Concurrency: 64,
+//		Keys:        10000,
+//		Requests:    100000,
+//		Ops:         ops,
+//	})
+//	fmt.Println(report)
+//
+// See cmd/gincagebench for command line tool
+package gincagebench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gincage "github.com/fyx1t/gin-cage"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// Default count of concurrent clients
+	DefaultConcurrency = 16
+	// Default count of distinct keys
+	DefaultKeys = 1000
+	// Default count of requests sent by all clients
+	DefaultRequests = 100000
+)
+
+// Header carrying key of generated request
+const keyHeader = "X-Gincage-Bench-Key"
+
+var errNoRequests = errors.New("gincagebench: no requests were sent")
+
+// Configs: settings of benchmark run
+type Configs struct {
+	// Count of concurrent clients. If <= 0, uses DefaultConcurrency
+	Concurrency int
+	// Count of distinct keys, every request takes random one.
+	// If <= 0, uses DefaultKeys
+	Keys int
+	// Count of requests sent by all clients. If <= 0, uses DefaultRequests
+	Requests int
+	// Stops run early if > 0
+	Duration time.Duration
+	// Counts storage commands. If nil, Report.Ops is zero
+	Ops *OpsCounter
+}
+
+// Report: result of benchmark run
+type Report struct {
+	Requests int
+	Allowed  int
+	Rejected int
+	Errored  int
+	// Wall time of run
+	Elapsed time.Duration
+	// Latency added by limiter to request
+	P50 time.Duration
+	P99 time.Duration
+	Max time.Duration
+	// Storage commands sent during run
+	Ops int64
+}
+
+// Returns requests per second
+func (r Report) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Requests) / r.Elapsed.Seconds()
+}
+
+// Returns storage commands per request
+func (r Report) OpsPerRequest() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Ops) / float64(r.Requests)
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf(
+		"requests %d (allowed %d, rejected %d, errored %d) in %s, %.0f req/s\n"+
+			"added latency p50 %s, p99 %s, max %s\n"+
+			"storage ops %d, %.2f per request",
+		r.Requests, r.Allowed, r.Rejected, r.Errored, r.Elapsed.Round(time.Millisecond), r.Throughput(),
+		r.P50, r.P99, r.Max,
+		r.Ops, r.OpsPerRequest(),
+	)
+}
+
+// Sends requests through limiter on top of bucket and measures it.
+// Options are passed to limiter, key func is replaced by generated keys
+func Run(ctx context.Context, bucket gincage.Bucket, cfg Configs, opts ...gincage.Option) (Report, error) {
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = DefaultConcurrency
+	}
+	if cfg.Keys <= 0 {
+		cfg.Keys = DefaultKeys
+	}
+	if cfg.Requests <= 0 {
+		cfg.Requests = DefaultRequests
+	}
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+
+	limiter := gincage.NewLimiter(bucket, append(opts, gincage.WithKeyFunc(func(ctx *gin.Context) string {
+		return ctx.GetHeader(keyHeader)
+	}))...)
+	router := newRouter(limiter)
+
+	keys := make([]string, cfg.Keys)
+	for i := range keys {
+		keys[i] = "bench-" + strconv.Itoa(i)
+	}
+
+	var opsBefore int64
+	if cfg.Ops != nil {
+		opsBefore = cfg.Ops.Load()
+	}
+
+	var (
+		sent    atomic.Int64
+		wg      sync.WaitGroup
+		results = make([]workerResult, cfg.Concurrency)
+	)
+	start := time.Now()
+	for w := range results {
+		wg.Add(1)
+		go func(res *workerResult) {
+			defer wg.Done()
+			for ctx.Err() == nil && sent.Add(1) <= int64(cfg.Requests) {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.Header.Set(keyHeader, keys[rand.N(len(keys))])
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, req)
+				res.add(rec)
+			}
+		}(&results[w])
+	}
+	wg.Wait()
+
+	report := Report{Elapsed: time.Since(start)}
+	if cfg.Ops != nil {
+		report.Ops = cfg.Ops.Load() - opsBefore
+	}
+	var latencies []time.Duration
+	for _, res := range results {
+		report.Allowed += res.allowed
+		report.Rejected += res.rejected
+		report.Errored += res.errored
+		latencies = append(latencies, res.latencies...)
+	}
+	report.Requests = len(latencies)
+	if report.Requests == 0 {
+		return report, errNoRequests
+	}
+	slices.Sort(latencies)
+	report.P50 = percentile(latencies, 0.5)
+	report.P99 = percentile(latencies, 0.99)
+	report.Max = latencies[len(latencies)-1]
+	return report, nil
+}
+
+// Latency header set by router, so worker doesn't share state with handlers
+const latencyHeader = "X-Gincage-Bench-Latency"
+
+// Returns router measuring time spent in limiter
+func newRouter(limiter *gincage.Limiter) http.Handler {
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	router.Use(func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+		// handler does nothing, so whole time is spent in limiter
+		ctx.Writer.Header().Set(latencyHeader, strconv.FormatInt(int64(time.Since(start)), 10))
+	})
+	router.Use(limiter.WalkThrough())
+	router.GET("/", func(ctx *gin.Context) {
+		ctx.Status(http.StatusNoContent)
+	})
+	return router
+}
+
+type workerResult struct {
+	allowed, rejected, errored int
+	latencies                  []time.Duration
+}
+
+func (r *workerResult) add(rec *httptest.ResponseRecorder) {
+	switch rec.Code {
+	case http.StatusNoContent:
+		r.allowed++
+	case http.StatusTooManyRequests:
+		r.rejected++
+	default:
+		r.errored++
+	}
+	n, _ := strconv.ParseInt(rec.Header().Get(latencyHeader), 10, 64)
+	r.latencies = append(r.latencies, time.Duration(n))
+}
+
+// Returns p-th percentile of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(float64(len(sorted)-1) * p)
+	return sorted[i]
+}
+
+// OpsCounter: counts commands sent by redis client
+type OpsCounter struct {
+	n atomic.Int64
+}
+
+// Installs hook counting commands of c, pipelined commands are counted one by one
+func CountOps(c *redis.Client) *OpsCounter {
+	o := &OpsCounter{}
+	c.AddHook(o)
+	return o
+}
+
+// Returns count of commands sent since CountOps
+func (o *OpsCounter) Load() int64 {
+	return o.n.Load()
+}
+
+func (o *OpsCounter) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (o *OpsCounter) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		o.n.Add(1)
+		return next(ctx, cmd)
+	}
+}
+
+func (o *OpsCounter) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		o.n.Add(int64(len(cmds)))
+		return next(ctx, cmds)
+	}
+}
@@ -0,0 +1,74 @@
+// Chi middleware for gincage limiter.
+//
+// Middleware shares bucket, limits and metrics with gin middleware:
+//
+//	limiter := gincage.NewLimiter(bucket)
+//	r := chi.NewRouter()
+//	r.Use(gincagechi.Middleware(limiter))
+//
+// Route rules are matched against chi route patterns like "/users/{id}"
+// when middleware is added to routes with r.With or r.Route, otherwise
+// against request path (chi routes request after router middlewares)
+package gincagechi
+
+import (
+	"net/http"
+
+	gincage "github.com/fyx1t/gin-cage"
+	"github.com/go-chi/chi/v5"
+)
+
+type config struct {
+	keyFunc gincage.HTTPKeyFunc
+}
+
+// Option: optional setting passed to Middleware
+type Option func(*config)
+
+// Sets function extracting client key from request.
+// Default is gincage.RemoteIPKey, use chi's middleware.RealIP
+// to key clients behind proxies. If f is nil, option is ignored
+func WithKeyFunc(f gincage.HTTPKeyFunc) Option {
+	return func(c *config) {
+		if f != nil {
+			c.keyFunc = f
+		}
+	}
+}
+
+// Returns chi middleware limiting requests with limiter
+func Middleware(l *gincage.Limiter, opts ...Option) func(http.Handler) http.Handler {
+	cfg := config{keyFunc: gincage.RemoteIPKey}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var route string
+			var param func(string) string
+			if rctx := chi.RouteContext(r.Context()); rctx != nil {
+				route = rctx.RoutePattern()
+				param = rctx.URLParam
+			}
+			d := l.Allow(r.Context(), gincage.Request{
+				Key:       cfg.keyFunc(r),
+				IP:        gincage.RemoteIPKey(r),
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Route:     route,
+				UserAgent: r.UserAgent(),
+				Param:     param,
+				Header:    r.Header.Get,
+			})
+			if !d.Allowed {
+				l.Render(d).Write(w)
+				return
+			}
+			for k, v := range l.Header(d) {
+				w.Header()[k] = v
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,72 @@
+// Echo middleware for gincage limiter.
+//
+// Middleware shares bucket, limits and metrics with gin middleware:
+//
+//	limiter := gincage.NewLimiter(bucket)
+//	e := echo.New()
+//	e.Use(gincageecho.Middleware(limiter))
+//
+// Route rules are matched against echo route patterns like "/users/:id"
+package gincageecho
+
+import (
+	gincage "github.com/fyx1t/gin-cage"
+	"github.com/labstack/echo/v4"
+)
+
+// KeyFunc: returns key identifying client of request
+type KeyFunc func(c echo.Context) string
+
+// Keys clients by ip resolved by echo (see echo.IPExtractor). Default KeyFunc
+func RealIPKey(c echo.Context) string {
+	return c.RealIP()
+}
+
+type config struct {
+	keyFunc KeyFunc
+}
+
+// Option: optional setting passed to Middleware
+type Option func(*config)
+
+// Sets function extracting client key from request.
+// Default is RealIPKey. If f is nil, option is ignored
+func WithKeyFunc(f KeyFunc) Option {
+	return func(c *config) {
+		if f != nil {
+			c.keyFunc = f
+		}
+	}
+}
+
+// Returns echo middleware limiting requests with limiter
+func Middleware(l *gincage.Limiter, opts ...Option) echo.MiddlewareFunc {
+	cfg := config{keyFunc: RealIPKey}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			d := l.Allow(req.Context(), gincage.Request{
+				Key:       cfg.keyFunc(c),
+				IP:        c.RealIP(),
+				Method:    req.Method,
+				Path:      req.URL.Path,
+				Route:     c.Path(),
+				UserAgent: req.UserAgent(),
+				Param:     c.Param,
+				Header:    req.Header.Get,
+			})
+			if d.Allowed {
+				for k, v := range l.Header(d) {
+					c.Response().Header()[k] = v
+				}
+				return next(c)
+			}
+			l.Render(d).Write(c.Response())
+			return nil
+		}
+	}
+}
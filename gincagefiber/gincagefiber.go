@@ -0,0 +1,81 @@
+// Fiber middleware for gincage limiter.
+//
+// Middleware shares bucket, limits and metrics with gin middleware:
+//
+//	limiter := gincage.NewLimiter(bucket)
+//	app := fiber.New()
+//	app.Use(gincagefiber.New(limiter))
+//
+// Route rules are matched against request path
+package gincagefiber
+
+import (
+	gincage "github.com/fyx1t/gin-cage"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// KeyFunc: returns key identifying client of request
+type KeyFunc func(c *fiber.Ctx) string
+
+// Keys clients by ip resolved by fiber (see fiber.Config.ProxyHeader).
+// Default KeyFunc
+func IPKey(c *fiber.Ctx) string {
+	return c.IP()
+}
+
+type config struct {
+	keyFunc KeyFunc
+}
+
+// Option: optional setting passed to New
+type Option func(*config)
+
+// Sets function extracting client key from request.
+// Default is IPKey. If f is nil, option is ignored
+func WithKeyFunc(f KeyFunc) Option {
+	return func(c *config) {
+		if f != nil {
+			c.keyFunc = f
+		}
+	}
+}
+
+// Returns fiber handler limiting requests with limiter
+func New(l *gincage.Limiter, opts ...Option) fiber.Handler {
+	cfg := config{keyFunc: IPKey}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(c *fiber.Ctx) error {
+		// fiber strings point to buffers reused after handler returns,
+		// limiter keeps keys and paths in its state
+		d := l.Allow(c.UserContext(), gincage.Request{
+			Key:       utils.CopyString(cfg.keyFunc(c)),
+			IP:        utils.CopyString(c.IP()),
+			Method:    utils.CopyString(c.Method()),
+			Path:      utils.CopyString(c.Path()),
+			UserAgent: utils.CopyString(c.Get(fiber.HeaderUserAgent)),
+			Header: func(name string) string {
+				return c.Get(name)
+			},
+		})
+		if d.Allowed {
+			for k, v := range l.Header(d) {
+				for _, s := range v {
+					c.Response().Header.Add(k, s)
+				}
+			}
+			return c.Next()
+		}
+
+		resp := l.Render(d)
+		for k, v := range resp.Header {
+			for _, s := range v {
+				c.Response().Header.Add(k, s)
+			}
+		}
+		return c.Status(resp.Status).Send(resp.Body)
+	}
+}
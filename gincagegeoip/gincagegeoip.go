@@ -0,0 +1,91 @@
+// MaxMind GeoIP2 / GeoLite2 resolver for gincage geo rules.
+//
+// Usage:
+//
+//	resolver, err := gincagegeoip.Open("GeoLite2-Country.mmdb", "GeoLite2-ASN.mmdb")
+//	if err != nil {
+//		return err
+//	}
+//	defer resolver.Close()
+//	limiter := gincage.NewLimiter(bucket,
+//		gincage.WithGeoResolver(resolver),
+//		gincage.WithConfig(gincage.Config{
+//			GeoRules: []gincage.GeoRule{{Countries: []string{"XX"}, Rate: gincage.Rate{Capacity: 2}}},
+//		}),
+//	)
+package gincagegeoip
+
+import (
+	"errors"
+	"net/netip"
+
+	gincage "github.com/fyx1t/gin-cage"
+	"github.com/oschwald/geoip2-golang/v2"
+)
+
+var _ gincage.GeoResolver = (*Resolver)(nil)
+
+var errNoDatabases = errors.New("gincagegeoip: no databases to open")
+
+// Resolver: gincage.GeoResolver reading MaxMind databases
+type Resolver struct {
+	country *geoip2.Reader
+	asn     *geoip2.Reader
+}
+
+// Opens country (Country or City edition) and ASN databases.
+// Either path can be empty, then field isn't resolved
+func Open(countryPath, asnPath string) (*Resolver, error) {
+	if countryPath == "" && asnPath == "" {
+		return nil, errNoDatabases
+	}
+	r := &Resolver{}
+	var err error
+	if countryPath != "" {
+		if r.country, err = geoip2.Open(countryPath); err != nil {
+			return nil, err
+		}
+	}
+	if asnPath != "" {
+		if r.asn, err = geoip2.Open(asnPath); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Returns country and ASN of ip. Unknown fields are left empty
+func (r *Resolver) Resolve(ip netip.Addr) (gincage.GeoInfo, error) {
+	var info gincage.GeoInfo
+	if r.country != nil {
+		c, err := r.country.Country(ip)
+		if err != nil {
+			return info, err
+		}
+		info.Country = c.Country.ISOCode
+		if info.Country == "" {
+			info.Country = c.RegisteredCountry.ISOCode
+		}
+	}
+	if r.asn != nil {
+		a, err := r.asn.ASN(ip)
+		if err != nil {
+			return info, err
+		}
+		info.ASN = a.AutonomousSystemNumber
+	}
+	return info, nil
+}
+
+// Closes databases
+func (r *Resolver) Close() error {
+	var errs []error
+	if r.country != nil {
+		errs = append(errs, r.country.Close())
+	}
+	if r.asn != nil {
+		errs = append(errs, r.asn.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,162 @@
+// gRPC server interceptors for gincage limiter.
+//
+// Interceptors share bucket, limits and metrics with gin middleware,
+// so mixed HTTP/gRPC services enforce one quota:
+//
+//	limiter := gincage.NewLimiter(bucket)
+//	router.Use(limiter.WalkThrough())
+//	server := grpc.NewServer(
+//		grpc.UnaryInterceptor(gincagegrpc.UnaryServerInterceptor(limiter)),
+//		grpc.StreamInterceptor(gincagegrpc.StreamServerInterceptor(limiter)),
+//	)
+//
+// Route rules are matched against full method name like "/pkg.Service/Method".
+// Limited calls fail with codes.ResourceExhausted and "retry-after" header
+// (seconds), calls rejected by fail policy fail with codes.Unavailable
+package gincagegrpc
+
+import (
+	"context"
+	"math"
+	"net"
+	"strconv"
+
+	gincage "github.com/fyx1t/gin-cage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Header carrying seconds to wait before retry of limited call
+const RetryAfterHeader = "retry-after"
+
+// KeyFunc: returns key identifying client of call
+type KeyFunc func(ctx context.Context, fullMethod string) string
+
+// Keys clients by ip of peer. Default KeyFunc
+func PeerKey(ctx context.Context, fullMethod string) string {
+	return peerIP(ctx)
+}
+
+// Keys clients by first value of metadata header (e.g. "x-api-key").
+// Calls without header are keyed by ip of peer
+func MetadataKey(header string) KeyFunc {
+	return func(ctx context.Context, fullMethod string) string {
+		if v := metadata.ValueFromIncomingContext(ctx, header); len(v) > 0 && v[0] != "" {
+			return v[0]
+		}
+		return peerIP(ctx)
+	}
+}
+
+type config struct {
+	keyFunc KeyFunc
+}
+
+// Option: optional setting passed to interceptors
+type Option func(*config)
+
+// Sets function extracting client key from call.
+// Default is PeerKey. If f is nil, option is ignored
+func WithKeyFunc(f KeyFunc) Option {
+	return func(c *config) {
+		if f != nil {
+			c.keyFunc = f
+		}
+	}
+}
+
+func newConfig(opts []Option) config {
+	c := config{keyFunc: PeerKey}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// Returns unary interceptor limiting calls with limiter
+func UnaryServerInterceptor(l *gincage.Limiter, opts ...Option) grpc.UnaryServerInterceptor {
+	cfg := newConfig(opts)
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		d := allow(ctx, l, cfg, info.FullMethod)
+		if !d.Allowed {
+			if md := retryAfter(d); md != nil {
+				grpc.SetHeader(ctx, md)
+			}
+			return nil, statusOf(d)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Returns stream interceptor limiting streams with limiter.
+// Stream takes one token when it is opened
+func StreamServerInterceptor(l *gincage.Limiter, opts ...Option) grpc.StreamServerInterceptor {
+	cfg := newConfig(opts)
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		d := allow(ss.Context(), l, cfg, info.FullMethod)
+		if !d.Allowed {
+			if md := retryAfter(d); md != nil {
+				ss.SetHeader(md)
+			}
+			return statusOf(d)
+		}
+		return handler(srv, ss)
+	}
+}
+
+func allow(ctx context.Context, l *gincage.Limiter, cfg config, fullMethod string) gincage.Decision {
+	return l.Allow(ctx, gincage.Request{
+		Key:       cfg.keyFunc(ctx, fullMethod),
+		IP:        peerIP(ctx),
+		Path:      fullMethod,
+		UserAgent: userAgent(ctx),
+		Header: func(name string) string {
+			return metadataValue(ctx, name)
+		},
+	})
+}
+
+// Returns user-agent of incoming call
+func userAgent(ctx context.Context) string {
+	return metadataValue(ctx, "user-agent")
+}
+
+// Returns first value of metadata key of incoming call
+func metadataValue(ctx context.Context, key string) string {
+	if v := metadata.ValueFromIncomingContext(ctx, key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Returns retry-after header of limited call or nil
+func retryAfter(d gincage.Decision) metadata.MD {
+	if !d.Limited() || d.RetryAfter <= 0 {
+		return nil
+	}
+	return metadata.Pairs(RetryAfterHeader, strconv.Itoa(int(math.Ceil(d.RetryAfter.Seconds()))))
+}
+
+func statusOf(d gincage.Decision) error {
+	if d.Limited() {
+		return status.Error(codes.ResourceExhausted, "too many requests, try again later")
+	}
+	return status.Error(codes.Unavailable, "server error occured")
+}
+
+// Returns ip of peer or empty string if it is unknown
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	addr := p.Addr.String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
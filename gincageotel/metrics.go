@@ -0,0 +1,329 @@
+package gincageotel
+
+import (
+	"context"
+	"time"
+
+	gincage "github.com/fyx1t/gin-cage"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var (
+	_ gincage.Metrics          = (*Metrics)(nil)
+	_ gincage.AgentRuleMetrics = (*Metrics)(nil)
+	_ gincage.StorageMetrics   = (*Metrics)(nil)
+	_ gincage.ShadowMetrics    = (*Metrics)(nil)
+	_ gincage.AdmissionMetrics = (*Metrics)(nil)
+	_ gincage.ScopeMetrics     = (*Metrics)(nil)
+	_ gincage.FailoverMetrics  = (*Metrics)(nil)
+)
+
+var (
+	allowedAttrs  = metric.WithAttributeSet(attribute.NewSet(attribute.String("outcome", "allowed")))
+	rejectedAttrs = metric.WithAttributeSet(attribute.NewSet(attribute.String("outcome", "rejected")))
+	erroredAttrs  = metric.WithAttributeSet(attribute.NewSet(attribute.String("outcome", "error")))
+
+	failOpenAttrs      = metric.WithAttributeSet(attribute.NewSet(attribute.String("policy", "open")))
+	failLocalAttrs     = metric.WithAttributeSet(attribute.NewSet(attribute.String("policy", "local")))
+	failSecondaryAttrs = metric.WithAttributeSet(attribute.NewSet(attribute.String("policy", "secondary")))
+)
+
+// Metrics: gincage.Metrics implementation recording OpenTelemetry instruments
+type Metrics struct {
+	requests       metric.Int64Counter
+	storageLatency metric.Float64Histogram
+	failPolicy     metric.Int64Counter
+	agentRules     metric.Int64Counter
+	storageCalls   metric.Float64Histogram
+	retries        metric.Int64Counter
+	timeouts       metric.Int64Counter
+	shadowed       metric.Int64Counter
+	admission      metric.Int64Counter
+	scopes         metric.Int64Counter
+}
+
+type metricsConfig struct {
+	provider   metric.MeterProvider
+	activeKeys gincage.KeyCounter
+	evictions  map[string]gincage.EvictionCounter
+}
+
+// MetricsOption: optional setting passed to NewMetrics
+type MetricsOption func(*metricsConfig)
+
+// Sets meter provider. Default is otel.GetMeterProvider()
+func WithMeterProvider(mp metric.MeterProvider) MetricsOption {
+	return func(c *metricsConfig) {
+		c.provider = mp
+	}
+}
+
+// Records gauge of active keys counted by counter on every collection
+func WithActiveKeys(counter gincage.KeyCounter) MetricsOption {
+	return func(c *metricsConfig) {
+		c.activeKeys = counter
+	}
+}
+
+// Records counter of keys evicted by counter on every collection
+// with component attribute (e.g. "bucket", "limits_cache").
+// Can be passed for several components
+func WithEvictions(component string, counter gincage.EvictionCounter) MetricsOption {
+	return func(c *metricsConfig) {
+		if c.evictions == nil {
+			c.evictions = make(map[string]gincage.EvictionCounter)
+		}
+		c.evictions[component] = counter
+	}
+}
+
+// Creates instruments:
+//
+// - gincage.requests: requests processed by limiter partitioned by outcome
+//
+// - gincage.storage.duration: time spent in bucket storage per request
+//
+// - gincage.fail_policy: requests decided by fail policy because of storage errors
+//
+// - gincage.agent_rules: requests matched by agent rules partitioned by rule and action
+//
+// - gincage.storage.call.duration: time of storage calls partitioned by backend and outcome
+//
+// - gincage.storage.retries: retries on concurrent updates of keys partitioned by backend
+//
+// - gincage.storage.timeouts: timed out storage calls partitioned by backend
+//
+// - gincage.shadow_rejections: requests allowed in shadow mode which would be rejected
+//
+// - gincage.admission_rejections: requests rejected by admission filter without storage calls
+//
+// - gincage.scope_requests: requests matched by scope rules partitioned by scope and outcome
+//
+// - gincage.active_keys: keys currently stored in bucket (with WithActiveKeys)
+//
+// - gincage.evicted_keys: keys evicted from local state partitioned by component (with WithEvictions)
+func NewMetrics(opts ...MetricsOption) (*Metrics, error) {
+	cfg := metricsConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.provider == nil {
+		cfg.provider = otel.GetMeterProvider()
+	}
+	meter := cfg.provider.Meter(ScopeName)
+
+	requests, err := meter.Int64Counter("gincage.requests",
+		metric.WithDescription("Requests processed by limiter partitioned by outcome (allowed, rejected, error)."),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	storageLatency, err := meter.Float64Histogram("gincage.storage.duration",
+		metric.WithDescription("Time spent in bucket storage per request."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	failPolicy, err := meter.Int64Counter("gincage.fail_policy",
+		metric.WithDescription("Requests decided by fail policy because of storage errors partitioned by policy (open, local)."),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	agentRules, err := meter.Int64Counter("gincage.agent_rules",
+		metric.WithDescription("Requests matched by agent rules partitioned by rule and action (limit, deny)."),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	storageCalls, err := meter.Float64Histogram("gincage.storage.call.duration",
+		metric.WithDescription("Time of storage calls partitioned by backend and outcome (ok, error, timeout)."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	retries, err := meter.Int64Counter("gincage.storage.retries",
+		metric.WithDescription("Retries of storage calls on concurrent updates of keys partitioned by backend."),
+		metric.WithUnit("{retry}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	timeouts, err := meter.Int64Counter("gincage.storage.timeouts",
+		metric.WithDescription("Timed out storage calls partitioned by backend."),
+		metric.WithUnit("{call}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	shadowed, err := meter.Int64Counter("gincage.shadow_rejections",
+		metric.WithDescription("Requests allowed in shadow mode which would be rejected."),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	admission, err := meter.Int64Counter("gincage.admission_rejections",
+		metric.WithDescription("Requests rejected by admission filter without storage calls."),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes, err := meter.Int64Counter("gincage.scope_requests",
+		metric.WithDescription("Requests matched by scope rules partitioned by scope and outcome (allowed, rejected)."),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.activeKeys != nil {
+		counter := cfg.activeKeys
+		_, err = meter.Int64ObservableGauge("gincage.active_keys",
+			metric.WithDescription("Keys currently stored in bucket."),
+			metric.WithUnit("{key}"),
+			metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+				n, err := counter.ActiveKeys(ctx)
+				if err != nil {
+					return err
+				}
+				o.Observe(int64(n))
+				return nil
+			}),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(cfg.evictions) > 0 {
+		evictions := cfg.evictions
+		_, err = meter.Int64ObservableCounter("gincage.evicted_keys",
+			metric.WithDescription("Keys evicted from local state bounded by max keys partitioned by component."),
+			metric.WithUnit("{key}"),
+			metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+				for component, counter := range evictions {
+					o.Observe(int64(counter.Evictions()), metric.WithAttributes(attribute.String("component", component)))
+				}
+				return nil
+			}),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Metrics{
+		requests:       requests,
+		storageLatency: storageLatency,
+		failPolicy:     failPolicy,
+		agentRules:     agentRules,
+		storageCalls:   storageCalls,
+		retries:        retries,
+		timeouts:       timeouts,
+		shadowed:       shadowed,
+		admission:      admission,
+		scopes:         scopes,
+	}, nil
+}
+
+func (m *Metrics) Allowed() {
+	m.requests.Add(context.Background(), 1, allowedAttrs)
+}
+
+func (m *Metrics) Rejected() {
+	m.requests.Add(context.Background(), 1, rejectedAttrs)
+}
+
+func (m *Metrics) Errored() {
+	m.requests.Add(context.Background(), 1, erroredAttrs)
+}
+
+func (m *Metrics) StorageLatency(d time.Duration) {
+	m.storageLatency.Record(context.Background(), d.Seconds())
+}
+
+func (m *Metrics) FailedOpen() {
+	m.failPolicy.Add(context.Background(), 1, failOpenAttrs)
+}
+
+func (m *Metrics) LocalFallback() {
+	m.failPolicy.Add(context.Background(), 1, failLocalAttrs)
+}
+
+func (m *Metrics) FailedOver() {
+	m.failPolicy.Add(context.Background(), 1, failSecondaryAttrs)
+}
+
+func (m *Metrics) AgentRuleMatched(rule string, denied bool) {
+	action := "limit"
+	if denied {
+		action = "deny"
+	}
+	m.agentRules.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("rule", rule),
+		attribute.String("action", action),
+	))
+}
+
+func (m *Metrics) ScopeMatched(scope string, allowed bool) {
+	outcome := "allowed"
+	if !allowed {
+		outcome = "rejected"
+	}
+	m.scopes.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("scope", scope),
+		attribute.String("outcome", outcome),
+	))
+}
+
+func (m *Metrics) StorageCalled(c gincage.StorageCall) {
+	ctx := context.Background()
+	backend := attribute.String("backend", c.Backend)
+	m.storageCalls.Record(ctx, c.Latency.Seconds(), metric.WithAttributes(
+		backend,
+		attribute.String("outcome", storageOutcome(c)),
+	))
+	if c.Retries > 0 {
+		m.retries.Add(ctx, int64(c.Retries), metric.WithAttributes(backend))
+	}
+	if c.Timeout {
+		m.timeouts.Add(ctx, 1, metric.WithAttributes(backend))
+	}
+}
+
+func (m *Metrics) ShadowRejected() {
+	m.shadowed.Add(context.Background(), 1)
+}
+
+func (m *Metrics) AdmissionRejected() {
+	m.admission.Add(context.Background(), 1)
+}
+
+func storageOutcome(c gincage.StorageCall) string {
+	switch {
+	case c.Timeout:
+		return "timeout"
+	case c.Failed:
+		return "error"
+	default:
+		return "ok"
+	}
+}
@@ -0,0 +1,135 @@
+// OpenTelemetry instrumentation for gincage.
+//
+// Metrics:
+//
+//	metrics, err := gincageotel.NewMetrics(
+//		gincageotel.WithMeterProvider(meterProvider),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	limiter := gincage.NewLimiter(bucket, gincage.WithMetrics(metrics))
+//
+// Tracing:
+//
+//	bucket, err := gincage.NewRedisBucket(...)
+//	if err != nil {
+//		return err
+//	}
+//	bucket = gincageotel.NewTracingBucket(bucket)
+package gincageotel
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	gincage "github.com/fyx1t/gin-cage"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Instrumentation name used for tracer and meter
+const ScopeName = "github.com/fyx1t/gin-cage/gincageotel"
+
+type tracingBucket struct {
+	bucket gincage.Bucket
+	tracer trace.Tracer
+}
+
+type tracingConfig struct {
+	provider trace.TracerProvider
+}
+
+// TracingOption: optional setting passed to NewTracingBucket
+type TracingOption func(*tracingConfig)
+
+// Sets tracer provider. Default is otel.GetTracerProvider()
+func WithTracerProvider(tp trace.TracerProvider) TracingOption {
+	return func(c *tracingConfig) {
+		c.provider = tp
+	}
+}
+
+// Wraps bucket, so every Take is recorded as span
+// started from request context.
+//
+// Span has attributes:
+//
+// - gincage.key_hash: sha256 prefix of client key
+//
+// - gincage.outcome: allowed, rejected or error
+//
+// - gincage.retries: count of retries on concurrent updates
+//
+// - gincage.backend: storage backend name
+//
+// - gincage.request_id: id of request if it's known (see gincage.Request.RequestID)
+func NewTracingBucket(bucket gincage.Bucket, opts ...TracingOption) gincage.Bucket {
+	cfg := tracingConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.provider == nil {
+		cfg.provider = otel.GetTracerProvider()
+	}
+
+	return &tracingBucket{
+		bucket: bucket,
+		tracer: cfg.provider.Tracer(ScopeName),
+	}
+}
+
+func (b *tracingBucket) Take(ctx context.Context, key string, n int) (gincage.Result, error) {
+	stats := gincage.WalkStatsFromContext(ctx)
+	if stats == nil {
+		stats = &gincage.WalkStats{}
+		ctx = gincage.ContextWithWalkStats(ctx, stats)
+	}
+	// storage calls get span as parent
+	ctx, span := b.tracer.Start(
+		ctx,
+		"gincage.Take",
+		trace.WithSpanKind(trace.SpanKindClient),
+	)
+	defer span.End()
+
+	res, err := b.bucket.Take(ctx, key, n)
+
+	outcome := "allowed"
+	switch {
+	case err != nil:
+		outcome = "error"
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case !res.Allowed:
+		outcome = "rejected"
+	}
+	span.SetAttributes(
+		attribute.String("gincage.key_hash", hashKey(key)),
+		attribute.String("gincage.outcome", outcome),
+		attribute.Int("gincage.retries", stats.Retries),
+		attribute.String("gincage.backend", stats.Backend),
+	)
+	if id := gincage.RequestIDFromContext(ctx); id != "" {
+		span.SetAttributes(attribute.String("gincage.request_id", id))
+	}
+
+	return res, err
+}
+
+func (b *tracingBucket) Close() error {
+	return b.bucket.Close()
+}
+
+func (b *tracingBucket) Unwrap() gincage.Bucket {
+	return b.bucket
+}
+
+// Keys are hashed, so raw client addresses don't leak to tracing backend
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
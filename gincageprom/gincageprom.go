@@ -0,0 +1,288 @@
+// Prometheus instrumentation for gincage limiter.
+//
+// Usage:
+//
+//	metrics, err := gincageprom.New(prometheus.DefaultRegisterer,
+//		gincageprom.WithActiveKeys(bucket),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	limiter := gincage.NewLimiter(bucket, gincage.WithMetrics(metrics))
+package gincageprom
+
+import (
+	"context"
+	"time"
+
+	gincage "github.com/fyx1t/gin-cage"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Default namespace of exported metrics
+	DefaultNamespace = "gincage"
+	// Default timeout for counting active keys on scrape
+	DefaultActiveKeysTimeout = time.Duration(5 * time.Second)
+)
+
+var (
+	_ gincage.Metrics          = (*Metrics)(nil)
+	_ gincage.AgentRuleMetrics = (*Metrics)(nil)
+	_ gincage.StorageMetrics   = (*Metrics)(nil)
+	_ gincage.ShadowMetrics    = (*Metrics)(nil)
+	_ gincage.AdmissionMetrics = (*Metrics)(nil)
+	_ gincage.ScopeMetrics     = (*Metrics)(nil)
+	_ gincage.FailoverMetrics  = (*Metrics)(nil)
+)
+
+// Metrics: gincage.Metrics implementation exporting prometheus collectors
+type Metrics struct {
+	requests       *prometheus.CounterVec
+	storageLatency prometheus.Histogram
+	failPolicy     *prometheus.CounterVec
+	agentRules     *prometheus.CounterVec
+	storageCalls   *prometheus.HistogramVec
+	retries        *prometheus.CounterVec
+	timeouts       *prometheus.CounterVec
+	shadowed       prometheus.Counter
+	admission      prometheus.Counter
+	scopes         *prometheus.CounterVec
+}
+
+type config struct {
+	namespace         string
+	buckets           []float64
+	activeKeys        gincage.KeyCounter
+	activeKeysTimeout time.Duration
+	evictions         map[string]gincage.EvictionCounter
+}
+
+// Option: optional Metrics setting passed to New
+type Option func(*config)
+
+// Sets namespace of exported metrics. Default is DefaultNamespace
+func WithNamespace(namespace string) Option {
+	return func(c *config) {
+		c.namespace = namespace
+	}
+}
+
+// Sets histogram buckets for storage latency (in seconds).
+// Default is prometheus.DefBuckets
+func WithLatencyBuckets(buckets []float64) Option {
+	return func(c *config) {
+		c.buckets = buckets
+	}
+}
+
+// Exports gauge of active keys counted by counter on every scrape.
+// If timeout <= 0, uses DefaultActiveKeysTimeout
+func WithActiveKeys(counter gincage.KeyCounter, timeout time.Duration) Option {
+	return func(c *config) {
+		c.activeKeys = counter
+		c.activeKeysTimeout = timeout
+	}
+}
+
+// Exports counter of keys evicted by counter on every scrape
+// with component label (e.g. "bucket", "limits_cache").
+// Can be passed for several components
+func WithEvictions(component string, counter gincage.EvictionCounter) Option {
+	return func(c *config) {
+		if c.evictions == nil {
+			c.evictions = make(map[string]gincage.EvictionCounter)
+		}
+		c.evictions[component] = counter
+	}
+}
+
+// Creates metrics and registers them on reg
+func New(reg prometheus.Registerer, opts ...Option) (*Metrics, error) {
+	cfg := config{
+		namespace: DefaultNamespace,
+		buckets:   prometheus.DefBuckets,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.activeKeysTimeout <= 0 {
+		cfg.activeKeysTimeout = DefaultActiveKeysTimeout
+	}
+
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Name:      "requests_total",
+			Help:      "Requests processed by limiter partitioned by outcome (allowed, rejected, error).",
+		}, []string{"outcome"}),
+		storageLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: cfg.namespace,
+			Name:      "storage_duration_seconds",
+			Help:      "Time spent in bucket storage per request.",
+			Buckets:   cfg.buckets,
+		}),
+		failPolicy: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Name:      "fail_policy_total",
+			Help:      "Requests decided by fail policy because of storage errors partitioned by policy (open, local, secondary).",
+		}, []string{"policy"}),
+		agentRules: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Name:      "agent_rule_matches_total",
+			Help:      "Requests matched by agent rules partitioned by rule and action (limit, deny).",
+		}, []string{"rule", "action"}),
+		storageCalls: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: cfg.namespace,
+			Name:      "storage_call_duration_seconds",
+			Help:      "Time of storage calls partitioned by backend and outcome (ok, error, timeout).",
+			Buckets:   cfg.buckets,
+		}, []string{"backend", "outcome"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Name:      "storage_retries_total",
+			Help:      "Retries of storage calls on concurrent updates of keys partitioned by backend.",
+		}, []string{"backend"}),
+		timeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Name:      "storage_timeouts_total",
+			Help:      "Timed out storage calls partitioned by backend.",
+		}, []string{"backend"}),
+		shadowed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Name:      "shadow_rejections_total",
+			Help:      "Requests allowed in shadow mode which would be rejected.",
+		}),
+		admission: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Name:      "admission_rejections_total",
+			Help:      "Requests rejected by admission filter without storage calls.",
+		}),
+		scopes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Name:      "scope_requests_total",
+			Help:      "Requests matched by scope rules partitioned by scope and outcome (allowed, rejected).",
+		}, []string{"scope", "outcome"}),
+	}
+
+	collectors := []prometheus.Collector{
+		m.requests, m.storageLatency, m.failPolicy, m.agentRules,
+		m.storageCalls, m.retries, m.timeouts, m.shadowed, m.admission, m.scopes,
+	}
+	if cfg.activeKeys != nil {
+		counter, timeout := cfg.activeKeys, cfg.activeKeysTimeout
+		collectors = append(collectors, prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: cfg.namespace,
+			Name:      "active_keys",
+			Help:      "Keys currently stored in bucket.",
+		}, func() float64 {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			n, err := counter.ActiveKeys(ctx)
+			if err != nil {
+				return -1
+			}
+			return float64(n)
+		}))
+	}
+
+	for component, counter := range cfg.evictions {
+		collectors = append(collectors, prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   cfg.namespace,
+			Name:        "evicted_keys_total",
+			Help:        "Keys evicted from local state bounded by max keys partitioned by component.",
+			ConstLabels: prometheus.Labels{"component": component},
+		}, func() float64 {
+			return float64(counter.Evictions())
+		}))
+	}
+
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	// initialize outcomes so they are exported before first request
+	for _, outcome := range []string{"allowed", "rejected", "error"} {
+		m.requests.WithLabelValues(outcome)
+	}
+	for _, policy := range []string{"open", "local"} {
+		m.failPolicy.WithLabelValues(policy)
+	}
+
+	return m, nil
+}
+
+func (m *Metrics) Allowed() {
+	m.requests.WithLabelValues("allowed").Inc()
+}
+
+func (m *Metrics) Rejected() {
+	m.requests.WithLabelValues("rejected").Inc()
+}
+
+func (m *Metrics) Errored() {
+	m.requests.WithLabelValues("error").Inc()
+}
+
+func (m *Metrics) StorageLatency(d time.Duration) {
+	m.storageLatency.Observe(d.Seconds())
+}
+
+func (m *Metrics) FailedOpen() {
+	m.failPolicy.WithLabelValues("open").Inc()
+}
+
+func (m *Metrics) LocalFallback() {
+	m.failPolicy.WithLabelValues("local").Inc()
+}
+
+func (m *Metrics) FailedOver() {
+	m.failPolicy.WithLabelValues("secondary").Inc()
+}
+
+func (m *Metrics) AgentRuleMatched(rule string, denied bool) {
+	action := "limit"
+	if denied {
+		action = "deny"
+	}
+	m.agentRules.WithLabelValues(rule, action).Inc()
+}
+
+func (m *Metrics) ScopeMatched(scope string, allowed bool) {
+	outcome := "allowed"
+	if !allowed {
+		outcome = "rejected"
+	}
+	m.scopes.WithLabelValues(scope, outcome).Inc()
+}
+
+func (m *Metrics) StorageCalled(c gincage.StorageCall) {
+	m.storageCalls.WithLabelValues(c.Backend, storageOutcome(c)).Observe(c.Latency.Seconds())
+	if c.Retries > 0 {
+		m.retries.WithLabelValues(c.Backend).Add(float64(c.Retries))
+	}
+	if c.Timeout {
+		m.timeouts.WithLabelValues(c.Backend).Inc()
+	}
+}
+
+func (m *Metrics) ShadowRejected() {
+	m.shadowed.Inc()
+}
+
+func (m *Metrics) AdmissionRejected() {
+	m.admission.Inc()
+}
+
+func storageOutcome(c gincage.StorageCall) string {
+	switch {
+	case c.Timeout:
+		return "timeout"
+	case c.Failed:
+		return "error"
+	default:
+		return "ok"
+	}
+}
@@ -0,0 +1,189 @@
+// In-process gincage.Bucket backed by per-key limiters
+// of golang.org/x/time/rate, for single-instance services.
+//
+// Usage:
+//
+//	bucket := gincagerate.New(gincage.BucketConfigs{
+//		Capability:           10,
+//		TokensAppendDuration: time.Second,
+//		MaxKeys:              100000,
+//	})
+//	limiter := gincage.NewLimiter(bucket)
+package gincagerate
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	gincage "github.com/fyx1t/gin-cage"
+	"golang.org/x/time/rate"
+)
+
+// Default max count of limiters kept by bucket
+var DefaultMaxKeys = 10000
+
+var (
+	_ gincage.Bucket       = (*Bucket)(nil)
+	_ gincage.Peeker       = (*Bucket)(nil)
+	_ gincage.Resetter     = (*Bucket)(nil)
+	_ gincage.RateReporter = (*Bucket)(nil)
+)
+
+// Bucket: gincage.Bucket keeping rate.Limiter of every key.
+//
+// Unlike gincage.MemoryBucket, tokens are refilled continuously
+// (one per Rate.Refill on average), not in whole steps. Least recently
+// used limiters are dropped when MaxKeys is reached, dropped key
+// starts with full capacity again. Rate.TTL isn't used
+type Bucket struct {
+	rate    gincage.Rate
+	clock   gincage.Clock
+	maxKeys int
+
+	mu sync.Mutex
+	// most recently used limiters first
+	lru  *list.List
+	keys map[string]*list.Element
+}
+
+type entry struct {
+	key string
+	lim *rate.Limiter
+}
+
+// Returns bucket with limits of cfg. Only limits of cfg, Clock and
+// MaxKeys are used. If MaxKeys <= 0, uses DefaultMaxKeys
+func New(cfg gincage.BucketConfigs) *Bucket {
+	if cfg.Capability <= 0 {
+		cfg.Capability = gincage.DefaultTokensCap
+	}
+	if cfg.TokensAppendDuration <= 0 {
+		cfg.TokensAppendDuration = gincage.DefaultTokensAppendDuration
+	}
+	if cfg.TokensExist <= 0 {
+		cfg.TokensExist = gincage.DefaultTokensExist
+	}
+	if cfg.MaxKeys <= 0 {
+		cfg.MaxKeys = DefaultMaxKeys
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = gincage.SystemClock{}
+	}
+	return &Bucket{
+		rate: gincage.Rate{
+			Capacity: cfg.Capability,
+			Refill:   cfg.TokensAppendDuration,
+			TTL:      cfg.TokensExist,
+		},
+		clock:   cfg.Clock,
+		maxKeys: cfg.MaxKeys,
+		lru:     list.New(),
+		keys:    make(map[string]*list.Element),
+	}
+}
+
+// Takes n tokens of key (one if n <= 0) from its limiter
+func (b *Bucket) Take(ctx context.Context, key string, n int) (gincage.Result, error) {
+	if stats := gincage.WalkStatsFromContext(ctx); stats != nil {
+		stats.Backend = "xrate"
+	}
+	r := b.rateOf(ctx)
+	n = max(n, 1)
+	now := b.clock.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	lim := b.limiter(key, r, now)
+	if lim.AllowN(now, n) {
+		return gincage.Result{Allowed: true, Remaining: int(lim.TokensAt(now)), Limit: r.Capacity}, nil
+	}
+	tokens := lim.TokensAt(now)
+	// time till tokens reach next whole count
+	next := (math.Floor(tokens) + 1 - tokens) * float64(r.Refill)
+	return gincage.Result{
+		Remaining:  max(int(tokens), 0),
+		Limit:      r.Capacity,
+		RetryAfter: time.Duration(next),
+	}, nil
+}
+
+// Returns tokens of key without taking them
+func (b *Bucket) Peek(ctx context.Context, key string) (gincage.KeyState, error) {
+	now := b.clock.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.keys[key]
+	if !ok {
+		return gincage.KeyState{Key: key, Tokens: b.rate.Capacity, RefilledAt: now}, nil
+	}
+	lim := e.Value.(*entry).lim
+	return gincage.KeyState{Key: key, Tokens: int(lim.TokensAt(now)), RefilledAt: now, Exists: true}, nil
+}
+
+// Drops limiter of key, so it has full capacity
+func (b *Bucket) Reset(ctx context.Context, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e, ok := b.keys[key]; ok {
+		b.lru.Remove(e)
+		delete(b.keys, key)
+	}
+	return nil
+}
+
+// Returns default limits of bucket
+func (b *Bucket) Rate() gincage.Rate {
+	return b.rate
+}
+
+// Drops all limiters
+func (b *Bucket) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lru.Init()
+	clear(b.keys)
+	return nil
+}
+
+// Returns limit of walk options of ctx, zero fields are taken from bucket
+func (b *Bucket) rateOf(ctx context.Context) gincage.Rate {
+	opts, _ := gincage.WalkOptionsFromContext(ctx)
+	r := opts.Rate
+	if r.Capacity <= 0 {
+		r.Capacity = b.rate.Capacity
+	}
+	if r.Refill <= 0 {
+		r.Refill = b.rate.Refill
+	}
+	return r
+}
+
+// Returns limiter of key with limit r, creating it and dropping
+// least recently used one if needed. Should be called with lock held
+func (b *Bucket) limiter(key string, r gincage.Rate, now time.Time) *rate.Limiter {
+	limit := rate.Every(r.Refill)
+	if e, ok := b.keys[key]; ok {
+		b.lru.MoveToFront(e)
+		lim := e.Value.(*entry).lim
+		// limit of key changed, e.g. config was reloaded
+		if lim.Limit() != limit {
+			lim.SetLimitAt(now, limit)
+		}
+		if lim.Burst() != r.Capacity {
+			lim.SetBurstAt(now, r.Capacity)
+		}
+		return lim
+	}
+
+	if b.lru.Len() >= b.maxKeys {
+		last := b.lru.Back()
+		b.lru.Remove(last)
+		delete(b.keys, last.Value.(*entry).key)
+	}
+	lim := rate.NewLimiter(limit, r.Capacity)
+	b.keys[key] = b.lru.PushFront(&entry{key: key, lim: lim})
+	return lim
+}
@@ -0,0 +1,286 @@
+// gincage.Bucket on rueidis client. Keys are stored the same way
+// as by gincage.RedisBucket (without Codec), so both can share storage.
+//
+// Usage:
+//
+//	client, err := rueidis.NewClient(rueidis.ClientOption{InitAddress: []string{"localhost:6379"}})
+//	if err != nil {
+//		panic(err)
+//	}
+//	bucket := gincagerueidis.New(gincage.BucketConfigs{Capability: 10, TokensAppendDuration: time.Second}, client)
+//	limiter := gincage.NewLimiter(bucket)
+package gincagerueidis
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	gincage "github.com/fyx1t/gin-cage"
+	"github.com/redis/rueidis"
+)
+
+// Default client side cache ttl of ban checks
+var DefaultCacheTTL = time.Duration(time.Minute)
+
+var (
+	takeScript      = rueidis.NewLuaScript(gincage.RedisTakeScript)
+	violationScript = rueidis.NewLuaScript(gincage.RedisViolationScript)
+)
+
+var (
+	_ gincage.Bucket        = (*Bucket)(nil)
+	_ gincage.BatchWalker   = (*Bucket)(nil)
+	_ gincage.Banner        = (*Bucket)(nil)
+	_ gincage.Peeker        = (*Bucket)(nil)
+	_ gincage.Resetter      = (*Bucket)(nil)
+	_ gincage.RateReporter  = (*Bucket)(nil)
+	_ gincage.HealthChecker = (*Bucket)(nil)
+)
+
+// Bucket: redis bucket on rueidis client.
+//
+// Tokens are taken by one Lua script call, which rueidis pipelines with
+// commands of concurrent requests, so many requests share few connections.
+// Ban checks are served from RESP3 client side cache, redis invalidates
+// cached bans when they change. Transactional extensions (Syncer, Pacer, ...)
+// aren't implemented, use gincage.RedisBucket for them
+type Bucket struct {
+	client rueidis.Client
+	rate   gincage.Rate
+	clock  gincage.Clock
+	layout gincage.RedisLayout
+}
+
+// Returns bucket on existing rueidis client.
+// Only limits of cfg, TTLJitter, Clock and Schema are used
+func New(cfg gincage.BucketConfigs, c rueidis.Client) *Bucket {
+	if cfg.Capability <= 0 {
+		cfg.Capability = gincage.DefaultTokensCap
+	}
+	if cfg.TokensExist <= 0 {
+		cfg.TokensExist = gincage.DefaultTokensExist
+	}
+	if cfg.TokensAppendDuration <= 0 {
+		cfg.TokensAppendDuration = gincage.DefaultTokensAppendDuration
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = gincage.SystemClock{}
+	}
+	return &Bucket{
+		client: c,
+		rate: gincage.Rate{
+			Capacity: cfg.Capability,
+			Refill:   cfg.TokensAppendDuration,
+			TTL:      cfg.TokensExist,
+		},
+		clock:  cfg.Clock,
+		layout: gincage.NewRedisLayout(cfg),
+	}
+}
+
+// Takes n tokens of key (one if n <= 0) if awailable
+func (b *Bucket) Take(ctx context.Context, key string, n int) (gincage.Result, error) {
+	if stats := gincage.WalkStatsFromContext(ctx); stats != nil {
+		stats.Backend = "rueidis"
+	}
+	opts, _ := gincage.WalkOptionsFromContext(ctx)
+	rate := b.rateOf(opts.Rate)
+
+	// reserve isn't checked by script, limiter checks remaining tokens
+	keys := []gincage.KeyedCost{{Key: key, Cost: max(n, 1), Rate: rate}}
+	left, err := b.take(ctx, keys, []gincage.Rate{rate})
+	var e *gincage.LimitExceededError
+	if errors.As(err, &e) {
+		r := gincage.Result{Remaining: e.Remaining, Limit: rate.Capacity}
+		if !e.Reset.IsZero() {
+			r.RetryAfter = max(e.Reset.Sub(b.clock.Now()), 0)
+		}
+		return r, nil
+	}
+	if err != nil {
+		return gincage.Result{}, err
+	}
+	return gincage.Result{Allowed: true, Remaining: int(left[0]), Limit: rate.Capacity}, nil
+}
+
+// Takes tokens of all keys at once by one script call. If any key has
+// not enough tokens, nothing is taken and *gincage.LimitExceededError of
+// that key is returned. In redis cluster all keys should be in one hash slot
+func (b *Bucket) WalkMany(ctx context.Context, keys []gincage.KeyedCost) error {
+	if stats := gincage.WalkStatsFromContext(ctx); stats != nil {
+		stats.Backend = "rueidis"
+	}
+	keys = mergeKeyedCosts(keys)
+	if len(keys) == 0 {
+		return nil
+	}
+	rates := make([]gincage.Rate, len(keys))
+	for i, k := range keys {
+		rates[i] = b.rateOf(k.Rate)
+	}
+	_, err := b.take(ctx, keys, rates)
+	return err
+}
+
+// Runs take script on keys, returns tokens left of them
+func (b *Bucket) take(ctx context.Context, keys []gincage.KeyedCost, rates []gincage.Rate) ([]int64, error) {
+	names := b.layout.TakeKeys(keys)
+	args := b.layout.TakeArgs(b.clock.Now(), keys, rates)
+	reply, err := takeScript.Exec(ctx, b.client, names, args).AsIntSlice()
+	if err != nil {
+		return nil, err
+	}
+	return b.layout.TakeReply(reply, keys, rates)
+}
+
+// Registers violation of key and returns count of violations in current window
+func (b *Bucket) AddViolation(ctx context.Context, key string, window time.Duration) (int, error) {
+	keys := []string{b.layout.ViolationsKey(key)}
+	ms := strconv.FormatInt(max(window.Milliseconds(), 1), 10)
+	n, err := violationScript.Exec(ctx, b.client, keys, []string{ms}).AsInt64()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// Bans key for d
+func (b *Bucket) Ban(ctx context.Context, key string, d time.Duration) error {
+	until := b.clock.Now().Add(d)
+	// pipelined without MULTI, keys may be in different slots of cluster
+	for _, r := range b.client.DoMulti(ctx,
+		b.client.B().Set().Key(b.layout.BanKey(key)).Value(b.layout.BanValue(until)).Px(d).Build(),
+		b.client.B().Del().Key(b.layout.ViolationsKey(key)).Build(),
+	) {
+		if err := r.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Removes ban of key
+func (b *Bucket) Unban(ctx context.Context, key string) error {
+	return b.client.Do(ctx, b.client.B().Del().Key(b.layout.BanKey(key)).Build()).Error()
+}
+
+// Returns time when ban of key ends. Returns zero time if key isn't banned.
+// Served from client side cache
+func (b *Bucket) BannedUntil(ctx context.Context, key string) (time.Time, error) {
+	cmd := b.client.B().Get().Key(b.layout.BanKey(key)).Cache()
+	return b.parseBan(b.client.DoCache(ctx, cmd, DefaultCacheTTL).ToString())
+}
+
+// Returns list of banned keys.
+//
+// Uses SCAN, so result is approximate if bans are changed while listing
+func (b *Bucket) Bans(ctx context.Context) ([]gincage.BanInfo, error) {
+	prefix := b.layout.BanKey("")
+	var bans []gincage.BanInfo
+	var cursor uint64
+	for {
+		cmd := b.client.B().Scan().Cursor(cursor).Match(prefix + "*").Count(1000).Build()
+		entry, err := b.client.Do(ctx, cmd).AsScanEntry()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range entry.Elements {
+			cmd := b.client.B().Get().Key(name).Build()
+			until, err := b.parseBan(b.client.Do(ctx, cmd).ToString())
+			if err != nil {
+				return nil, err
+			}
+			// ban expired while listing
+			if until.IsZero() {
+				continue
+			}
+			bans = append(bans, gincage.BanInfo{Key: name[len(prefix):], Until: until})
+		}
+		if cursor = entry.Cursor; cursor == 0 {
+			return bans, nil
+		}
+	}
+}
+
+// Parses stored ban, missing ban is zero time
+func (b *Bucket) parseBan(r string, err error) (time.Time, error) {
+	if rueidis.IsRedisNil(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return b.layout.ParseBan(r)
+}
+
+// Returns tokens of key without taking them
+func (b *Bucket) Peek(ctx context.Context, key string) (gincage.KeyState, error) {
+	name := b.layout.Key(key)
+	results := b.client.DoMulti(ctx,
+		b.client.B().Hgetall().Key(name).Build(),
+		b.client.B().Pttl().Key(name).Build(),
+	)
+	fields, err := results[0].AsStrMap()
+	if err != nil {
+		return gincage.KeyState{}, err
+	}
+	ttl, err := results[1].AsInt64()
+	if err != nil {
+		return gincage.KeyState{}, err
+	}
+	return b.layout.KeyState(key, fields, ttl, b.rate, b.clock.Now())
+}
+
+// Restores full capacity of key
+func (b *Bucket) Reset(ctx context.Context, key string) error {
+	return b.client.Do(ctx, b.client.B().Del().Key(b.layout.Key(key)).Build()).Error()
+}
+
+// Returns limits of bucket
+func (b *Bucket) Rate() gincage.Rate {
+	return b.rate
+}
+
+// Pings redis
+func (b *Bucket) Ping(ctx context.Context) error {
+	return b.client.Do(ctx, b.client.B().Ping().Build()).Error()
+}
+
+// Closes rueidis client
+func (b *Bucket) Close() error {
+	b.client.Close()
+	return nil
+}
+
+// Returns r with zero fields taken from bucket
+func (b *Bucket) rateOf(r gincage.Rate) gincage.Rate {
+	if r.Capacity <= 0 {
+		r.Capacity = b.rate.Capacity
+	}
+	if r.Refill <= 0 {
+		r.Refill = b.rate.Refill
+	}
+	if r.TTL <= 0 {
+		r.TTL = b.rate.TTL
+	}
+	return r
+}
+
+// Merges costs of same keys, so every key is taken once.
+// Rate of first occurrence is used
+func mergeKeyedCosts(keys []gincage.KeyedCost) []gincage.KeyedCost {
+	merged := make([]gincage.KeyedCost, 0, len(keys))
+	idx := make(map[string]int, len(keys))
+	for _, k := range keys {
+		k.Cost = max(k.Cost, 1)
+		if i, ok := idx[k.Key]; ok {
+			merged[i].Cost += k.Cost
+			continue
+		}
+		idx[k.Key] = len(merged)
+		merged = append(merged, k)
+	}
+	return merged
+}
@@ -0,0 +1,326 @@
+// StatsD and DogStatsD instrumentation for gincage limiter.
+//
+// Usage:
+//
+//	metrics, err := gincagestatsd.New("127.0.0.1:8125",
+//		gincagestatsd.WithTags("service:api", "env:prod"),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	defer metrics.Close()
+//	limiter := gincage.NewLimiter(bucket, gincage.WithMetrics(metrics))
+package gincagestatsd
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gincage "github.com/fyx1t/gin-cage"
+)
+
+var (
+	// Default prefix of metric names
+	DefaultPrefix = "gincage"
+	// Default time between sends of buffered metrics
+	DefaultFlushInterval = time.Duration(time.Second)
+	// Default max size of UDP packet, fits common MTU
+	DefaultMaxPacketSize = 1432
+)
+
+var (
+	_ gincage.Metrics          = (*Metrics)(nil)
+	_ gincage.AgentRuleMetrics = (*Metrics)(nil)
+	_ gincage.StorageMetrics   = (*Metrics)(nil)
+	_ gincage.ShadowMetrics    = (*Metrics)(nil)
+	_ gincage.AdmissionMetrics = (*Metrics)(nil)
+	_ gincage.ScopeMetrics     = (*Metrics)(nil)
+	_ gincage.FailoverMetrics  = (*Metrics)(nil)
+)
+
+// Metrics: gincage.Metrics implementation sending metrics to StatsD agent.
+//
+// Metrics are buffered and sent in packets of max size
+// every flush interval, Close sends the rest
+type Metrics struct {
+	conn net.Conn
+	cfg  config
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+type config struct {
+	prefix        string
+	tags          []string
+	dogstatsd     bool
+	flushInterval time.Duration
+	maxPacketSize int
+}
+
+// Option: optional Metrics setting passed to New
+type Option func(*config)
+
+// Sets prefix of metric names. Default is DefaultPrefix
+func WithPrefix(prefix string) Option {
+	return func(c *config) {
+		c.prefix = prefix
+	}
+}
+
+// Sends metrics in DogStatsD format: labels (outcome, backend, ...) are
+// sent as tags instead of parts of metric names. Enabled by WithTags too
+func WithDogStatsD() Option {
+	return func(c *config) {
+		c.dogstatsd = true
+	}
+}
+
+// Adds tags ("key:value") to every metric, enables DogStatsD format
+func WithTags(tags ...string) Option {
+	return func(c *config) {
+		c.dogstatsd = true
+		c.tags = append(c.tags, tags...)
+	}
+}
+
+// Sets time between sends of buffered metrics.
+// Default is DefaultFlushInterval
+func WithFlushInterval(d time.Duration) Option {
+	return func(c *config) {
+		c.flushInterval = d
+	}
+}
+
+// Sets max size of sent packets. Default is DefaultMaxPacketSize
+func WithMaxPacketSize(n int) Option {
+	return func(c *config) {
+		c.maxPacketSize = n
+	}
+}
+
+// Creates metrics sending packets to agent at UDP addr
+func New(addr string, opts ...Option) (*Metrics, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithConn(conn, opts...), nil
+}
+
+// Creates metrics writing packets to conn (e.g. unix socket of agent).
+// conn is closed by Close
+func NewWithConn(conn net.Conn, opts ...Option) *Metrics {
+	cfg := config{
+		prefix:        DefaultPrefix,
+		flushInterval: DefaultFlushInterval,
+		maxPacketSize: DefaultMaxPacketSize,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.flushInterval <= 0 {
+		cfg.flushInterval = DefaultFlushInterval
+	}
+	if cfg.maxPacketSize <= 0 {
+		cfg.maxPacketSize = DefaultMaxPacketSize
+	}
+
+	m := &Metrics{
+		conn: conn,
+		cfg:  cfg,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go m.run()
+	return m
+}
+
+func (m *Metrics) run() {
+	defer close(m.done)
+	t := time.NewTicker(m.cfg.flushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-t.C:
+			m.Flush()
+		}
+	}
+}
+
+// Sends buffered metrics
+func (m *Metrics) Flush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flush()
+}
+
+// Sends buffer. Should be called with lock held
+func (m *Metrics) flush() {
+	if m.buf.Len() == 0 {
+		return
+	}
+	// statsd is best effort, lost packets aren't retried
+	m.conn.Write(m.buf.Bytes())
+	m.buf.Reset()
+}
+
+// Sends buffered metrics and closes connection
+func (m *Metrics) Close() error {
+	var err error
+	m.once.Do(func() {
+		close(m.stop)
+		<-m.done
+		m.Flush()
+		err = m.conn.Close()
+	})
+	return err
+}
+
+func (m *Metrics) Allowed() {
+	m.count("requests", 1, "outcome", "allowed")
+}
+
+func (m *Metrics) Rejected() {
+	m.count("requests", 1, "outcome", "rejected")
+}
+
+func (m *Metrics) Errored() {
+	m.count("requests", 1, "outcome", "error")
+}
+
+func (m *Metrics) StorageLatency(d time.Duration) {
+	m.timing("storage.duration", d)
+}
+
+func (m *Metrics) FailedOpen() {
+	m.count("fail_policy", 1, "policy", "open")
+}
+
+func (m *Metrics) LocalFallback() {
+	m.count("fail_policy", 1, "policy", "local")
+}
+
+func (m *Metrics) FailedOver() {
+	m.count("fail_policy", 1, "policy", "secondary")
+}
+
+func (m *Metrics) AgentRuleMatched(rule string, denied bool) {
+	action := "limit"
+	if denied {
+		action = "deny"
+	}
+	m.count("agent_rules", 1, "rule", rule, "action", action)
+}
+
+func (m *Metrics) ScopeMatched(scope string, allowed bool) {
+	outcome := "allowed"
+	if !allowed {
+		outcome = "rejected"
+	}
+	m.count("scope_requests", 1, "scope", scope, "outcome", outcome)
+}
+
+func (m *Metrics) StorageCalled(c gincage.StorageCall) {
+	m.timing("storage.call.duration", c.Latency, "backend", c.Backend, "outcome", storageOutcome(c))
+	if c.Retries > 0 {
+		m.count("storage.retries", int64(c.Retries), "backend", c.Backend)
+	}
+	if c.Timeout {
+		m.count("storage.timeouts", 1, "backend", c.Backend)
+	}
+}
+
+func (m *Metrics) ShadowRejected() {
+	m.count("shadow_rejections", 1)
+}
+
+func (m *Metrics) AdmissionRejected() {
+	m.count("admission_rejections", 1)
+}
+
+func storageOutcome(c gincage.StorageCall) string {
+	switch {
+	case c.Timeout:
+		return "timeout"
+	case c.Failed:
+		return "error"
+	default:
+		return "ok"
+	}
+}
+
+func (m *Metrics) count(name string, n int64, labels ...string) {
+	m.send(name, strconv.FormatInt(n, 10), "c", labels)
+}
+
+func (m *Metrics) timing(name string, d time.Duration, labels ...string) {
+	m.send(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64), "ms", labels)
+}
+
+// Buffers metric line. labels are pairs of label name and value
+func (m *Metrics) send(name, value, kind string, labels []string) {
+	var line strings.Builder
+	if m.cfg.prefix != "" {
+		line.WriteString(m.cfg.prefix)
+		line.WriteByte('.')
+	}
+	line.WriteString(name)
+	if !m.cfg.dogstatsd {
+		// plain statsd has no tags, label values are parts of name
+		for i := 1; i < len(labels); i += 2 {
+			line.WriteByte('.')
+			line.WriteString(sanitize(labels[i], '_'))
+		}
+	}
+	line.WriteByte(':')
+	line.WriteString(value)
+	line.WriteByte('|')
+	line.WriteString(kind)
+	if m.cfg.dogstatsd && len(labels)+len(m.cfg.tags) > 0 {
+		line.WriteString("|#")
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				line.WriteByte(',')
+			}
+			line.WriteString(labels[i])
+			line.WriteByte(':')
+			line.WriteString(sanitize(labels[i+1], '_'))
+		}
+		for i, tag := range m.cfg.tags {
+			if i > 0 || len(labels) > 0 {
+				line.WriteByte(',')
+			}
+			line.WriteString(tag)
+		}
+	}
+	line.WriteByte('\n')
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.buf.Len()+line.Len() > m.cfg.maxPacketSize {
+		m.flush()
+	}
+	m.buf.WriteString(line.String())
+}
+
+// Replaces characters of statsd protocol in s with r
+func sanitize(s string, r rune) string {
+	return strings.Map(func(c rune) rune {
+		switch c {
+		case ':', '|', '@', '#', ',', '\n', ' ':
+			return r
+		}
+		return c
+	}, s)
+}
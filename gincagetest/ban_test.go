@@ -0,0 +1,66 @@
+package gincagetest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	gincage "github.com/fyx1t/gin-cage"
+	"github.com/fyx1t/gin-cage/gincagetest"
+)
+
+func TestBanPolicyWithGreylist(t *testing.T) {
+	tests := []struct {
+		name     string
+		greylist bool
+	}{
+		{name: "ban policy"},
+		{name: "ban policy with greylist", greylist: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gincagetest.NewRedis(t, gincage.BucketConfigs{Capability: 1, TokensAppendDuration: time.Hour})
+			opts := []gincage.Option{
+				gincage.WithBanPolicy(gincage.BanPolicy{Threshold: 3, Window: time.Minute, Duration: time.Hour}),
+			}
+			if tt.greylist {
+				opts = append(opts, gincage.WithGreylist(gincage.GreylistPolicy{
+					Penalties: []time.Duration{time.Millisecond},
+				}))
+			}
+			l := gincage.NewLimiter(r.Bucket, opts...)
+			ctx := context.Background()
+			banner, _ := gincage.BucketAs[gincage.Banner](r.Bucket)
+
+			var bans int
+			l.OnBan(func(gincage.Event) { bans++ })
+			for range 10 {
+				// penalties of greylist end between requests
+				r.Advance(2 * time.Millisecond)
+				l.Allow(ctx, gincage.Request{Key: "10.0.0.1", Path: "/"})
+			}
+			if bans != 1 {
+				t.Fatalf("key banned %d times, want 1", bans)
+			}
+			until, err := banner.BannedUntil(ctx, "10.0.0.1")
+			if err != nil || until.IsZero() {
+				t.Fatalf("BannedUntil() = %v, %v, want ban", until, err)
+			}
+
+			list, err := l.Bans(ctx)
+			if err != nil {
+				t.Fatalf("Bans() = %v", err)
+			}
+			if len(list) != 1 || list[0].Key != "10.0.0.1" {
+				t.Fatalf("Bans() = %v, want ban of 10.0.0.1", list)
+			}
+
+			if err := l.Unban(ctx, "10.0.0.1"); err != nil {
+				t.Fatalf("Unban() = %v", err)
+			}
+			if list, _ := l.Bans(ctx); len(list) != 0 {
+				t.Fatalf("Bans() = %v after Unban, want none", list)
+			}
+		})
+	}
+}
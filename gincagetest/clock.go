@@ -0,0 +1,45 @@
+package gincagetest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock: gincage.Clock moved only by Advance and Set.
+// Clock is safe for concurrent use
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// Returns clock stopped at start. If start is zero, current time is used
+func NewClock(start time.Time) *Clock {
+	if start.IsZero() {
+		start = time.Now()
+	}
+	return &Clock{now: start}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Clock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// Moves clock forward by d
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Sets clock to t
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
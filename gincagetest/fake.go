@@ -0,0 +1,130 @@
+// Test doubles for applications using gincage.
+//
+// Handlers can be tested under limiting without redis:
+//
+//	bucket := gincagetest.Script(nil, nil, gincage.ErrNoTokensAwailable)
+//	limiter := gincage.NewLimiter(bucket)
+//	router.Use(limiter.WalkThrough())
+//	// first two requests pass, next ones are limited
+package gincagetest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	gincage "github.com/fyx1t/gin-cage"
+)
+
+// Returned by Fail when error isn't set
+var ErrFake = errors.New("gincagetest: fake storage error")
+
+// Call: take received by FakeBucket
+type Call struct {
+	Key  string
+	N    int
+	Rate gincage.Rate
+}
+
+// FakeBucket: Bucket returning scripted results.
+//
+// Results are returned in order, last one is repeated when script ends.
+// Nil result allows request, gincage.ErrNoTokensAwailable
+// (or *gincage.LimitExceededError) limits it,
+// other errors act as storage failures.
+// FakeBucket is safe for concurrent use
+type FakeBucket struct {
+	mu     sync.Mutex
+	script []error
+	calls  []Call
+	closed bool
+}
+
+// Returns bucket allowing every request
+func Allow() *FakeBucket {
+	return Script(nil)
+}
+
+// Returns bucket limiting every request
+func Deny() *FakeBucket {
+	return Script(gincage.ErrNoTokensAwailable)
+}
+
+// Returns bucket allowing n requests and limiting next ones
+func AllowN(n int) *FakeBucket {
+	return FailAfter(n, gincage.ErrNoTokensAwailable)
+}
+
+// Returns bucket failing every request with err.
+// If err is nil, ErrFake is used
+func Fail(err error) *FakeBucket {
+	if err == nil {
+		err = ErrFake
+	}
+	return Script(err)
+}
+
+// Returns bucket allowing n requests and failing next ones with err.
+// If err is nil, ErrFake is used
+func FailAfter(n int, err error) *FakeBucket {
+	if err == nil {
+		err = ErrFake
+	}
+	script := make([]error, n, n+1)
+	return Script(append(script, err)...)
+}
+
+// Returns bucket returning results in order. Last result is repeated,
+// empty script allows every request
+func Script(results ...error) *FakeBucket {
+	return &FakeBucket{script: results}
+}
+
+// Returns next scripted result and records call
+func (b *FakeBucket) Take(ctx context.Context, key string, n int) (gincage.Result, error) {
+	opts, _ := gincage.WalkOptionsFromContext(ctx)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls = append(b.calls, Call{Key: key, N: max(n, 1), Rate: opts.Rate})
+	var err error
+	if len(b.script) > 0 {
+		err = b.script[0]
+		if len(b.script) > 1 {
+			b.script = b.script[1:]
+		}
+	}
+	var le *gincage.LimitExceededError
+	switch {
+	case err == nil:
+		return gincage.Result{Allowed: true, Remaining: -1}, nil
+	case errors.As(err, &le):
+		return gincage.Result{Remaining: le.Remaining, Limit: le.Limit, RetryAfter: max(time.Until(le.Reset), 0)}, nil
+	case errors.Is(err, gincage.ErrNoTokensAwailable):
+		return gincage.Result{Remaining: -1}, nil
+	default:
+		return gincage.Result{}, err
+	}
+}
+
+// Returns takes received by bucket
+func (b *FakeBucket) Calls() []Call {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Call(nil), b.calls...)
+}
+
+func (b *FakeBucket) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	return nil
+}
+
+// Returns true if bucket was closed
+func (b *FakeBucket) Closed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
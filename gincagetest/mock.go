@@ -0,0 +1,67 @@
+package gincagetest
+
+import (
+	"context"
+	"sync"
+
+	gincage "github.com/fyx1t/gin-cage"
+)
+
+// MockBucket: Bucket delegating to TakeFunc and CloseFunc, for tests
+// deciding results by key, cost or rate of take:
+//
+//	bucket := &gincagetest.MockBucket{
+//		TakeFunc: func(ctx context.Context, key string, n int) (gincage.Result, error) {
+//			return gincage.Result{Allowed: key != "10.0.0.1", Remaining: -1}, nil
+//		},
+//	}
+//
+// Nil TakeFunc allows every request, nil CloseFunc returns nil.
+// Takes are recorded before TakeFunc is called. MockBucket is safe
+// for concurrent use if its funcs are
+type MockBucket struct {
+	TakeFunc  func(ctx context.Context, key string, n int) (gincage.Result, error)
+	CloseFunc func() error
+
+	mu     sync.Mutex
+	calls  []Call
+	closed bool
+}
+
+// Records take and returns result of TakeFunc
+func (b *MockBucket) Take(ctx context.Context, key string, n int) (gincage.Result, error) {
+	opts, _ := gincage.WalkOptionsFromContext(ctx)
+	b.mu.Lock()
+	b.calls = append(b.calls, Call{Key: key, N: max(n, 1), Rate: opts.Rate})
+	b.mu.Unlock()
+
+	if b.TakeFunc == nil {
+		return gincage.Result{Allowed: true, Remaining: -1}, nil
+	}
+	return b.TakeFunc(ctx, key, n)
+}
+
+// Returns takes received by bucket
+func (b *MockBucket) Calls() []Call {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Call(nil), b.calls...)
+}
+
+func (b *MockBucket) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+
+	if b.CloseFunc == nil {
+		return nil
+	}
+	return b.CloseFunc()
+}
+
+// Returns true if bucket was closed
+func (b *MockBucket) Closed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
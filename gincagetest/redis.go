@@ -0,0 +1,70 @@
+package gincagetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	gincage "github.com/fyx1t/gin-cage"
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis: in-process redis (miniredis) with RedisBucket on top of it
+type Redis struct {
+	Server *miniredis.Miniredis
+	Client *redis.Client
+	Bucket gincage.Bucket
+	// Used by Bucket for refill math, moved by Advance
+	Clock *Clock
+}
+
+// Starts miniredis and creates RedisBucket with cfg limits against it.
+// cfg.Clock should be nil (new Clock is used) or *Clock, other clocks
+// fail t, since Advance couldn't move them.
+// Everything is stopped with t.Cleanup
+func NewRedis(t testing.TB, cfg gincage.BucketConfigs) *Redis {
+	t.Helper()
+
+	clock, ok := cfg.Clock.(*Clock)
+	switch {
+	case cfg.Clock == nil:
+		clock = NewClock(time.Time{})
+		cfg.Clock = clock
+	case !ok:
+		t.Fatalf("gincagetest: cfg.Clock should be nil or *gincagetest.Clock, got %T", cfg.Clock)
+	}
+
+	server := miniredis.RunT(t)
+	server.SetTime(clock.Now())
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	bucket := gincage.NewRedisBucketWithClient(cfg, client)
+	t.Cleanup(func() {
+		bucket.Close()
+	})
+
+	return &Redis{
+		Server: server,
+		Client: client,
+		Bucket: bucket,
+		Clock:  clock,
+	}
+}
+
+// Moves clock and TTLs of miniredis forward by d together,
+// so refills and key expiry happen as if d passed
+func (r *Redis) Advance(d time.Duration) {
+	r.Clock.Advance(d)
+	r.Server.SetTime(r.Clock.Now())
+	r.Server.FastForward(d)
+}
+
+// Simulates storage outage until Restart
+func (r *Redis) Stop() {
+	r.Server.Close()
+}
+
+// Restarts storage on the same address, data is kept
+func (r *Redis) Restart() error {
+	return r.Server.Restart()
+}
@@ -0,0 +1,153 @@
+package gincagetest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	gincage "github.com/fyx1t/gin-cage"
+	"github.com/fyx1t/gin-cage/gincagetest"
+)
+
+func TestRedisTake(t *testing.T) {
+	type step struct {
+		// time passed before take
+		advance time.Duration
+		n       int
+		allowed bool
+		// tokens left after take, checked if allowed
+		remaining int
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "takes until empty",
+			steps: []step{
+				{n: 1, allowed: true, remaining: 2},
+				{n: 1, allowed: true, remaining: 1},
+				{n: 1, allowed: true, remaining: 0},
+				{n: 1},
+			},
+		},
+		{
+			name: "cost above tokens left",
+			steps: []step{
+				{n: 2, allowed: true, remaining: 1},
+				{n: 2},
+				{n: 1, allowed: true, remaining: 0},
+			},
+		},
+		{
+			name: "refill",
+			steps: []step{
+				{n: 3, allowed: true, remaining: 0},
+				{advance: 500 * time.Millisecond, n: 1},
+				{advance: 500 * time.Millisecond, n: 1, allowed: true, remaining: 0},
+				{advance: time.Hour, n: 1, allowed: true, remaining: 2},
+			},
+		},
+		{
+			name: "expired key starts full",
+			steps: []step{
+				{n: 3, allowed: true, remaining: 0},
+				{advance: time.Minute + time.Second, n: 3, allowed: true, remaining: 0},
+			},
+		},
+	}
+	for _, functions := range []bool{false, true} {
+		for _, tt := range tests {
+			name := tt.name
+			if functions {
+				name += " with functions"
+			}
+			t.Run(name, func(t *testing.T) {
+				r := gincagetest.NewRedis(t, gincage.BucketConfigs{
+					Capability:           3,
+					TokensAppendDuration: time.Second,
+					TokensExist:          time.Minute,
+					Functions:            functions,
+				})
+				for i, s := range tt.steps {
+					r.Advance(s.advance)
+					res, err := r.Bucket.Take(context.Background(), "k", s.n)
+					if err != nil {
+						t.Fatalf("steps[%d]: Take() = %v", i, err)
+					}
+					if res.Allowed != s.allowed {
+						t.Fatalf("steps[%d]: Allowed = %v, want %v", i, res.Allowed, s.allowed)
+					}
+					if s.allowed && res.Remaining != s.remaining {
+						t.Fatalf("steps[%d]: Remaining = %d, want %d", i, res.Remaining, s.remaining)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestRedisWalkMany(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []gincage.KeyedCost
+		// key without enough tokens, empty if all are taken
+		denied string
+		// tokens of keys after walk
+		tokens map[string]int
+	}{
+		{
+			name:   "all keys taken",
+			keys:   []gincage.KeyedCost{{Key: "a", Cost: 1}, {Key: "b", Cost: 2}},
+			tokens: map[string]int{"a": 2, "b": 1},
+		},
+		{
+			name:   "same key merged",
+			keys:   []gincage.KeyedCost{{Key: "a", Cost: 1}, {Key: "a", Cost: 1}},
+			tokens: map[string]int{"a": 1},
+		},
+		{
+			name:   "nothing taken if one key is short",
+			keys:   []gincage.KeyedCost{{Key: "a", Cost: 1}, {Key: "b", Cost: 4}},
+			denied: "b",
+			tokens: map[string]int{"a": 3, "b": 3},
+		},
+		{
+			name:   "own rate of key",
+			keys:   []gincage.KeyedCost{{Key: "a", Cost: 5, Rate: gincage.Rate{Capacity: 10}}},
+			tokens: map[string]int{"a": 5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gincagetest.NewRedis(t, gincage.BucketConfigs{Capability: 3, TokensAppendDuration: time.Hour})
+			walker, _ := gincage.BucketAs[gincage.BatchWalker](r.Bucket)
+			ctx := context.Background()
+
+			err := walker.WalkMany(ctx, tt.keys)
+			var e *gincage.LimitExceededError
+			switch {
+			case tt.denied == "" && err != nil:
+				t.Fatalf("WalkMany() = %v", err)
+			case tt.denied != "" && !errors.As(err, &e):
+				t.Fatalf("WalkMany() = %v, want *LimitExceededError", err)
+			}
+
+			peeker, _ := gincage.BucketAs[gincage.Peeker](r.Bucket)
+			for key, want := range tt.tokens {
+				st, err := peeker.Peek(ctx, key)
+				if err != nil {
+					t.Fatalf("Peek(%q) = %v", key, err)
+				}
+				// peek doesn't know own rate of key, it's full at bucket capacity
+				if st.Exists && st.Tokens != want {
+					t.Errorf("tokens of %q = %d, want %d", key, st.Tokens, want)
+				}
+				if !st.Exists && want != 3 {
+					t.Errorf("%q isn't stored, want %d tokens", key, want)
+				}
+			}
+		})
+	}
+}
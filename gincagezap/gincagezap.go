@@ -0,0 +1,49 @@
+// zap adapter for gincage.Logger.
+//
+// Usage:
+//
+//	limiter := gincage.NewLimiter(bucket, gincage.WithLogger(gincagezap.New(zapLogger)))
+package gincagezap
+
+import (
+	gincage "github.com/fyx1t/gin-cage"
+	"go.uber.org/zap"
+)
+
+var _ gincage.Logger = logger{}
+
+type logger struct {
+	l *zap.Logger
+}
+
+// Adapts zap logger to gincage.Logger. If l is nil, zap.L() is used
+func New(l *zap.Logger) gincage.Logger {
+	if l == nil {
+		l = zap.L()
+	}
+	return logger{l: l.WithOptions(zap.AddCallerSkip(1))}
+}
+
+func (z logger) Debug(msg string, fields ...gincage.Field) {
+	z.l.Debug(msg, convert(fields)...)
+}
+
+func (z logger) Info(msg string, fields ...gincage.Field) {
+	z.l.Info(msg, convert(fields)...)
+}
+
+func (z logger) Warn(msg string, fields ...gincage.Field) {
+	z.l.Warn(msg, convert(fields)...)
+}
+
+func (z logger) Error(msg string, fields ...gincage.Field) {
+	z.l.Error(msg, convert(fields)...)
+}
+
+func convert(fields []gincage.Field) []zap.Field {
+	zf := make([]zap.Field, len(fields))
+	for i, f := range fields {
+		zf[i] = zap.Any(f.Key, f.Value)
+	}
+	return zf
+}
@@ -0,0 +1,53 @@
+// zerolog adapter for gincage.Logger.
+//
+// Usage:
+//
+//	limiter := gincage.NewLimiter(bucket, gincage.WithLogger(gincagezerolog.New(zerologLogger)))
+package gincagezerolog
+
+import (
+	gincage "github.com/fyx1t/gin-cage"
+	"github.com/rs/zerolog"
+)
+
+var _ gincage.Logger = logger{}
+
+type logger struct {
+	l zerolog.Logger
+}
+
+// Adapts zerolog logger to gincage.Logger
+func New(l zerolog.Logger) gincage.Logger {
+	return logger{l: l}
+}
+
+func (z logger) Debug(msg string, fields ...gincage.Field) {
+	send(z.l.Debug(), msg, fields)
+}
+
+func (z logger) Info(msg string, fields ...gincage.Field) {
+	send(z.l.Info(), msg, fields)
+}
+
+func (z logger) Warn(msg string, fields ...gincage.Field) {
+	send(z.l.Warn(), msg, fields)
+}
+
+func (z logger) Error(msg string, fields ...gincage.Field) {
+	send(z.l.Error(), msg, fields)
+}
+
+func send(e *zerolog.Event, msg string, fields []gincage.Field) {
+	// event is nil when level is disabled
+	if e == nil {
+		return
+	}
+	for _, f := range fields {
+		if err, ok := f.Value.(error); ok {
+			e = e.AnErr(f.Key, err)
+			continue
+		}
+		e = e.Interface(f.Key, f.Value)
+	}
+	e.Msg(msg)
+}
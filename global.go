@@ -0,0 +1,84 @@
+package gincage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Storage key of global limit. Keys starting with "|" are reserved for
+// limiter: scopes can't be empty and client keys starting with "|" are escaped
+const globalKey = "|global"
+
+// Returns storage key of client key used without scope. Keys starting
+// with "|" get one more "|", so they can't clash with globalKey
+func clientKey(key string) string {
+	if strings.HasPrefix(key, "|") {
+		return "|" + key
+	}
+	return key
+}
+
+// Matches error in Decision when request was rejected because of Config.Global.
+// Key of client isn't penalized by ban policy and greylist for it
+var ErrGlobalLimit = fmt.Errorf("global limit: %w", ErrNoTokensAwailable)
+
+// Walks through key of client and global key.
+//
+// If bucket implements BatchWalker, both keys are taken at once,
+// so neither of them is spent when other one is exhausted
+// (reserve of priority class is checked only by Take).
+// Otherwise key of client is taken first, so rejected clients don't spend
+// global tokens. Token of client isn't returned if global limit is reached
+func (l *Limiter) walkGlobal(ctx context.Context, opts WalkOptions, global Rate) (*WalkStats, Result, time.Duration, error) {
+	if w, ok := l.bucket.(BatchWalker); ok && opts.Reserve <= 0 {
+		return l.walkMany(ctx, w, opts, KeyedCost{Key: globalKey, Cost: opts.Cost, Rate: global})
+	}
+
+	stats, res, latency, err := l.walk(ctx, l.bucket, opts)
+	if err != nil {
+		return stats, res, latency, err
+	}
+
+	gstats, _, glatency, err := l.walk(ctx, l.bucket, WalkOptions{Key: globalKey, Rate: global, Cost: opts.Cost})
+	stats.Retries += gstats.Retries
+	return stats, res, latency + glatency, err
+}
+
+// Takes token of opts key together with tokens of other keys.
+// Returns result of opts key, remaining tokens are unknown when allowed
+func (l *Limiter) walkMany(ctx context.Context, w BatchWalker, opts WalkOptions, other ...KeyedCost) (*WalkStats, Result, time.Duration, error) {
+	stats := &WalkStats{}
+	ctx, cancel := l.storageContext(ctx)
+	defer cancel()
+	ctx = ContextWithWalkStats(ctx, stats)
+	ctx = ContextWithWalkOptions(ctx, opts)
+
+	keys := append([]KeyedCost{{Key: opts.Key, Cost: opts.Cost, Rate: opts.Rate}}, other...)
+	start := time.Now()
+	err := w.WalkMany(ctx, keys)
+	latency := time.Since(start)
+	res, _ := resultOf(err)
+	return stats, res, latency, err
+}
+
+// Rejects request because global limit is reached
+func (l *Limiter) rejectGlobal(req Request, global Rate, err error) Decision {
+	l.rejected(req)
+
+	retryAfter, ok := retryAfterOf(err)
+	if !ok {
+		retryAfter = global.Refill
+	}
+	if r, ok := BucketAs[RefillIntervaler](l.bucket); ok && retryAfter <= 0 {
+		retryAfter = r.RefillInterval()
+	}
+	d := Decision{Err: err, RetryAfter: retryAfter}
+	var e *LimitExceededError
+	if errors.As(err, &e) {
+		d.Limit, d.Remaining = e.Limit, e.Remaining
+	}
+	return d
+}
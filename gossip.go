@@ -0,0 +1,388 @@
+package gincage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// Default count of random peers gossip message is sent to
+	DefaultGossipFanout = 3
+	// Default max count of gossip message forwards
+	DefaultGossipMaxHops = 3
+	// Default time after silent peer is forgotten
+	DefaultGossipPeerTimeout = time.Duration(30 * time.Second)
+)
+
+const (
+	// Max size of gossip datagram
+	gossipMaxSize = 64 * 1024
+	// Max count of peers sent in one message
+	gossipMaxPeers = 16
+)
+
+var (
+	errGossipTooLarge = errors.New("gossip message is too large")
+	errGossipBadMAC   = errors.New("gossip message has bad signature")
+)
+
+// GossipConfigs: settings of gossip cluster
+type GossipConfigs struct {
+	// UDP address to listen on, e.g. ":7946"
+	BindAddr string
+	// Addresses of instances used to join cluster. Other instances
+	// are discovered from messages
+	Seeds []string
+	// Count of random peers message is sent to. If <= 0, uses DefaultGossipFanout
+	Fanout int
+	// Max count of message forwards. If <= 0, uses DefaultGossipMaxHops
+	MaxHops int
+	// Time after silent peer is forgotten, seeds are never forgotten.
+	// Heartbeats are sent 3 times per timeout.
+	// If <= 0, uses DefaultGossipPeerTimeout
+	PeerTimeout time.Duration
+	// Logs network errors. If nil, logs are discarded
+	Logger Logger
+	// Shared secret of instances. Messages are signed with HMAC-SHA256
+	// of it, messages with bad signature are dropped. If empty, messages
+	// aren't signed, so anyone reaching BindAddr can change limits
+	Secret string
+}
+
+type gossipMessage struct {
+	// Random id of sending node, used to detect own messages
+	Node string `json:"node"`
+	// Empty for heartbeats
+	ID      string   `json:"id,omitempty"`
+	Hops    int      `json:"hops,omitempty"`
+	Peers   []string `json:"peers,omitempty"`
+	Payload []byte   `json:"payload,omitempty"`
+}
+
+// GossipBroadcaster: Broadcaster spreading messages between instances
+// over UDP without central datastore.
+//
+// Every message is sent to Fanout random peers, receivers forward
+// it to their random peers until MaxHops is reached. Delivery isn't
+// guaranteed, so it fits approximate data like taken tokens.
+//
+// Messages change limits of all instances, so BindAddr should be reachable
+// by instances only, not exposed to clients. Set Secret to sign messages
+type GossipBroadcaster struct {
+	cfg  GossipConfigs
+	conn *net.UDPConn
+	node string
+
+	mu    sync.Mutex
+	peers map[string]time.Time
+	seeds map[string]bool
+	self  map[string]bool
+	seen  map[string]time.Time
+
+	msgs      chan []byte
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// Starts listening on cfg.BindAddr and joins cluster through seeds
+func NewGossipBroadcaster(cfg GossipConfigs) (*GossipBroadcaster, error) {
+	if cfg.Fanout <= 0 {
+		cfg.Fanout = DefaultGossipFanout
+	}
+	if cfg.MaxHops <= 0 {
+		cfg.MaxHops = DefaultGossipMaxHops
+	}
+	if cfg.PeerTimeout <= 0 {
+		cfg.PeerTimeout = DefaultGossipPeerTimeout
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = NopLogger{}
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", cfg.BindAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &GossipBroadcaster{
+		cfg:   cfg,
+		conn:  conn,
+		node:  randomID(),
+		peers: make(map[string]time.Time),
+		seeds: make(map[string]bool),
+		self:  make(map[string]bool),
+		seen:  make(map[string]time.Time),
+		msgs:  make(chan []byte, 1024),
+		done:  make(chan struct{}),
+	}
+	for _, s := range cfg.Seeds {
+		g.seeds[s] = true
+		g.peers[s] = time.Now()
+	}
+
+	g.wg.Add(2)
+	go g.receive()
+	go g.heartbeat()
+	return g, nil
+}
+
+// Returns address gossip listens on
+func (g *GossipBroadcaster) Addr() net.Addr {
+	return g.conn.LocalAddr()
+}
+
+// Returns addresses of known peers
+func (g *GossipBroadcaster) Peers() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	peers := make([]string, 0, len(g.peers))
+	for p := range g.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// Sends msg to random peers
+func (g *GossipBroadcaster) Publish(ctx context.Context, msg []byte) error {
+	m := gossipMessage{Node: g.node, ID: randomID(), Payload: msg}
+
+	g.mu.Lock()
+	g.seen[m.ID] = time.Now()
+	g.mu.Unlock()
+	return g.send(m, "")
+}
+
+// Calls handler for messages of other instances until ctx is canceled
+func (g *GossipBroadcaster) Subscribe(ctx context.Context, handler func(msg []byte)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-g.done:
+			return net.ErrClosed
+		case msg := <-g.msgs:
+			handler(msg)
+		}
+	}
+}
+
+// Stops gossip and closes connection
+func (g *GossipBroadcaster) Close() error {
+	var err error
+	g.closeOnce.Do(func() {
+		close(g.done)
+		err = g.conn.Close()
+		g.wg.Wait()
+	})
+	return err
+}
+
+// Sends m to random peers except one it came from
+func (g *GossipBroadcaster) send(m gossipMessage, from string) error {
+	peers := g.randomPeers(g.cfg.Fanout, from)
+	m.Peers = g.randomPeers(gossipMaxPeers, "")
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	data = g.sign(data)
+	if len(data) > gossipMaxSize {
+		return errGossipTooLarge
+	}
+
+	var errs []error
+	for _, p := range peers {
+		addr, err := net.ResolveUDPAddr("udp", p)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, err := g.conn.WriteToUDP(data, addr); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (g *GossipBroadcaster) randomPeers(n int, except string) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	peers := make([]string, 0, len(g.peers))
+	for p := range g.peers {
+		if p != except {
+			peers = append(peers, p)
+		}
+	}
+	rand.Shuffle(len(peers), func(i, j int) {
+		peers[i], peers[j] = peers[j], peers[i]
+	})
+	return peers[:min(n, len(peers))]
+}
+
+func (g *GossipBroadcaster) receive() {
+	defer g.wg.Done()
+
+	buf := make([]byte, gossipMaxSize)
+	for {
+		n, addr, err := g.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-g.done:
+				return
+			default:
+			}
+			g.cfg.Logger.Warn("gossip read failed", F("error", err))
+			continue
+		}
+
+		data, err := g.verify(buf[:n])
+		if err != nil {
+			g.cfg.Logger.Warn("gossip message dropped", F("error", err), F("from", addr.String()))
+			continue
+		}
+		var m gossipMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			g.cfg.Logger.Warn("bad gossip message", F("error", err), F("from", addr.String()))
+			continue
+		}
+		if fresh := g.learn(m, addr.String()); !fresh {
+			continue
+		}
+
+		select {
+		case g.msgs <- m.Payload:
+		default:
+			g.cfg.Logger.Warn("gossip queue is full, message dropped")
+		}
+		if m.Hops+1 < g.cfg.MaxHops {
+			m.Node = g.node
+			m.Hops++
+			if err := g.send(m, addr.String()); err != nil {
+				g.cfg.Logger.Warn("gossip forward failed", F("error", err))
+			}
+		}
+	}
+}
+
+// Prepends HMAC of data if secret is set
+func (g *GossipBroadcaster) sign(data []byte) []byte {
+	if g.cfg.Secret == "" {
+		return data
+	}
+	mac := hmac.New(sha256.New, []byte(g.cfg.Secret))
+	mac.Write(data)
+	return append(mac.Sum(nil), data...)
+}
+
+// Checks and strips HMAC of datagram if secret is set
+func (g *GossipBroadcaster) verify(datagram []byte) ([]byte, error) {
+	if g.cfg.Secret == "" {
+		return datagram, nil
+	}
+	if len(datagram) < sha256.Size {
+		return nil, errGossipBadMAC
+	}
+	sum, data := datagram[:sha256.Size], datagram[sha256.Size:]
+	mac := hmac.New(sha256.New, []byte(g.cfg.Secret))
+	mac.Write(data)
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return nil, errGossipBadMAC
+	}
+	return data, nil
+}
+
+// Updates peers from message. Returns true if message
+// carries payload which wasn't seen before
+func (g *GossipBroadcaster) learn(m gossipMessage, from string) bool {
+	now := time.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if m.Node == g.node {
+		// own address as seen by network
+		g.self[from] = true
+		delete(g.peers, from)
+		return false
+	}
+	g.peers[from] = now
+	for _, p := range m.Peers {
+		if _, ok := g.peers[p]; !ok && !g.self[p] {
+			g.peers[p] = now
+		}
+	}
+
+	if m.ID == "" {
+		return false
+	}
+	if _, ok := g.seen[m.ID]; ok {
+		return false
+	}
+	g.seen[m.ID] = now
+	return true
+}
+
+// Announces node to peers and forgets silent peers and old messages
+func (g *GossipBroadcaster) heartbeat() {
+	defer g.wg.Done()
+
+	t := time.NewTicker(g.cfg.PeerTimeout / 3)
+	defer t.Stop()
+	for {
+		select {
+		case <-g.done:
+			return
+		case <-t.C:
+		}
+
+		now := time.Now()
+		g.mu.Lock()
+		for p, at := range g.peers {
+			if !g.seeds[p] && now.Sub(at) > g.cfg.PeerTimeout {
+				delete(g.peers, p)
+			}
+		}
+		for id, at := range g.seen {
+			if now.Sub(at) > g.cfg.PeerTimeout {
+				delete(g.seen, id)
+			}
+		}
+		g.mu.Unlock()
+
+		if err := g.send(gossipMessage{Node: g.node}, ""); err != nil {
+			g.cfg.Logger.Debug("gossip heartbeat failed", F("error", err))
+		}
+	}
+}
+
+func randomID() string {
+	return strconv.FormatUint(rand.Uint64(), 36)
+}
+
+// Creates in-memory bucket with limits of cfg which shares taken tokens
+// with other instances over gossip (see NewBroadcastBucket, GossipBroadcaster).
+// Close stops gossip
+func NewGossipBucket(cfg BucketConfigs, gcfg GossipConfigs, bcfg BroadcastConfigs) (Bucket, error) {
+	if gcfg.Logger == nil {
+		gcfg.Logger = bcfg.Logger
+	}
+	g, err := NewGossipBroadcaster(gcfg)
+	if err != nil {
+		return nil, err
+	}
+	b := newBroadcastBucket(cfg, g, bcfg)
+	b.closer = g
+	return b, nil
+}
@@ -0,0 +1,105 @@
+package gincage
+
+import (
+	"context"
+	"time"
+)
+
+var (
+	// Default penalties of greylist offenses
+	DefaultGreylistPenalties = []time.Duration{10 * time.Second, time.Minute, 10 * time.Minute, time.Hour}
+	// Default time offenses of key are remembered
+	DefaultGreylistMemory = time.Duration(24 * time.Hour)
+)
+
+// Prefix of keys counting greylist offenses, keeps them apart
+// from violations counted by ban policy
+const greylistKeyPrefix = "greylist|"
+
+// Prefix of ban keys keeping greylist penalties. Banner.Ban clears
+// violations of banned key, so penalties can't be bans of key itself
+const greylistedKeyPrefix = "greylisted|"
+
+// GreylistPolicy: escalating friction for keys exceeding their limits.
+//
+// Every rejection because of rate limit is offense of key. Offender is
+// rejected without touching tokens for penalty, which grows with count
+// of offenses remembered. Penalties are stored as bans of bucket under
+// their own keys, so they are shared by instances, don't reset violations
+// of ban policy and can be lifted with Limiter.Unban
+type GreylistPolicy struct {
+	// Penalty by offense number: first offense gets Penalties[0], second one
+	// Penalties[1], ... Last penalty is used for all next offenses.
+	// If empty, uses DefaultGreylistPenalties
+	Penalties []time.Duration
+	// Time offenses are remembered, counted from first offense.
+	// If <= 0, uses DefaultGreylistMemory
+	Memory time.Duration
+	// Delay of responses to repeat offenders, so aggressive clients are
+	// slowed down instead of retrying at once. Delay is cut by request
+	// context and penalty. Zero disables tarpit
+	Tarpit time.Duration
+}
+
+// Enables greylisting. Bucket has to implement Banner, otherwise option is ignored
+func WithGreylist(p GreylistPolicy) Option {
+	return func(l *Limiter) {
+		if len(p.Penalties) == 0 {
+			p.Penalties = DefaultGreylistPenalties
+		}
+		p.Penalties = append([]time.Duration(nil), p.Penalties...)
+		if p.Memory <= 0 {
+			p.Memory = DefaultGreylistMemory
+		}
+		l.greylist = &p
+	}
+}
+
+// Returns penalty of n-th offense
+func (p *GreylistPolicy) penalty(n int) time.Duration {
+	return p.Penalties[min(max(n, 1), len(p.Penalties))-1]
+}
+
+// Returns true if penalty ending at until was given for repeat offense
+func (p *GreylistPolicy) repeat(until time.Time) bool {
+	return time.Until(until) > p.Penalties[0]
+}
+
+// Registers offense of key and penalizes it.
+// Returns penalty and true if key is repeat offender
+func (l *Limiter) registerOffense(ctx context.Context, req Request) (time.Duration, bool) {
+	key := req.Key
+	rctx, cancel := l.storageContext(ctx)
+	defer cancel()
+	n, err := l.banner.AddViolation(rctx, greylistKeyPrefix+key, l.greylist.Memory)
+	if err != nil {
+		l.log(req.RequestID).Error("failed to register offense", F("error", err), F("key", key))
+		return 0, false
+	}
+
+	d := l.greylist.penalty(n)
+	if err := l.banner.Ban(rctx, greylistedKeyPrefix+key, d); err != nil {
+		l.log(req.RequestID).Error("failed to penalize key", F("error", err), F("key", key))
+		return 0, false
+	}
+	l.log(req.RequestID).Info("key greylisted",
+		F("key", key),
+		F("offenses", n),
+		F("penalty", d),
+	)
+	return d, n > 1
+}
+
+// Delays response to repeat offender for tarpit duration, at most for d
+func (l *Limiter) tarpit(ctx context.Context, d time.Duration) {
+	d = min(d, l.greylist.Tarpit)
+	if d <= 0 {
+		return
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
@@ -0,0 +1,69 @@
+package gincage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Returns nil if limiter can limit requests now, e.g. for readiness probes.
+//
+// Storage is pinged if bucket implements HealthChecker (buckets without it,
+// like MemoryBucket, are always healthy). Failed storage doesn't make limiter
+// unhealthy with FailLocal policy or answering secondary bucket
+// (see WithSecondary), as requests are still limited.
+// Ping is bounded by storage timeout (see WithStorageTimeout)
+func (l *Limiter) Healthy(ctx context.Context) error {
+	if l.closeCtx.Err() != nil {
+		return ErrLimiterClosed
+	}
+	err := l.ping(ctx, l.bucket)
+	if err == nil {
+		return nil
+	}
+	switch l.failPolicy {
+	case FailLocal:
+		return nil
+	case FailSecondary:
+		if serr := l.ping(ctx, l.secondary); serr != nil {
+			return errors.Join(err, serr)
+		}
+		return nil
+	}
+	return err
+}
+
+// Pings storage of b if it implements HealthChecker
+func (l *Limiter) ping(ctx context.Context, b Bucket) error {
+	h, ok := BucketAs[HealthChecker](b)
+	if !ok {
+		return nil
+	}
+	ctx, cancel := l.storageContext(ctx)
+	defer cancel()
+	return h.Ping(ctx)
+}
+
+// Returns nil if all limiters of chain are healthy, see Limiter.Healthy
+func (c *LimiterChain) Healthy(ctx context.Context) error {
+	var errs []error
+	for _, l := range c.limiters {
+		errs = append(errs, l.Healthy(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// Returns gin handler for readiness probes: responds 200 OK
+// if limiter is healthy, otherwise 503 Service Unavailable
+// with {"error": "..."} body. See Healthy
+func (l *Limiter) HealthHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if err := l.Healthy(ctx.Request.Context()); err != nil {
+			ctx.JSON(http.StatusServiceUnavailable, adminError{Error: err.Error()})
+			return
+		}
+		ctx.Status(http.StatusOK)
+	}
+}
@@ -0,0 +1,115 @@
+package gincage
+
+import (
+	"sync"
+	"time"
+)
+
+// Event: limiter decision passed to hooks
+type Event struct {
+	// Client key (ip by default)
+	Key    string
+	Method string
+	Path   string
+	Time   time.Time
+	// Id of request, see Request.RequestID
+	RequestID string
+
+	// Storage error for OnStorageError hooks
+	Err error
+	// Ban duration for OnBan hooks
+	BanDuration time.Duration
+}
+
+// Hook: callback registered on limiter.
+//
+// Hooks are called synchronously in request goroutine,
+// so they should be fast or pass event to another goroutine
+type Hook func(e Event)
+
+type hookKind int
+
+const (
+	hookAllow hookKind = iota
+	hookReject
+	hookBan
+	hookStorageError
+	hookChallenge
+	hookFailover
+	hookRecover
+	hookKindsCount
+)
+
+type hooks struct {
+	mu    sync.RWMutex
+	hooks [hookKindsCount][]Hook
+}
+
+func (h *hooks) add(kind hookKind, hook Hook) {
+	if hook == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hooks[kind] = append(h.hooks[kind], hook)
+}
+
+// Calls hooks of kind without lock held, so hooks can register other hooks
+// and slow ones don't block add. Registered hooks are only appended,
+// so slice taken under lock isn't changed later
+func (h *hooks) emit(kind hookKind, e Event) {
+	h.mu.RLock()
+	hooks := h.hooks[kind]
+	h.mu.RUnlock()
+	for _, hook := range hooks {
+		hook(e)
+	}
+}
+
+// Registers hook called when request walked through the limiter
+func (l *Limiter) OnAllow(h Hook) {
+	l.hooks.add(hookAllow, h)
+}
+
+// Registers hook called when request was rejected
+func (l *Limiter) OnReject(h Hook) {
+	l.hooks.add(hookReject, h)
+}
+
+// Registers hook called when key was banned by ban policy
+func (l *Limiter) OnBan(h Hook) {
+	l.hooks.add(hookBan, h)
+}
+
+// Registers hook called when key was challenged by challenge policy
+func (l *Limiter) OnChallenge(h Hook) {
+	l.hooks.add(hookChallenge, h)
+}
+
+// Registers hook called when bucket returned error
+func (l *Limiter) OnStorageError(h Hook) {
+	l.hooks.add(hookStorageError, h)
+}
+
+func (l *Limiter) newEvent(req Request) Event {
+	return Event{
+		Key:       req.Key,
+		Method:    req.Method,
+		Path:      req.Path,
+		Time:      time.Now(),
+		RequestID: req.RequestID,
+	}
+}
+
+// Registers hook called when limiter switched to fallback or secondary
+// bucket because storage failed (see FailLocal, WithSecondary).
+// Event carries storage error and id of request noticing failure,
+// but not its key
+func (l *Limiter) OnFailover(h Hook) {
+	l.hooks.add(hookFailover, h)
+}
+
+// Registers hook called when storage recovered after failover
+func (l *Limiter) OnRecover(h Hook) {
+	l.hooks.add(hookRecover, h)
+}
@@ -0,0 +1,84 @@
+package gincage
+
+import (
+	"net"
+	"net/http"
+)
+
+// HTTPKeyFunc: returns key identifying client of net/http request
+type HTTPKeyFunc func(r *http.Request) string
+
+// Keys clients of net/http requests by ip of connection.
+// Default HTTPKeyFunc. Proxy headers aren't trusted
+func RemoteIPKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Sets function extracting client key from net/http request.
+// Default is RemoteIPKey. If f is nil, option is ignored
+func WithHTTPKeyFunc(f HTTPKeyFunc) Option {
+	return func(l *Limiter) {
+		if f != nil {
+			l.httpKeyFunc = f
+		}
+	}
+}
+
+// Returns net/http middleware sharing bucket, limits and metrics
+// with gin middleware of limiter:
+//
+//	mux := http.NewServeMux()
+//	...
+//	http.ListenAndServe(addr, limiter.Middleware(mux))
+//
+// Route rules are matched against request path,
+// allowlist is checked against ip of connection
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, ok := l.httpRequest(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		d := l.Allow(r.Context(), req)
+		if !d.Allowed {
+			l.Render(d).Write(w)
+			return
+		}
+		for k, v := range l.Header(d) {
+			w.Header()[k] = v
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Builds Request of net/http request. Returns false if request is skipped
+// by skip functions
+func (l *Limiter) httpRequest(r *http.Request) (Request, bool) {
+	for _, skip := range l.httpSkipFuncs {
+		if skip(r) {
+			return Request{}, false
+		}
+	}
+	req := newRequest(r, l.httpKeyFunc(r), RemoteIPKey(r), "")
+	req.Priority = l.headerPriority(r)
+	req.Cost = l.cost(r)
+	req.RequestID = l.headerRequestID(r)
+	return req, true
+}
+
+// Builds Request of r, r can be nil
+func newRequest(r *http.Request, key, ip, route string) Request {
+	req := Request{Key: key, IP: ip, Route: route}
+	if r != nil {
+		req.Method = r.Method
+		req.Path = r.URL.Path
+		req.UserAgent = r.UserAgent()
+		req.Header = r.Header.Get
+	}
+	return req
+}
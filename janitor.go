@@ -0,0 +1,123 @@
+package gincage
+
+import (
+	"context"
+	"time"
+)
+
+// Default time between sweeps of janitor
+var DefaultJanitorInterval = time.Duration(time.Minute)
+
+// Sweeper can be implemented by Bucket and local caches keeping expired
+// keys until they are used again, so janitor removes them (see WithJanitor)
+type Sweeper interface {
+	// Removes expired keys, returns count of removed ones
+	Sweep() int
+}
+
+// Starts janitor removing expired keys of in-process state every interval:
+// of bucket and buckets wrapped by it, local fallback and cached limits
+// (see CacheLimits). Otherwise expired keys are removed only when they
+// are used again or evicted by max keys, so memory grows with count of
+// clients ever seen. Janitor is stopped by Close.
+// If interval <= 0, uses DefaultJanitorInterval
+func WithJanitor(interval time.Duration) Option {
+	return func(l *Limiter) {
+		if interval <= 0 {
+			interval = DefaultJanitorInterval
+		}
+		l.janitorInterval = interval
+	}
+}
+
+// Returns local state of limiter implementing Sweeper
+func (l *Limiter) sweepers() []Sweeper {
+	var sweepers []Sweeper
+	for b := l.bucket; b != nil; {
+		if s, ok := b.(Sweeper); ok {
+			sweepers = append(sweepers, s)
+		}
+		w, ok := b.(BucketWrapper)
+		if !ok {
+			break
+		}
+		b = w.Unwrap()
+	}
+	if l.fallback != nil {
+		sweepers = append(sweepers, l.fallback)
+	}
+	if s, ok := BucketAs[Sweeper](l.secondary); ok {
+		sweepers = append(sweepers, s)
+	}
+	if s, ok := l.limits.(Sweeper); ok {
+		sweepers = append(sweepers, s)
+	}
+	return sweepers
+}
+
+func (l *Limiter) startJanitor() {
+	if l.janitorInterval <= 0 {
+		return
+	}
+	sweepers := l.sweepers()
+	if len(sweepers) == 0 {
+		l.logger.Warn("limiter has no local state to sweep, janitor isn't started")
+		return
+	}
+	l.background(l.closeCtx, func(ctx context.Context) {
+		t := time.NewTicker(l.janitorInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			var n int
+			for _, s := range sweepers {
+				n += s.Sweep()
+			}
+			if n > 0 {
+				l.logger.Debug("expired keys removed", F("keys", n))
+			}
+		}
+	})
+}
+
+// Removes expired keys and passed pacing slots
+func (b *MemoryBucket) Sweep() int {
+	now := b.clock.Now()
+	var n int
+	for i := range b.shards {
+		s := &b.shards[i]
+		s.mu.Lock()
+		for k, e := range s.entries {
+			if !now.Before(e.expiresAt) {
+				delete(s.entries, k)
+				n++
+			}
+		}
+		for k, t := range s.slots {
+			if t.Before(now) {
+				delete(s.slots, k)
+			}
+		}
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// Removes expired limits
+func (c *limitsCache) Sweep() int {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var n int
+	for k, e := range c.entries {
+		if !now.Before(e.expiresAt) {
+			delete(c.entries, k)
+			n++
+		}
+	}
+	return n
+}
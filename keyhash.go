@@ -0,0 +1,64 @@
+package gincage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"strings"
+	"sync"
+)
+
+// Hashes client keys (ips, user ids) with HMAC-SHA256 of secret before
+// they reach storage, bans and hooks, so limiter state holds no personal
+// data in plaintext. Keys can't be recovered without secret, changing
+// secret starts all keys from scratch.
+//
+// LimitProvider, PlanFunc and allowlist get raw keys. Route param keys
+// (see RouteRule.KeyParams) aren't hashed.
+// If secret is empty, option is ignored
+func WithKeyHashing(secret []byte) Option {
+	return func(l *Limiter) {
+		if len(secret) == 0 {
+			return
+		}
+		secret = append([]byte(nil), secret...)
+		l.keyHash = &sync.Pool{New: func() any {
+			return hmac.New(sha256.New, secret)
+		}}
+	}
+}
+
+// Returns hash of client key (first 128 bits of HMAC as hex),
+// key itself if hashing isn't enabled
+func (l *Limiter) hashKey(key string) string {
+	if l.keyHash == nil {
+		return key
+	}
+	h := l.keyHash.Get().(hash.Hash)
+	defer l.keyHash.Put(h)
+	h.Reset()
+	h.Write([]byte(key))
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// Returns key as it's stored by bucket: client part of key (e.g. ip of
+// "/login|10.0.0.1") is hashed if WithKeyHashing is used.
+// Used to inspect and reset keys of clients
+func (l *Limiter) StorageKey(key string) string {
+	if l.keyHash == nil {
+		return key
+	}
+	if scope, client, ok := cutLast(key, "|"); ok {
+		return scope + "|" + l.hashKey(client)
+	}
+	return l.hashKey(key)
+}
+
+// Slices s around last sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
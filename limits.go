@@ -0,0 +1,118 @@
+package gincage
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default max count of keys cached by CacheLimits
+var DefaultLimitsCacheMaxKeys = 100000
+
+// LimitProvider: source of per-key limits (tenant, user, api key plans)
+// stored in DB, cache or config service.
+//
+// Zero fields of returned rate are taken from Config.Rate and then from
+// bucket. Route rules override provider limits on their routes
+type LimitProvider interface {
+	Limits(ctx context.Context, key string) (Rate, error)
+}
+
+// LimitProviderFunc: function implementing LimitProvider
+type LimitProviderFunc func(ctx context.Context, key string) (Rate, error)
+
+func (f LimitProviderFunc) Limits(ctx context.Context, key string) (Rate, error) {
+	return f(ctx, key)
+}
+
+// Sets provider of per-key limits. Provider is called on every request,
+// wrap slow providers with CacheLimits. If provider fails, error is logged
+// and limits of config are used
+func WithLimitProvider(p LimitProvider) Option {
+	return func(l *Limiter) {
+		l.limits = p
+	}
+}
+
+// Returns limit of key from provider or zero rate if it failed
+func (l *Limiter) keyLimits(ctx context.Context, key string) Rate {
+	if l.limits == nil {
+		return Rate{}
+	}
+	rate, err := l.limits.Limits(ctx, key)
+	if err != nil {
+		l.log(RequestIDFromContext(ctx)).Error("failed to get limits of key, default limits are used", F("error", err), F("key", key))
+		return Rate{}
+	}
+	return rate
+}
+
+type cachedLimit struct {
+	rate      Rate
+	expiresAt time.Time
+}
+
+type limitsCache struct {
+	provider LimitProvider
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cachedLimit
+	pruneAt int
+
+	maxKeys   int
+	evictions atomic.Uint64
+}
+
+// Wraps p, so limits of key are requested once per ttl.
+// Errors aren't cached. At most DefaultLimitsCacheMaxKeys keys are cached,
+// approximately least recently cached keys are evicted
+// (returned provider implements EvictionCounter)
+func CacheLimits(p LimitProvider, ttl time.Duration) LimitProvider {
+	return &limitsCache{
+		provider: p,
+		ttl:      ttl,
+		entries:  make(map[string]cachedLimit),
+		maxKeys:  DefaultLimitsCacheMaxKeys,
+	}
+}
+
+func (c *limitsCache) Limits(ctx context.Context, key string) (Rate, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(e.expiresAt) {
+		return e.rate, nil
+	}
+
+	rate, err := c.provider.Limits(ctx, key)
+	if err != nil {
+		return Rate{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && c.maxKeys > 0 && len(c.entries) >= c.maxKeys {
+		victim, _ := evictionVictim(c.entries, now, func(e cachedLimit) time.Time { return e.expiresAt }, nil)
+		delete(c.entries, victim)
+		c.evictions.Add(1)
+	}
+	c.entries[key] = cachedLimit{rate: rate, expiresAt: now.Add(c.ttl)}
+	if len(c.entries) > c.pruneAt {
+		for k, e := range c.entries {
+			if !now.Before(e.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		c.pruneAt = max(2*len(c.entries), 1024)
+	}
+	return rate, nil
+}
+
+// Returns count of keys evicted because cache was full
+func (c *limitsCache) Evictions() uint64 {
+	return c.evictions.Load()
+}
@@ -0,0 +1,147 @@
+package gincage
+
+import (
+	"runtime/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// Default share of limits kept while process is overloaded
+	DefaultLoadFactor = 0.5
+	// Default time between samples of process load
+	DefaultLoadInterval = time.Duration(time.Second)
+)
+
+// LoadPolicy: thresholds of process load tightening limits of all keys.
+//
+// Limits keep clients fair, load shedding protects service itself:
+// while any threshold is crossed, every key gets smaller share of its limit.
+// Zero thresholds aren't checked
+type LoadPolicy struct {
+	// Share of CPU time of GOMAXPROCS used by process (0..1), e.g. 0.8
+	MaxCPU float64
+	// Bytes of live heap objects
+	MaxHeap uint64
+	// Count of goroutines
+	MaxGoroutines int
+	// Share of limits kept while overloaded (0..1): capacity is multiplied
+	// and refill interval is divided by it.
+	// If <= 0 or >= 1, uses DefaultLoadFactor
+	Factor float64
+	// Time between load samples. If <= 0, uses DefaultLoadInterval
+	Interval time.Duration
+}
+
+// Enables load shedding: limits are tightened while process is overloaded,
+// see LoadPolicy. Load is sampled with runtime/metrics during requests,
+// so no goroutines are started
+func WithLoadShedding(p LoadPolicy) Option {
+	return func(l *Limiter) {
+		if p.Factor <= 0 || p.Factor >= 1 {
+			p.Factor = DefaultLoadFactor
+		}
+		if p.Interval <= 0 {
+			p.Interval = DefaultLoadInterval
+		}
+		l.load = &loadShedder{
+			policy: p,
+			samples: []metrics.Sample{
+				{Name: "/cpu/classes/total:cpu-seconds"},
+				{Name: "/cpu/classes/idle:cpu-seconds"},
+				{Name: "/memory/classes/heap/objects:bytes"},
+				{Name: "/sched/goroutines:goroutines"},
+			},
+		}
+	}
+}
+
+type loadShedder struct {
+	policy LoadPolicy
+
+	mu      sync.Mutex
+	samples []metrics.Sample
+	next    time.Time
+	// cpu seconds of previous sample
+	cpuTotal float64
+	cpuIdle  float64
+
+	overloaded atomic.Bool
+}
+
+// Samples load if interval passed. Returns threshold which is crossed
+// (empty if none) and true if overload state was changed
+func (s *loadShedder) sample(now time.Time) (string, bool) {
+	if !s.mu.TryLock() {
+		return "", false
+	}
+	defer s.mu.Unlock()
+	if now.Before(s.next) {
+		return "", false
+	}
+	s.next = now.Add(s.policy.Interval)
+
+	metrics.Read(s.samples)
+	total, idle := sampleFloat(s.samples[0]), sampleFloat(s.samples[1])
+	cpu := 0.0
+	if d := total - s.cpuTotal; s.cpuTotal > 0 && d > 0 {
+		cpu = 1 - (idle-s.cpuIdle)/d
+	}
+	s.cpuTotal, s.cpuIdle = total, idle
+
+	var reason string
+	switch p := s.policy; {
+	case p.MaxCPU > 0 && cpu >= p.MaxCPU:
+		reason = "cpu"
+	case p.MaxHeap > 0 && sampleUint(s.samples[2]) >= p.MaxHeap:
+		reason = "heap"
+	case p.MaxGoroutines > 0 && sampleUint(s.samples[3]) >= uint64(p.MaxGoroutines):
+		reason = "goroutines"
+	}
+	return reason, s.overloaded.Swap(reason != "") != (reason != "")
+}
+
+// Returns rate tightened by load factor if process is overloaded
+func (l *Limiter) shed(rate Rate) Rate {
+	if l.load == nil {
+		return rate
+	}
+	if reason, changed := l.load.sample(time.Now()); changed {
+		if reason != "" {
+			l.logger.Warn("process overloaded, limits tightened", F("reason", reason))
+		} else {
+			l.logger.Info("process load recovered, limits restored")
+		}
+	}
+	if !l.load.overloaded.Load() {
+		return rate
+	}
+
+	if r, ok := BucketAs[RateReporter](l.bucket); ok {
+		rate = rate.withDefaults(r.Rate())
+	}
+	factor := l.load.policy.Factor
+	rate.Capacity = max(int(float64(rate.Capacity)*factor), 1)
+	rate.Refill = time.Duration(float64(rate.Refill) / factor)
+	return rate
+}
+
+// Returns true if limits are tightened because of process load
+func (s *loadShedder) isOverloaded() bool {
+	return s != nil && s.overloaded.Load()
+}
+
+func sampleFloat(s metrics.Sample) float64 {
+	if s.Value.Kind() != metrics.KindFloat64 {
+		return 0
+	}
+	return s.Value.Float64()
+}
+
+func sampleUint(s metrics.Sample) uint64 {
+	if s.Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return s.Value.Uint64()
+}
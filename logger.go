@@ -0,0 +1,125 @@
+package gincage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Field: structured log field
+type Field struct {
+	Key   string
+	Value any
+}
+
+// Creates log field
+func F(key string, value any) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Logger: leveled structured logger used by limiter.
+//
+// Adapters for zap and zerolog live in gincagezap and gincagezerolog
+type Logger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+}
+
+// NopLogger: Logger implementation which does nothing
+type NopLogger struct{}
+
+func (NopLogger) Debug(msg string, fields ...Field) {}
+func (NopLogger) Info(msg string, fields ...Field)  {}
+func (NopLogger) Warn(msg string, fields ...Field)  {}
+func (NopLogger) Error(msg string, fields ...Field) {}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// Adapts slog logger to Logger. If l is nil, slog.Default() is used
+func NewSlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return slogLogger{l: l}
+}
+
+func (s slogLogger) Debug(msg string, fields ...Field) {
+	s.log(slog.LevelDebug, msg, fields)
+}
+
+func (s slogLogger) Info(msg string, fields ...Field) {
+	s.log(slog.LevelInfo, msg, fields)
+}
+
+func (s slogLogger) Warn(msg string, fields ...Field) {
+	s.log(slog.LevelWarn, msg, fields)
+}
+
+func (s slogLogger) Error(msg string, fields ...Field) {
+	s.log(slog.LevelError, msg, fields)
+}
+
+func (s slogLogger) log(level slog.Level, msg string, fields []Field) {
+	ctx := context.Background()
+	if !s.l.Enabled(ctx, level) {
+		return
+	}
+	attrs := make([]slog.Attr, len(fields))
+	for i, f := range fields {
+		attrs[i] = slog.Any(f.Key, f.Value)
+	}
+	s.l.LogAttrs(ctx, level, msg, attrs...)
+}
+
+type writerLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Adapts io.Writer to Logger. Writes one line per record:
+//
+//	2006-01-02T15:04:05Z ERROR storage error key=127.0.0.1 backend=redis
+func NewWriterLogger(w io.Writer) Logger {
+	return &writerLogger{w: w}
+}
+
+func (l *writerLogger) Debug(msg string, fields ...Field) {
+	l.write("DEBUG", msg, fields)
+}
+
+func (l *writerLogger) Info(msg string, fields ...Field) {
+	l.write("INFO", msg, fields)
+}
+
+func (l *writerLogger) Warn(msg string, fields ...Field) {
+	l.write("WARN", msg, fields)
+}
+
+func (l *writerLogger) Error(msg string, fields ...Field) {
+	l.write("ERROR", msg, fields)
+}
+
+func (l *writerLogger) write(level, msg string, fields []Field) {
+	var b strings.Builder
+	b.WriteString(time.Now().UTC().Format(time.RFC3339))
+	b.WriteByte(' ')
+	b.WriteString(level)
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for _, f := range fields {
+		fmt.Fprintf(&b, " %s=%v", f.Key, f.Value)
+	}
+	b.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write([]byte(b.String()))
+}
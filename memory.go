@@ -0,0 +1,448 @@
+package gincage
+
+import (
+	"context"
+	"hash/maphash"
+	"math/bits"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type memoryEntry struct {
+	tokens     int
+	refilledAt time.Time
+	expiresAt  time.Time
+}
+
+// MemoryBucket: in-process tokens bucket.
+//
+// Unlike other buckets it uses sync primitives, because its state
+// lives in one process. It is suitable for single instance services,
+// tests and as local fallback when distributed storage is unreachable.
+//
+// Keys are spread over lock-striped shards, so requests of different
+// keys don't wait for one mutex on large machines
+type MemoryBucket struct {
+	shards []memoryShard
+	seed   maphash.Seed
+
+	rate      Rate
+	ttlJitter time.Duration
+	clock     Clock
+
+	// max keys of shard, zero if unbounded
+	shardMaxKeys int
+	evictions    atomic.Uint64
+
+	flags atomic.Pointer[Flags]
+}
+
+type memoryShard struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+
+	// next free slots of keys in pacing mode
+	slots map[string]time.Time
+	// count of slots causing removal of passed ones
+	pruneAt int
+}
+
+// Implements Bucket interface and keeps tokens in process memory.
+//
+// Only limits of cfg, Clock, Shards and MaxKeys are used
+func NewMemoryBucket(cfg BucketConfigs) Bucket {
+	return newMemoryBucket(cfg)
+}
+
+func newMemoryBucket(cfg BucketConfigs) *MemoryBucket {
+	if cfg.Capability <= 0 {
+		cfg.Capability = DefaultTokensCap
+	}
+
+	if cfg.TokensExist <= 0 {
+		cfg.TokensExist = DefaultTokensExist
+	}
+
+	if cfg.TokensAppendDuration <= 0 {
+		cfg.TokensAppendDuration = DefaultTokensAppendDuration
+	}
+
+	if cfg.Shards <= 0 {
+		cfg.Shards = 4 * runtime.GOMAXPROCS(0)
+	}
+
+	// power of two, so shard is found with mask
+	shards := 1 << bits.Len(uint(cfg.Shards-1))
+	if cfg.MaxKeys > 0 && shards > cfg.MaxKeys {
+		// every shard keeps at least one key, fewer shards keep MaxKeys bound
+		shards = 1 << (bits.Len(uint(cfg.MaxKeys)) - 1)
+	}
+
+	b := &MemoryBucket{
+		shards: make([]memoryShard, shards),
+		seed:   maphash.MakeSeed(),
+		rate: Rate{
+			Capacity: cfg.Capability,
+			Refill:   cfg.TokensAppendDuration,
+			TTL:      cfg.TokensExist,
+		},
+		ttlJitter: cfg.TTLJitter,
+		clock:     clockOrDefault(cfg.Clock),
+	}
+	if cfg.MaxKeys > 0 {
+		b.shardMaxKeys = max(cfg.MaxKeys/len(b.shards), 1)
+	}
+	for i := range b.shards {
+		b.shards[i].entries = make(map[string]*memoryEntry)
+		b.shards[i].slots = make(map[string]time.Time)
+	}
+	return b
+}
+
+func (b *MemoryBucket) shardIndex(key string) int {
+	return int(maphash.String(b.seed, key) & uint64(len(b.shards)-1))
+}
+
+func (b *MemoryBucket) shard(key string) *memoryShard {
+	return &b.shards[b.shardIndex(key)]
+}
+
+// Takes n tokens of key if awailable
+func (b *MemoryBucket) Take(ctx context.Context, key string, n int) (Result, error) {
+	if stats := WalkStatsFromContext(ctx); stats != nil {
+		stats.Backend = "memory"
+	}
+	opts, _ := WalkOptionsFromContext(ctx)
+	rate := opts.Rate.withDefaults(b.rate)
+	return b.take(key, rate, takeCount(n), opts.reserved(rate)), nil
+}
+
+// Takes n tokens of key if reserve is left after that
+func (b *MemoryBucket) take(key string, rate Rate, n, reserve int) Result {
+	now := b.clock.Now()
+	s := b.shard(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens, t := s.load(key, rate, now)
+	if tokens < n+reserve {
+		return denied(rate, tokens, t, now)
+	}
+	b.put(s, key, &memoryEntry{
+		tokens:     tokens - n,
+		refilledAt: t,
+		expiresAt:  now.Add(withJitter(rate.TTL, b.ttlJitter)),
+	}, now)
+	return allowed(rate, tokens-n)
+}
+
+// Takes tokens of all keys at once. If any key has not enough tokens,
+// nothing is taken and *LimitExceededError of that key is returned
+func (b *MemoryBucket) WalkMany(ctx context.Context, keys []KeyedCost) error {
+	if stats := WalkStatsFromContext(ctx); stats != nil {
+		stats.Backend = "memory"
+	}
+	keys = mergeKeyedCosts(keys)
+	now := b.clock.Now()
+
+	// shards are locked in order, so concurrent calls don't deadlock
+	idx := make([]int, 0, len(keys))
+	for _, k := range keys {
+		idx = append(idx, b.shardIndex(k.Key))
+	}
+	locked := slices.Compact(slices.Sorted(slices.Values(idx)))
+	for _, i := range locked {
+		b.shards[i].mu.Lock()
+	}
+	defer func() {
+		for _, i := range locked {
+			b.shards[i].mu.Unlock()
+		}
+	}()
+
+	entries := make([]memoryEntry, len(keys))
+	for i, k := range keys {
+		rate := k.Rate.withDefaults(b.rate)
+		tokens, t := b.shards[idx[i]].load(k.Key, rate, now)
+		if tokens < k.Cost {
+			return limitExceeded(k.Key, rate, tokens, t)
+		}
+		entries[i] = memoryEntry{
+			tokens:     tokens - k.Cost,
+			refilledAt: t,
+			expiresAt:  now.Add(withJitter(rate.TTL, b.ttlJitter)),
+		}
+	}
+	for i, k := range keys {
+		b.put(&b.shards[idx[i]], k.Key, &entries[i], now)
+	}
+	return nil
+}
+
+// Takes tokens of updates, tokens of key don't go below zero,
+// returned tokens don't go above capacity
+func (b *MemoryBucket) Sync(ctx context.Context, updates []SyncUpdate) ([]KeyState, error) {
+	now := b.clock.Now()
+
+	result := make([]KeyState, len(updates))
+	for i, u := range updates {
+		rate := u.Rate.withDefaults(b.rate)
+		s := b.shard(u.Object)
+
+		s.mu.Lock()
+		tokens, t := s.load(u.Object, rate, now)
+		tokens = syncTokens(tokens, u.Tokens, rate)
+		b.put(s, u.Object, &memoryEntry{
+			tokens:     tokens,
+			refilledAt: t,
+			expiresAt:  now.Add(withJitter(rate.TTL, b.ttlJitter)),
+		}, now)
+		s.mu.Unlock()
+
+		result[i] = KeyState{Key: u.Object, Tokens: tokens, RefilledAt: t, Exists: true}
+	}
+	return result, nil
+}
+
+// Adds n tokens to key, even above capacity
+func (b *MemoryBucket) Credit(ctx context.Context, key string, n int) (KeyState, error) {
+	if n <= 0 {
+		return KeyState{}, errCreditNotPositive
+	}
+	opts, _ := WalkOptionsFromContext(ctx)
+	rate := opts.Rate.withDefaults(b.rate)
+	now := b.clock.Now()
+	s := b.shard(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens, t := s.load(key, rate, now)
+	tokens, t = credit(tokens, t, n, rate, now)
+	e := &memoryEntry{
+		tokens:     tokens,
+		refilledAt: t,
+		expiresAt:  now.Add(withJitter(rate.TTL, b.ttlJitter)),
+	}
+	b.put(s, key, e, now)
+	return KeyState{Key: key, Tokens: tokens, RefilledAt: t, Exists: true, ExpiresAt: e.expiresAt}, nil
+}
+
+// Books n tokens key has by time at, tokens of key may go below zero
+func (b *MemoryBucket) Book(ctx context.Context, key string, n int, at time.Time) (time.Time, error) {
+	if n <= 0 {
+		return time.Time{}, errBookNotPositive
+	}
+	if stats := WalkStatsFromContext(ctx); stats != nil {
+		stats.Backend = "memory"
+	}
+	opts, _ := WalkOptionsFromContext(ctx)
+	rate := opts.Rate.withDefaults(b.rate)
+	now := b.clock.Now()
+	s := b.shard(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens, t := s.load(key, rate, now)
+	tokens, ready, err := book(key, tokens, t, n, rate, now, at)
+	if err != nil {
+		return time.Time{}, err
+	}
+	b.put(s, key, &memoryEntry{
+		tokens:     tokens,
+		refilledAt: t,
+		// booked tokens are kept till they may be used
+		expiresAt: ready.Add(withJitter(rate.TTL, b.ttlJitter)),
+	}, now)
+	return ready, nil
+}
+
+// Stores entry of key in shard s, evicting other key if shard is full.
+// Should be called with lock of s held
+func (b *MemoryBucket) put(s *memoryShard, key string, e *memoryEntry, now time.Time) {
+	if _, ok := s.entries[key]; !ok && b.shardMaxKeys > 0 && len(s.entries) >= b.shardMaxKeys {
+		victim, _ := evictionVictim(s.entries, now, func(e *memoryEntry) time.Time { return e.expiresAt }, nil)
+		delete(s.entries, victim)
+		b.evictions.Add(1)
+	}
+	s.entries[key] = e
+}
+
+// Returns count of keys evicted because of BucketConfigs.MaxKeys
+func (b *MemoryBucket) Evictions() uint64 {
+	return b.evictions.Load()
+}
+
+// Returns tokens of key with refill applied. Should be called with lock held
+func (s *memoryShard) load(key string, rate Rate, now time.Time) (int, time.Time) {
+	e, ok := s.entries[key]
+	if !ok || !now.Before(e.expiresAt) {
+		return rate.Capacity, now
+	}
+	return refill(e.tokens, e.refilledAt, rate, now)
+}
+
+// Reserves slot of key spaced by interval from slots reserved before
+func (b *MemoryBucket) Reserve(ctx context.Context, key string, interval, maxDelay time.Duration) (time.Duration, error) {
+	if stats := WalkStatsFromContext(ctx); stats != nil {
+		stats.Backend = "memory"
+	}
+	now := b.clock.Now()
+	s := b.shard(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next, ok := s.slots[key]
+	if !ok || next.Before(now) {
+		next = now
+	}
+	delay := next.Sub(now)
+	if delay > maxDelay {
+		return 0, &LimitExceededError{Key: key, Limit: 1, Reset: next.Add(-maxDelay)}
+	}
+	s.slots[key] = next.Add(interval)
+
+	if len(s.slots) > s.pruneAt {
+		for k, t := range s.slots {
+			if t.Before(now) {
+				delete(s.slots, k)
+			}
+		}
+		s.pruneAt = max(2*len(s.slots), 1024)
+	}
+	return delay, nil
+}
+
+// Returns tokens of key without taking them
+func (b *MemoryBucket) Peek(ctx context.Context, key string) (KeyState, error) {
+	now := b.clock.Now()
+	s := b.shard(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	exists := ok && now.Before(e.expiresAt)
+	tokens, t := s.load(key, b.rate, now)
+	state := KeyState{
+		Key:        key,
+		Tokens:     tokens,
+		RefilledAt: t,
+		Exists:     exists,
+	}
+	if exists {
+		state.ExpiresAt = e.expiresAt
+	}
+	return state, nil
+}
+
+// Restores full capacity of key
+func (b *MemoryBucket) Reset(ctx context.Context, key string) error {
+	s := b.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+// Counts keys which aren't expired
+func (b *MemoryBucket) ActiveKeys(ctx context.Context) (int, error) {
+	now := b.clock.Now()
+
+	var n int
+	for i := range b.shards {
+		s := &b.shards[i]
+		s.mu.Lock()
+		for _, e := range s.entries {
+			if now.Before(e.expiresAt) {
+				n++
+			}
+		}
+		s.mu.Unlock()
+	}
+	return n, nil
+}
+
+// Calls fn for every key which isn't expired.
+// Shards are copied one by one, so fn can use bucket
+func (b *MemoryBucket) Scan(ctx context.Context, fn func(e SnapshotEntry) error) error {
+	now := b.clock.Now()
+
+	var entries []SnapshotEntry
+	for i := range b.shards {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s := &b.shards[i]
+		entries = entries[:0]
+		s.mu.Lock()
+		for key, e := range s.entries {
+			if !now.Before(e.expiresAt) {
+				continue
+			}
+			entries = append(entries, SnapshotEntry{
+				Key:        key,
+				Tokens:     e.tokens,
+				RefilledAt: e.refilledAt,
+				ExpiresAt:  e.expiresAt,
+			})
+		}
+		s.mu.Unlock()
+
+		for _, e := range entries {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Replaces state of keys
+func (b *MemoryBucket) Restore(ctx context.Context, entries []SnapshotEntry) error {
+	now := b.clock.Now()
+	for _, e := range entries {
+		expiresAt := e.ExpiresAt
+		if expiresAt.IsZero() {
+			expiresAt = now.Add(withJitter(b.rate.TTL, b.ttlJitter))
+		}
+		if !now.Before(expiresAt) {
+			continue
+		}
+		s := b.shard(e.Key)
+		s.mu.Lock()
+		b.put(s, e.Key, &memoryEntry{
+			tokens:     e.Tokens,
+			refilledAt: e.RefilledAt,
+			expiresAt:  expiresAt,
+		}, now)
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// Returns limits of bucket
+func (b *MemoryBucket) Rate() Rate {
+	return b.rate
+}
+
+// Returns time after new tokens append
+func (b *MemoryBucket) RefillInterval() time.Duration {
+	return b.rate.Refill
+}
+
+// Drops all keys
+func (b *MemoryBucket) Close() error {
+	for i := range b.shards {
+		s := &b.shards[i]
+		s.mu.Lock()
+		s.entries = make(map[string]*memoryEntry)
+		s.slots = make(map[string]time.Time)
+		s.mu.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package gincage
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// Metrics: sink for limiter outcomes.
+//
+// Implementations have to be safe for concurrent use.
+// Embed NopMetrics to implement only needed methods
+type Metrics interface {
+	// Request walked through the limiter
+	Allowed()
+	// Request was rejected because of rate limit
+	Rejected()
+	// Bucket returned error
+	Errored()
+	// Time spent in bucket for one request
+	StorageLatency(d time.Duration)
+	// Request was allowed because of storage error (FailOpen)
+	FailedOpen()
+	// Request was limited by local fallback because of storage error (FailLocal)
+	LocalFallback()
+}
+
+// StorageCall: storage call made for one request
+type StorageCall struct {
+	// Backend reported by bucket (redis, memory, ...)
+	Backend string
+	// Time spent in storage
+	Latency time.Duration
+	// Retries on concurrent updates of key (optimistic lock conflicts)
+	Retries int
+	// Call timed out (WithStorageTimeout, request deadline or client timeouts)
+	Timeout bool
+	// Call failed, rejection isn't failure
+	Failed bool
+}
+
+// StorageMetrics can be implemented by Metrics to observe
+// storage calls per backend, so contention and timeouts can be seen
+type StorageMetrics interface {
+	StorageCalled(c StorageCall)
+}
+
+// Reports storage call of request to metrics and stats
+func (l *Limiter) storageCalled(stats *WalkStats, latency time.Duration, err error) {
+	c := StorageCall{
+		Backend: stats.Backend,
+		Latency: latency,
+		Retries: stats.Retries,
+		Timeout: isTimeout(err),
+		Failed:  err != nil && !errors.Is(err, ErrNoTokensAwailable),
+	}
+	if c.Backend == "" {
+		c.Backend = "unknown"
+	}
+	l.metrics.StorageLatency(latency)
+	l.stats.backendCall(c, err)
+	if m, ok := l.metrics.(StorageMetrics); ok {
+		m.StorageCalled(c)
+	}
+}
+
+// Returns true if err is timeout of context or network
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// KeyCounter can be implemented by Bucket to report
+// count of keys currently stored in it
+type KeyCounter interface {
+	ActiveKeys(ctx context.Context) (int, error)
+}
+
+// NopMetrics: Metrics implementation which does nothing
+type NopMetrics struct{}
+
+func (NopMetrics) Allowed()                       {}
+func (NopMetrics) Rejected()                      {}
+func (NopMetrics) Errored()                       {}
+func (NopMetrics) StorageLatency(d time.Duration) {}
+func (NopMetrics) FailedOpen()                    {}
+func (NopMetrics) LocalFallback()                 {}
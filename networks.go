@@ -0,0 +1,86 @@
+package gincage
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// NetworkClass: clients of networks (e.g. internal services) limited
+// by own rate instead of limits of internet clients, see Config.Networks
+type NetworkClass struct {
+	// Class name reported in stats, scopes keys of class.
+	// If empty, "networks[i]" is used
+	Name string `json:"name,omitempty"`
+	// Client ips and CIDRs of class
+	CIDRs []string `json:"cidrs,omitempty"`
+	// Matches private (RFC 1918, RFC 4193), loopback
+	// and link-local addresses
+	Private bool `json:"private,omitempty"`
+	// Limit of all requests of class clients, route rules don't apply
+	// to them. Zero fields are taken from key limits, plan and Config.Rate
+	Rate Rate `json:"rate"`
+
+	// parsed CIDRs
+	cidrs []netip.Prefix
+}
+
+// Returns first network class of client ip
+func (c *Config) matchNetwork(ip string) (NetworkClass, bool) {
+	if len(c.Networks) == 0 || ip == "" {
+		return NetworkClass{}, false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return NetworkClass{}, false
+	}
+	addr = addr.Unmap()
+	for _, n := range c.Networks {
+		if n.match(addr) {
+			return n, true
+		}
+	}
+	return NetworkClass{}, false
+}
+
+func (n NetworkClass) match(addr netip.Addr) bool {
+	if n.Private && (addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast()) {
+		return true
+	}
+	for _, p := range n.cidrs {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses CIDRs of n
+func (n *NetworkClass) compile() error {
+	n.cidrs = nil
+	for i, c := range n.CIDRs {
+		p, err := parsePrefix(c)
+		if err != nil {
+			return fmt.Errorf("cidrs[%d]: %w", i, err)
+		}
+		n.cidrs = append(n.cidrs, p)
+	}
+	return nil
+}
+
+func (n NetworkClass) validate() error {
+	if strings.HasPrefix(n.Name, "/") || strings.Contains(n.Name, "|") {
+		return errors.New("name: should not start with / or contain |")
+	}
+	if len(n.CIDRs) == 0 && !n.Private {
+		return errors.New("cidrs or private should be set")
+	}
+	if err := n.compile(); err != nil {
+		return err
+	}
+	if err := n.Rate.validate(); err != nil {
+		return fmt.Errorf("rate.%w", err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package gincage
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Option: optional limiter setting passed to NewLimiter
+type Option func(*Limiter)
+
+// KeyFunc: returns key identifying client of request
+type KeyFunc func(ctx *gin.Context) string
+
+// Keys clients by ip. Default KeyFunc
+func ClientIPKey(ctx *gin.Context) string {
+	return ctx.ClientIP()
+}
+
+// Sets logger. If logger is nil, logs are discarded.
+// Use NewWriterLogger to log into io.Writer
+func WithLogger(logger Logger) Option {
+	return func(l *Limiter) {
+		if logger == nil {
+			logger = NopLogger{}
+		}
+		l.logger = logger
+	}
+}
+
+// Sets JSON bodies returned when bucket failed and when rate was limited.
+// Nil body keeps default one
+func WithErrorBody(serverError, tooManyRequestsError any) Option {
+	return func(l *Limiter) {
+		if serverError != nil {
+			l.serverError = serverError
+		}
+		if tooManyRequestsError != nil {
+			l.tooManyRequestsError = tooManyRequestsError
+		}
+	}
+}
+
+// Sets function extracting client key from request (user id, api key, ...).
+// Default is ClientIPKey. If f is nil, option is ignored
+func WithKeyFunc(f KeyFunc) Option {
+	return func(l *Limiter) {
+		if f != nil {
+			l.keyFunc = f
+		}
+	}
+}
+
+// Sets HTTP status returned when rate was limited.
+// Default is 429 Too Many Requests. If code <= 0, option is ignored
+func WithTooManyRequestsStatus(code int) Option {
+	return func(l *Limiter) {
+		if code > 0 {
+			l.tooManyRequestsStatus = code
+		}
+	}
+}
+
+// Sets HTTP status returned when bucket failed.
+// Default is 500 Internal Server Error. If code <= 0, option is ignored
+func WithServerErrorStatus(code int) Option {
+	return func(l *Limiter) {
+		if code > 0 {
+			l.serverErrorStatus = code
+		}
+	}
+}
+
+// Responds with RFC 7807 application/problem+json bodies instead of
+// bodies set with WithErrorBody. typeURI is used as problem type,
+// if empty "about:blank" is used
+func WithProblemDetails(typeURI string) Option {
+	return func(l *Limiter) {
+		l.problem = &problemResponder{typeURI: typeURI}
+	}
+}
+
+// Sends RateLimit-Limit and RateLimit-Remaining headers with tokens
+// left for key in every response (allowed ones too), see Limiter.Header.
+// RateLimit-Policy header advertises quotas of request built from config,
+// so clients can slow down before they are limited
+func WithRateLimitHeaders() Option {
+	return func(l *Limiter) {
+		l.rateLimitHeaders = true
+	}
+}
+
+// Sends limiter outcomes to m. If m is nil, option is ignored
+func WithMetrics(m Metrics) Option {
+	return func(l *Limiter) {
+		if m != nil {
+			l.metrics = m
+		}
+	}
+}
+
+// Limits time of every storage call to d, so slow storage adds at most d
+// to request latency. Timed out calls are handled with fail policy.
+// If d <= 0, calls are limited only by request context.
+//
+// Redis client passed to NewRedisBucketWithClient should be created
+// with ContextTimeoutEnabled to respect deadline
+func WithStorageTimeout(d time.Duration) Option {
+	return func(l *Limiter) {
+		l.storageTimeout = d
+	}
+}
@@ -0,0 +1,102 @@
+package gincage
+
+import (
+	"context"
+	"time"
+)
+
+var (
+	// Default min time between requests of key in pacing mode
+	DefaultPacingInterval = time.Duration(100 * time.Millisecond)
+	// Default max time request of key waits for its slot in pacing mode
+	DefaultPacingMaxDelay = time.Duration(time.Second)
+)
+
+// Pacer can be implemented by Bucket to reserve slots of key spaced
+// by interval (leaky bucket). Returns delay before reserved slot.
+// If slot isn't awailable within maxDelay, nothing is reserved
+// and *LimitExceededError with time slot gets awailable is returned
+type Pacer interface {
+	Reserve(ctx context.Context, key string, interval, maxDelay time.Duration) (time.Duration, error)
+}
+
+// PacingConfigs: settings of pacing bucket
+type PacingConfigs struct {
+	// Min time between requests of key. Rate.Refill of route rule
+	// overrides it. If <= 0, uses DefaultPacingInterval
+	Interval time.Duration
+	// Max time request waits for its slot, later requests are rejected.
+	// If <= 0, uses DefaultPacingMaxDelay
+	MaxDelay time.Duration
+}
+
+type pacingBucket struct {
+	bucket Bucket
+	pacer  Pacer
+	cfg    PacingConfigs
+}
+
+// Wraps bucket, so requests of key are admitted at most once per interval.
+//
+// Unlike token bucket pacing has no bursts: excess requests are queued
+// until their slot comes (up to MaxDelay) and go out at smooth rate.
+// It fits fragile downstream systems. Delay is spent in Take, so request
+// deadline (and WithStorageTimeout) limits MaxDelay.
+//
+// Bucket should implement Pacer, otherwise it is returned as is
+func NewPacingBucket(b Bucket, cfg PacingConfigs) Bucket {
+	pacer, ok := BucketAs[Pacer](b)
+	if !ok {
+		return b
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultPacingInterval
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = DefaultPacingMaxDelay
+	}
+	return &pacingBucket{bucket: b, pacer: pacer, cfg: cfg}
+}
+
+// Returns wrapped bucket
+func (b *pacingBucket) Unwrap() Bucket {
+	return b.bucket
+}
+
+func (b *pacingBucket) Close() error {
+	return b.bucket.Close()
+}
+
+// Reserves n slots of key and waits for them.
+// If slots aren't awailable within max delay, result isn't allowed
+func (b *pacingBucket) Take(ctx context.Context, key string, n int) (Result, error) {
+	opts, _ := WalkOptionsFromContext(ctx)
+	interval := opts.Rate.Refill
+	if interval <= 0 {
+		interval = b.cfg.Interval
+	}
+	interval *= time.Duration(takeCount(n))
+	maxDelay := b.cfg.MaxDelay
+	if d, ok := ctx.Deadline(); ok {
+		maxDelay = min(maxDelay, time.Until(d))
+	}
+
+	delay, err := b.pacer.Reserve(ctx, key, interval, maxDelay)
+	if err != nil || delay <= 0 {
+		return resultOf(err)
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		// client is gone, its slot is wasted
+		return Result{Remaining: -1}, nil
+	case <-t.C:
+		return Result{Allowed: true, Remaining: -1}, nil
+	}
+}
+
+// Returns interval of pacing bucket, used for retry after hints
+func (b *pacingBucket) RefillInterval() time.Duration {
+	return b.cfg.Interval
+}
@@ -0,0 +1,52 @@
+package gincage
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PlanFunc: returns name of client plan (free, pro, ...), see Config.Plans
+type PlanFunc func(ctx *gin.Context) string
+
+// Sets function resolving plan of client, so limits of plan from
+// Config.Plans are applied to it. Other adapters pass plan with Request.Plan.
+// If f is nil, option is ignored
+func WithPlanFunc(f PlanFunc) Option {
+	return func(l *Limiter) {
+		if f != nil {
+			l.planFunc = f
+		}
+	}
+}
+
+// Returns name of client plan or empty string if plans aren't used
+func (l *Limiter) plan(ctx *gin.Context) string {
+	if l.planFunc == nil {
+		return ""
+	}
+	return l.planFunc(ctx)
+}
+
+// Returns limits of plan. Unknown plans get limits of default plan
+func (c *Config) planRate(plan string) Rate {
+	if rate, ok := c.Plans[plan]; ok {
+		return rate
+	}
+	return c.Plans[c.DefaultPlan]
+}
+
+func (c Config) validatePlans() error {
+	for name, rate := range c.Plans {
+		if err := rate.validate(); err != nil {
+			return fmt.Errorf("plans.%s.%w", name, err)
+		}
+	}
+	if c.DefaultPlan == "" {
+		return nil
+	}
+	if _, ok := c.Plans[c.DefaultPlan]; !ok {
+		return fmt.Errorf("default_plan: unknown plan %q", c.DefaultPlan)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package gincage
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PriorityFunc: returns priority class of request (interactive, background, ...),
+// see Config.Priorities
+type PriorityFunc func(ctx *gin.Context) string
+
+// Sets function resolving priority class of request, so reserve of class
+// from Config.Priorities is applied to it. Other adapters pass class with
+// Request.Priority. If f is nil, option is ignored
+func WithPriorityFunc(f PriorityFunc) Option {
+	return func(l *Limiter) {
+		if f != nil {
+			l.priorityFunc = f
+		}
+	}
+}
+
+// Takes priority class of request from header name in gin and net/http
+// middlewares, if PriorityFunc isn't set.
+//
+// Header should be set by trusted callers (gateway, internal services):
+// client sending class without reserve takes all tokens of its key
+func WithPriorityHeader(name string) Option {
+	return func(l *Limiter) {
+		l.priorityHeader = name
+	}
+}
+
+// Returns priority class of request or empty string if classes aren't used
+func (l *Limiter) priority(ctx *gin.Context) string {
+	if l.priorityFunc != nil {
+		return l.priorityFunc(ctx)
+	}
+	return l.headerPriority(ctx.Request)
+}
+
+// Returns priority class from header of r
+func (l *Limiter) headerPriority(r *http.Request) string {
+	if l.priorityHeader == "" || r == nil {
+		return ""
+	}
+	return r.Header.Get(l.priorityHeader)
+}
+
+// Returns true if tokens left after allowed res are within reserve of opts,
+// so bucket ignoring WalkOptions.Reserve should have rejected request
+func deprioritized(opts WalkOptions, res Result) bool {
+	if !res.Allowed || res.Remaining < 0 || res.Limit <= 0 {
+		return false
+	}
+	return res.Remaining < opts.reserved(Rate{Capacity: res.Limit})
+}
@@ -0,0 +1,64 @@
+package gincage
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Content type of RFC 7807 responses
+const ProblemContentType = "application/problem+json"
+
+// ProblemDetails: RFC 7807 response body.
+//
+// RetryAfter is an extension member with seconds to wait before retry
+type ProblemDetails struct {
+	Type       string `json:"type"`
+	Title      string `json:"title"`
+	Status     int    `json:"status"`
+	Detail     string `json:"detail,omitempty"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+// RefillIntervaler can be implemented by Bucket to tell the limiter
+// how often new tokens are appended. It is used to fill retry after hints
+type RefillIntervaler interface {
+	RefillInterval() time.Duration
+}
+
+type problemResponder struct {
+	typeURI string
+}
+
+func (p problemResponder) render(status int, detail string, retryAfter time.Duration) Response {
+	typeURI := p.typeURI
+	if typeURI == "" {
+		typeURI = "about:blank"
+	}
+	title := http.StatusText(status)
+	// custom statuses (e.g. 420) have no standard text
+	if title == "" {
+		title = http.StatusText(http.StatusTooManyRequests)
+	}
+	body := ProblemDetails{
+		Type:   typeURI,
+		Title:  title,
+		Status: status,
+		Detail: detail,
+	}
+	r := Response{Status: status, Header: make(http.Header)}
+	if retryAfter > 0 {
+		body.RetryAfter = int(math.Ceil(retryAfter.Seconds()))
+		r.Header.Set("Retry-After", strconv.Itoa(body.RetryAfter))
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return r
+	}
+	r.Header.Set("Content-Type", ProblemContentType)
+	r.Body = data
+	return r
+}
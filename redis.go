@@ -0,0 +1,1000 @@
+package gincage
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	keyPrefix           = "gincage:"
+	banKeyPrefix        = "gincage-ban:"
+	violationsKeyPrefix = "gincage-violations:"
+	paceKeyPrefix       = "gincage-pace:"
+	connsKeyPrefix      = "gincage-conns:"
+)
+
+var errNilCore = errors.New("redis core is nil")
+
+type RedisBucket struct {
+	core redis.UniversalClient
+	// serves inspection queries, nil if there is no replica
+	replica *redis.Client
+
+	cap             int
+	dur             time.Duration
+	tokenAppendTime time.Duration
+	maxRetries      int
+	codec           Codec
+	ttlJitter       time.Duration
+	clock           Clock
+	schema          int
+	// nil if functions aren't enabled
+	functions *redisFunctions
+}
+
+// Implements Bucket interface and allows to use redis as tokens bucket.
+//
+// Allows to use existing redis connection
+func NewRedisBucketWithClient(cfg BucketConfigs, c *redis.Client) Bucket {
+	if cfg.Capability <= 0 {
+		cfg.Capability = DefaultTokensCap
+	}
+
+	if cfg.TokensExist <= 0 {
+		cfg.TokensExist = DefaultTokensExist
+	}
+
+	if cfg.TokensAppendDuration <= 0 {
+		cfg.TokensAppendDuration = DefaultTokensAppendDuration
+	}
+
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = DefaultMaxRetries
+	}
+
+	return &RedisBucket{
+		core:            c,
+		cap:             cfg.Capability,
+		dur:             cfg.TokensExist,
+		tokenAppendTime: cfg.TokensAppendDuration,
+		maxRetries:      cfg.MaxRetries,
+		codec:           cfg.Codec,
+		ttlJitter:       cfg.TTLJitter,
+		clock:           clockOrDefault(cfg.Clock),
+		schema:          schemaOf(cfg),
+		functions:       newRedisFunctions(cfg),
+	}
+}
+
+// Implements Bucket interface with existing connections to primary and
+// read-only replica. Replica serves Peek, ActiveKeys, Scan and Bans.
+// If replica is nil, all queries go to primary
+func NewRedisBucketWithReplica(cfg BucketConfigs, primary, replica *redis.Client) Bucket {
+	b := NewRedisBucketWithClient(cfg, primary).(*RedisBucket)
+	b.replica = replica
+	return b
+}
+
+// Implements Bucket interface with existing Redis Cluster client.
+// Keys taken together (e.g. by WalkMany) should be in one hash slot.
+// Functions aren't used in cluster, take script runs with EVALSHA
+func NewRedisClusterBucket(cfg BucketConfigs, c *redis.ClusterClient) Bucket {
+	cfg.Functions = false
+	b := NewRedisBucketWithClient(cfg, nil).(*RedisBucket)
+	if c != nil {
+		b.core = c
+	}
+	return b
+}
+
+// Implements Bucket interface and allows to use redis as tokens bucket.
+//
+// Creates new redis client (and client of replica if it's set)
+// and returns error if it was broken
+func NewRedisBucket(cfg BucketConfigs) (Bucket, error) {
+	opts, err := redisOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	c := redis.NewClient(opts)
+	if err := c.Ping(context.Background()).Err(); err != nil {
+		return nil, err
+	}
+	if cfg.Replica == nil {
+		return NewRedisBucketWithClient(cfg, c), nil
+	}
+
+	ropts := *opts
+	ropts.Addr = net.JoinHostPort(cfg.Replica.Host, strconv.Itoa(cfg.Replica.Port))
+	if ropts.TLSConfig != nil && cfg.TLS.ServerName == "" {
+		ropts.TLSConfig = ropts.TLSConfig.Clone()
+		ropts.TLSConfig.ServerName = cfg.Replica.Host
+	}
+	replica := redis.NewClient(&ropts)
+	if err := replica.Ping(context.Background()).Err(); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("replica: %w", err)
+	}
+	return NewRedisBucketWithReplica(cfg, c, replica), nil
+}
+
+// Returns client of inspection queries: replica if it's set, primary otherwise
+func (b RedisBucket) reader() redis.UniversalClient {
+	if b.replica != nil {
+		return b.replica
+	}
+	return b.core
+}
+
+// Converts connection settings of cfg to redis client options
+func redisOptions(cfg BucketConfigs) (*redis.Options, error) {
+	if cfg.Network == "" {
+		cfg.Network = "tcp"
+	}
+	var tlsConfig *tls.Config
+	if cfg.TLS != nil {
+		var err error
+		if tlsConfig, err = cfg.TLS.config(cfg.Host); err != nil {
+			return nil, err
+		}
+	}
+	return &redis.Options{
+		Network:      cfg.Network,
+		Addr:         net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		Username:     cfg.Username,
+		Password:     cfg.Password,
+		DB:           cfg.DB,
+		PoolSize:     cfg.PoolSize,
+		MinIdleConns: cfg.MinIdleConns,
+		DialTimeout:  cfg.DialTimeout,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		TLSConfig:    tlsConfig,
+		// storage timeout of limiter is set with context deadline
+		ContextTimeoutEnabled: true,
+	}, nil
+}
+
+// Builds tls config, certificates are loaded from files
+func (c TLSConfigs) config(host string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls ca: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls ca: no certificates in %s", c.CAFile)
+		}
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// Returns time after new tokens append
+func (b RedisBucket) RefillInterval() time.Duration {
+	return b.tokenAppendTime
+}
+
+// Counts keys stored in redis by gincage.
+//
+// Uses SCAN, so result is approximate if keys are changed while counting
+func (b RedisBucket) ActiveKeys(ctx context.Context) (int, error) {
+	if b.core == nil {
+		return 0, errNilCore
+	}
+
+	var n int
+	err := scanKeys(ctx, b.reader(), keyPrefix+"*", 1000, func(string) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// Count of keys read in one pipeline while scanning
+const scanBatch = 500
+
+// Calls fn for every key stored in redis by gincage.
+//
+// Uses SCAN, so keys are read in batches without blocking redis
+func (b RedisBucket) Scan(ctx context.Context, fn func(e SnapshotEntry) error) error {
+	if b.core == nil {
+		return errNilCore
+	}
+
+	keys := make([]string, 0, scanBatch)
+	err := scanKeys(ctx, b.reader(), keyPrefix+"*", scanBatch, func(key string) error {
+		keys = append(keys, key)
+		if len(keys) < scanBatch {
+			return nil
+		}
+		err := b.scanBatch(ctx, keys, fn)
+		keys = keys[:0]
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return b.scanBatch(ctx, keys, fn)
+}
+
+// Calls fn for every key matching pattern, one call at a time.
+// Cluster is scanned on every master
+func scanKeys(ctx context.Context, c redis.UniversalClient, match string, count int64, fn func(key string) error) error {
+	var mu sync.Mutex
+	scan := func(ctx context.Context, c redis.UniversalClient) error {
+		iter := c.Scan(ctx, 0, match, count).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			err := fn(iter.Val())
+			mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+		return iter.Err()
+	}
+	if cc, ok := c.(*redis.ClusterClient); ok {
+		return cc.ForEachMaster(ctx, func(ctx context.Context, c *redis.Client) error {
+			return scan(ctx, c)
+		})
+	}
+	return scan(ctx, c)
+}
+
+func (b RedisBucket) scanBatch(ctx context.Context, keys []string, fn func(e SnapshotEntry) error) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	c := b.reader()
+	states, err := b.readMany(ctx, c, keys)
+	if err != nil {
+		return err
+	}
+	ttls := make([]*redis.DurationCmd, len(keys))
+	_, err = c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			ttls[i] = pipe.PTTL(ctx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	now := b.clock.Now()
+	for i, st := range states {
+		ttl := ttls[i].Val()
+		// expired while scanning
+		if !st.exists || ttl == -2 {
+			continue
+		}
+		e := SnapshotEntry{
+			Key:        strings.TrimPrefix(keys[i], keyPrefix),
+			Tokens:     st.Tokens,
+			RefilledAt: st.RefilledAt,
+		}
+		if ttl > 0 {
+			e.ExpiresAt = now.Add(ttl)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replaces state of keys in one pipeline
+func (b RedisBucket) Restore(ctx context.Context, entries []SnapshotEntry) error {
+	if b.core == nil {
+		return errNilCore
+	}
+
+	now := b.clock.Now()
+	_, err := b.core.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, e := range entries {
+			ttl := b.dur
+			if !e.ExpiresAt.IsZero() {
+				ttl = e.ExpiresAt.Sub(now)
+			}
+			if ttl <= 0 {
+				continue
+			}
+			// key can be stored in other layout
+			st := redisState{
+				StoredState: StoredState{Tokens: e.Tokens, RefilledAt: e.RefilledAt},
+				relayout:    true,
+			}
+			b.store(ctx, pipe, keyPrefix+e.Key, st, ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+// Counts violation of KEYS[1], first violation starts window of ARGV[1] ms.
+// Counter and its expiry are set at once, so counter never lives forever.
+// Replies count of violations in window
+const addViolationLua = `
+local n = redis.call('INCR', KEYS[1])
+if n == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return n
+`
+
+var addViolationScript = redis.NewScript(addViolationLua)
+
+// Registers violation of key and returns count of violations in current window
+func (b RedisBucket) AddViolation(ctx context.Context, key string, window time.Duration) (int, error) {
+	if b.core == nil {
+		return 0, errNilCore
+	}
+
+	ms := strconv.FormatInt(max(window.Milliseconds(), 1), 10)
+	return addViolationScript.Run(ctx, b.core, []string{violationsKeyPrefix + key}, ms).Int()
+}
+
+// Bans key for d
+func (b RedisBucket) Ban(ctx context.Context, key string, d time.Duration) error {
+	if b.core == nil {
+		return errNilCore
+	}
+
+	until := b.clock.Now().Add(d)
+	pipelined := b.core.TxPipelined
+	// ban and violations keys may be in different slots of cluster
+	if _, ok := b.core.(*redis.ClusterClient); ok {
+		pipelined = b.core.Pipelined
+	}
+	_, err := pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, banKeyPrefix+key, until.Format(time.RFC3339Nano), d)
+		pipe.Del(ctx, violationsKeyPrefix+key)
+		return nil
+	})
+	return err
+}
+
+// Removes ban of key
+func (b RedisBucket) Unban(ctx context.Context, key string) error {
+	if b.core == nil {
+		return errNilCore
+	}
+	return b.core.Del(ctx, banKeyPrefix+key).Err()
+}
+
+// Returns time when ban of key ends. Returns zero time if key isn't banned
+func (b RedisBucket) BannedUntil(ctx context.Context, key string) (time.Time, error) {
+	if b.core == nil {
+		return time.Time{}, errNilCore
+	}
+	return b.bannedUntil(ctx, b.core, key)
+}
+
+func (b RedisBucket) bannedUntil(ctx context.Context, c redis.UniversalClient, key string) (time.Time, error) {
+	r, err := c.Get(ctx, banKeyPrefix+key).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	until, err := time.Parse(time.RFC3339Nano, r)
+	if err != nil {
+		return time.Time{}, ErrBadSyntaxInStorage
+	}
+	return until, nil
+}
+
+// Returns list of banned keys.
+//
+// Uses SCAN, so result is approximate if bans are changed while listing
+func (b RedisBucket) Bans(ctx context.Context) ([]BanInfo, error) {
+	if b.core == nil {
+		return nil, errNilCore
+	}
+
+	c := b.reader()
+	var bans []BanInfo
+	err := scanKeys(ctx, c, banKeyPrefix+"*", 1000, func(name string) error {
+		key := strings.TrimPrefix(name, banKeyPrefix)
+		until, err := b.bannedUntil(ctx, c, key)
+		// ban expired while listing
+		if err != nil || until.IsZero() {
+			return err
+		}
+		bans = append(bans, BanInfo{Key: key, Until: until})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bans, nil
+}
+
+// Returns tokens of key without taking them
+func (b RedisBucket) Peek(ctx context.Context, key string) (KeyState, error) {
+	if b.core == nil {
+		return KeyState{}, errNilCore
+	}
+
+	st, err := b.load(ctx, b.reader(), keyPrefix+key, b.Rate())
+	if err != nil {
+		return KeyState{}, err
+	}
+	state := KeyState{
+		Key:        key,
+		Tokens:     st.Tokens,
+		RefilledAt: st.RefilledAt,
+		Exists:     st.exists,
+	}
+	if st.exists {
+		ttl, err := b.reader().PTTL(ctx, keyPrefix+key).Result()
+		if err != nil {
+			return KeyState{}, err
+		}
+		// negative ttl: key expired after read or has no expiry
+		if ttl > 0 {
+			state.ExpiresAt = b.clock.Now().Add(ttl)
+		}
+	}
+	return state, nil
+}
+
+// Restores full capacity of key
+func (b RedisBucket) Reset(ctx context.Context, key string) error {
+	if b.core == nil {
+		return errNilCore
+	}
+	return b.core.Del(ctx, keyPrefix+key).Err()
+}
+
+// Returns limits of bucket
+func (b RedisBucket) Rate() Rate {
+	return Rate{
+		Capacity: b.cap,
+		Refill:   b.tokenAppendTime,
+		TTL:      b.dur,
+	}
+}
+
+// Closes connections to redis
+func (b RedisBucket) Close() error {
+	if b.replica != nil {
+		return errors.Join(b.core.Close(), b.replica.Close())
+	}
+	return b.core.Close()
+}
+
+// Pings primary redis. Replica isn't checked,
+// it serves only inspection queries
+func (b RedisBucket) Ping(ctx context.Context) error {
+	if b.core == nil {
+		return errNilCore
+	}
+	return b.core.Ping(ctx).Err()
+}
+
+// Takes n tokens of key if awailable.
+// Returns ErrContention if key was updated concurrently on every retry.
+func (b RedisBucket) Take(ctx context.Context, key string, n int) (Result, error) {
+	if b.core == nil {
+		return Result{}, errNilCore
+	}
+
+	stats := WalkStatsFromContext(ctx)
+	if stats != nil {
+		stats.Backend = "redis"
+	}
+	opts, _ := WalkOptionsFromContext(ctx)
+	rate := opts.Rate.withDefaults(b.Rate())
+
+	n = takeCount(n)
+	reserve := opts.reserved(rate)
+
+	name := keyPrefix + key
+	var res Result
+	err := b.transaction(ctx, stats, func(tx *redis.Tx) error {
+		st, err := b.load(ctx, tx, name, rate)
+		if err != nil {
+			return err
+		}
+		if st.Tokens < n+reserve {
+			res = denied(rate, st.Tokens, st.RefilledAt, b.clock.Now())
+			return nil
+		}
+
+		st.Tokens -= n
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			b.store(ctx, pipe, name, st, rate.TTL)
+			return nil
+		})
+		res = allowed(rate, st.Tokens)
+		return err
+	}, name)
+	if err != nil {
+		return Result{}, err
+	}
+	return res, nil
+}
+
+// Takes tokens of all keys at once. If any key has not enough tokens,
+// nothing is taken and *LimitExceededError of that key is returned.
+//
+// Keys stored as hashes are taken by one Lua script call, so hot keys
+// don't cause retries. Keys encoded by codec (or stored in other layout)
+// are taken in one transaction with pipelined reads.
+// In redis cluster all keys should be in one hash slot
+func (b RedisBucket) WalkMany(ctx context.Context, keys []KeyedCost) error {
+	if b.core == nil {
+		return errNilCore
+	}
+
+	stats := WalkStatsFromContext(ctx)
+	if stats != nil {
+		stats.Backend = "redis"
+	}
+	keys = mergeKeyedCosts(keys)
+	if len(keys) == 0 {
+		return nil
+	}
+	names := make([]string, len(keys))
+	rates := make([]Rate, len(keys))
+	for i, k := range keys {
+		names[i] = keyPrefix + k.Key
+		rates[i] = k.Rate.withDefaults(b.Rate())
+	}
+	if b.codec == nil {
+		err := b.walkScript(ctx, keys, names, rates)
+		if !errors.Is(err, errOtherLayout) {
+			return err
+		}
+	}
+
+	return b.transaction(ctx, stats, func(tx *redis.Tx) error {
+		states, err := b.loadMany(ctx, tx, names, rates)
+		if err != nil {
+			return err
+		}
+		for i, k := range keys {
+			if states[i].Tokens < k.Cost {
+				return limitExceeded(k.Key, rates[i], states[i].Tokens, states[i].RefilledAt)
+			}
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, k := range keys {
+				states[i].Tokens -= k.Cost
+				b.store(ctx, pipe, names[i], states[i], rates[i].TTL)
+			}
+			return nil
+		})
+		return err
+	}, names...)
+}
+
+// Takes tokens of updates in one transaction
+func (b RedisBucket) Sync(ctx context.Context, updates []SyncUpdate) ([]KeyState, error) {
+	if b.core == nil {
+		return nil, errNilCore
+	}
+	if len(updates) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, len(updates))
+	rates := make([]Rate, len(updates))
+	for i, u := range updates {
+		names[i] = keyPrefix + u.Object
+		rates[i] = u.Rate.withDefaults(b.Rate())
+	}
+
+	result := make([]KeyState, len(updates))
+	err := b.transaction(ctx, WalkStatsFromContext(ctx), func(tx *redis.Tx) error {
+		states, err := b.loadMany(ctx, tx, names, rates)
+		if err != nil {
+			return err
+		}
+		for i, u := range updates {
+			states[i].Tokens = syncTokens(states[i].Tokens, u.Tokens, rates[i])
+			result[i] = KeyState{
+				Key:        u.Object,
+				Tokens:     states[i].Tokens,
+				RefilledAt: states[i].RefilledAt,
+				Exists:     true,
+			}
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i := range updates {
+				b.store(ctx, pipe, names[i], states[i], rates[i].TTL)
+			}
+			return nil
+		})
+		return err
+	}, names...)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Adds n tokens to key in transaction, even above capacity
+func (b RedisBucket) Credit(ctx context.Context, key string, n int) (KeyState, error) {
+	if b.core == nil {
+		return KeyState{}, errNilCore
+	}
+	if n <= 0 {
+		return KeyState{}, errCreditNotPositive
+	}
+	opts, _ := WalkOptionsFromContext(ctx)
+	rate := opts.Rate.withDefaults(b.Rate())
+	name := keyPrefix + key
+
+	var st redisState
+	err := b.transaction(ctx, WalkStatsFromContext(ctx), func(tx *redis.Tx) error {
+		var err error
+		if st, err = b.load(ctx, tx, name, rate); err != nil {
+			return err
+		}
+		st.Tokens, st.RefilledAt = credit(st.Tokens, st.RefilledAt, n, rate, b.clock.Now())
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			b.store(ctx, pipe, name, st, rate.TTL)
+			return nil
+		})
+		return err
+	}, name)
+	if err != nil {
+		return KeyState{}, err
+	}
+	return KeyState{Key: key, Tokens: st.Tokens, RefilledAt: st.RefilledAt, Exists: true}, nil
+}
+
+// Books n tokens key has by time at in transaction,
+// tokens of key may go below zero
+func (b RedisBucket) Book(ctx context.Context, key string, n int, at time.Time) (time.Time, error) {
+	if b.core == nil {
+		return time.Time{}, errNilCore
+	}
+	if n <= 0 {
+		return time.Time{}, errBookNotPositive
+	}
+	stats := WalkStatsFromContext(ctx)
+	if stats != nil {
+		stats.Backend = "redis"
+	}
+	opts, _ := WalkOptionsFromContext(ctx)
+	rate := opts.Rate.withDefaults(b.Rate())
+	name := keyPrefix + key
+
+	var ready time.Time
+	err := b.transaction(ctx, stats, func(tx *redis.Tx) error {
+		st, err := b.load(ctx, tx, name, rate)
+		if err != nil {
+			return err
+		}
+		now := b.clock.Now()
+		st.Tokens, ready, err = book(key, st.Tokens, st.RefilledAt, n, rate, now, at)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			// booked tokens are kept till they may be used
+			b.store(ctx, pipe, name, st, ready.Sub(now)+rate.TTL)
+			return nil
+		})
+		return err
+	}, name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return ready, nil
+}
+
+// Runs fn watching keys. Transactions failed because of concurrent
+// updates are retried with backoff up to max retries of bucket
+func (b RedisBucket) transaction(ctx context.Context, stats *WalkStats, fn func(tx *redis.Tx) error, keys ...string) error {
+	for attempt := 0; ; attempt++ {
+		err := b.core.Watch(ctx, fn, keys...)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+		if attempt >= b.maxRetries {
+			return ErrContention
+		}
+		if stats != nil {
+			stats.Retries++
+		}
+		if err := backoff(ctx, attempt); err != nil {
+			return err
+		}
+	}
+}
+
+// Reserves slot of key spaced by interval from slots reserved before.
+// Next free slot is stored as unix nanoseconds in gincage-pace:<key>
+func (b RedisBucket) Reserve(ctx context.Context, key string, interval, maxDelay time.Duration) (time.Duration, error) {
+	if b.core == nil {
+		return 0, errNilCore
+	}
+
+	stats := WalkStatsFromContext(ctx)
+	if stats != nil {
+		stats.Backend = "redis"
+	}
+	name := paceKeyPrefix + key
+
+	var delay time.Duration
+	err := b.transaction(ctx, stats, func(tx *redis.Tx) error {
+		now := b.clock.Now()
+		next := now
+		n, err := tx.Get(ctx, name).Int64()
+		switch {
+		case errors.Is(err, redis.Nil):
+		case err != nil:
+			return err
+		case time.Unix(0, n).After(now):
+			next = time.Unix(0, n)
+		}
+
+		delay = next.Sub(now)
+		if delay > maxDelay {
+			return &LimitExceededError{Key: key, Limit: 1, Reset: next.Add(-maxDelay)}
+		}
+		next = next.Add(interval)
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			// slot is useless after it passed
+			pipe.Set(ctx, name, next.UnixNano(), next.Sub(now))
+			return nil
+		})
+		return err
+	}, name)
+	return delay, err
+}
+
+// Waits random time growing with attempt, so concurrent
+// transactions on same key don't conflict again
+func backoff(ctx context.Context, attempt int) error {
+	d := min(time.Millisecond<<min(attempt, 6), 50*time.Millisecond)
+	t := time.NewTimer(rand.N(d) + 1)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Fields of hash storing key state
+const (
+	fieldTokens     = "tokens"
+	fieldRefilledAt = "refilled_at"
+	fieldVersion    = "version"
+)
+
+// redisState: state of key stored in redis
+type redisState struct {
+	StoredState
+	exists bool
+	// stored in other layout than used by bucket, replaced on next write
+	relayout bool
+	// schema version key was written in
+	schema int
+}
+
+// Returns state of key with refill applied.
+// Not existing key has full capacity
+func (b RedisBucket) load(ctx context.Context, c redis.Cmdable, key string, rate Rate) (redisState, error) {
+	st, err := b.read(ctx, c, key)
+	if err != nil {
+		return redisState{}, err
+	}
+	return st.refill(rate, b.clock.Now()), nil
+}
+
+// Returns states of keys with refill applied, reads are sent in one pipeline
+func (b RedisBucket) loadMany(ctx context.Context, tx *redis.Tx, keys []string, rates []Rate) ([]redisState, error) {
+	states, err := b.readMany(ctx, tx, keys)
+	if err != nil {
+		return nil, err
+	}
+	now := b.clock.Now()
+	for i := range states {
+		states[i] = states[i].refill(rates[i], now)
+	}
+	return states, nil
+}
+
+// Reads keys in one pipeline
+func (b RedisBucket) readMany(ctx context.Context, c redis.Cmdable, keys []string) ([]redisState, error) {
+	cmds := make([]redis.Cmder, len(keys))
+	_, err := c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			if b.codec != nil {
+				cmds[i] = pipe.Get(ctx, key)
+			} else {
+				cmds[i] = pipe.HGetAll(ctx, key)
+			}
+		}
+		return nil
+	})
+	// errors of single commands are checked below
+	if err != nil && !errors.Is(err, redis.Nil) && !isWrongType(err) {
+		return nil, err
+	}
+
+	states := make([]redisState, len(keys))
+	for i, cmd := range cmds {
+		var st redisState
+		var err error
+		switch cmd := cmd.(type) {
+		case *redis.StringCmd:
+			st, err = b.valueState(cmd.Bytes())
+		case *redis.MapStringStringCmd:
+			st, err = hashState(cmd.Result())
+		}
+		// key stored in other layout, rare enough for extra round trip
+		if isWrongType(err) {
+			st, err = b.read(ctx, c, keys[i])
+		}
+		if err != nil {
+			return nil, err
+		}
+		states[i] = b.migrate(st)
+	}
+	return states, nil
+}
+
+// Reads key stored as hash or as value encoded by codec
+func (b RedisBucket) read(ctx context.Context, c redis.Cmdable, key string) (redisState, error) {
+	if b.codec != nil {
+		st, err := b.valueState(c.Get(ctx, key).Bytes())
+		if isWrongType(err) {
+			st, err = hashState(c.HGetAll(ctx, key).Result())
+			st.relayout = true
+		}
+		return b.migrate(st), err
+	}
+
+	st, err := hashState(c.HGetAll(ctx, key).Result())
+	if isWrongType(err) {
+		// older versions stored "tokens|RFC3339" strings
+		st, err = b.valueState(c.Get(ctx, key).Bytes())
+		st.relayout = true
+	}
+	return b.migrate(st), err
+}
+
+// Marks key written in other schema for rewrite in schema of bucket
+func (b RedisBucket) migrate(st redisState) redisState {
+	if st.exists && st.schema != b.schema {
+		st.relayout = true
+	}
+	return st
+}
+
+// Returns st with refill applied. Not existing key has full capacity
+func (st redisState) refill(rate Rate, now time.Time) redisState {
+	if !st.exists {
+		st.Tokens, st.RefilledAt = rate.Capacity, now
+		return st
+	}
+	st.Tokens, st.RefilledAt = refill(st.Tokens, st.RefilledAt, rate, now)
+	return st
+}
+
+// Parses result of HGETALL
+func hashState(fields map[string]string, err error) (redisState, error) {
+	if err != nil || len(fields) == 0 {
+		return redisState{}, err
+	}
+	schema, err := hashSchema(fields)
+	if err != nil {
+		return redisState{}, err
+	}
+
+	tokens, err := strconv.Atoi(fields[fieldTokens])
+	if err != nil {
+		return redisState{}, ErrBadSyntaxInStorage
+	}
+	nanos, err := strconv.ParseInt(fields[fieldRefilledAt], 10, 64)
+	if err != nil {
+		return redisState{}, ErrBadSyntaxInStorage
+	}
+	// version is optional, missing one is 0
+	version, _ := strconv.ParseInt(fields[fieldVersion], 10, 64)
+
+	return redisState{
+		StoredState: StoredState{
+			Tokens:     tokens,
+			RefilledAt: time.Unix(0, nanos),
+			Version:    version,
+		},
+		exists: true,
+		schema: schema,
+	}, nil
+}
+
+// Parses result of GET. Values of built-in codecs are always readable,
+// so codec can be changed without dropping stored keys
+func (b RedisBucket) valueState(data []byte, err error) (redisState, error) {
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return redisState{}, nil
+		}
+		return redisState{}, err
+	}
+
+	schema, data, err := splitSchema(data)
+	if err != nil {
+		return redisState{}, err
+	}
+	codecs := []Codec{TextCodec, BinaryCodec}
+	if b.codec != nil {
+		codecs = append([]Codec{b.codec}, codecs...)
+	}
+	for _, c := range codecs {
+		var st StoredState
+		if st, err = c.Decode(data); err == nil {
+			return redisState{StoredState: st, exists: true, schema: schema}, nil
+		}
+	}
+	return redisState{}, err
+}
+
+// Writes state of key in transaction
+func (b RedisBucket) store(ctx context.Context, pipe redis.Pipeliner, key string, st redisState, ttl time.Duration) {
+	if st.relayout {
+		pipe.Del(ctx, key)
+	}
+	st.Version++
+	ttl = withJitter(ttl, b.ttlJitter)
+	if b.codec != nil {
+		pipe.Set(ctx, key, appendSchema(b.schema, b.codec.Encode(st.StoredState)), ttl)
+		return
+	}
+	fields := []any{
+		fieldTokens, st.Tokens,
+		fieldRefilledAt, st.RefilledAt.UnixNano(),
+		fieldVersion, st.Version,
+	}
+	if b.schema > SchemaV1 {
+		fields = append(fields, fieldSchema, b.schema)
+	}
+	pipe.HSet(ctx, key, fields...)
+	pipe.PExpire(ctx, key, ttl)
+}
+
+func isWrongType(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "WRONGTYPE")
+}
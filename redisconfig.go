@@ -0,0 +1,119 @@
+package gincage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Default redis key (and pub/sub channel) of limits config
+const DefaultConfigKey = "gincage-config"
+
+// Stores cfg in redis key as JSON and notifies limiters watching it
+// (see WatchRedisConfig), so limits are changed fleet-wide without redeploy.
+// If key is empty, DefaultConfigKey is used
+func PublishConfig(ctx context.Context, c *redis.Client, key string, cfg Config) error {
+	if key == "" {
+		key = DefaultConfigKey
+	}
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	if err := c.Set(ctx, key, data, 0).Err(); err != nil {
+		return err
+	}
+	return c.Publish(ctx, key, data).Err()
+}
+
+// Loads limits from redis key (see PublishConfig) and reloads them when
+// they are published, until ctx is done or limiter is closed. Key is also checked every interval,
+// so missed notifications and direct writes are picked up.
+//
+// Returns error if key can't be read initially, missing key keeps current
+// limits. Later failures are logged and previous limits are kept.
+// If key is empty, DefaultConfigKey is used. If interval <= 0, uses DefaultConfigWatchInterval
+func (l *Limiter) WatchRedisConfig(ctx context.Context, c *redis.Client, key string, interval time.Duration) error {
+	if key == "" {
+		key = DefaultConfigKey
+	}
+	if interval <= 0 {
+		interval = DefaultConfigWatchInterval
+	}
+
+	w := &redisConfigWatcher{limiter: l, key: key}
+	if err := w.refresh(ctx, c); err != nil {
+		return err
+	}
+
+	sub := c.Subscribe(ctx, key)
+	l.background(ctx, func(ctx context.Context) {
+		defer sub.Close()
+		ch := sub.Channel()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case m, ok := <-ch:
+				if !ok {
+					return
+				}
+				w.apply([]byte(m.Payload))
+			case <-ticker.C:
+				if err := w.refresh(ctx, c); err != nil && ctx.Err() == nil {
+					l.logger.Error("failed to check redis config", F("error", err), F("key", key))
+				}
+			}
+		}
+	})
+	return nil
+}
+
+type redisConfigWatcher struct {
+	limiter *Limiter
+	key     string
+	// last applied config, config is updated only when it changes
+	last []byte
+}
+
+// Reads config from redis and applies it if it was changed
+func (w *redisConfigWatcher) refresh(ctx context.Context, c *redis.Client) error {
+	data, err := c.Get(ctx, w.key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	w.apply(data)
+	return nil
+}
+
+func (w *redisConfigWatcher) apply(data []byte) {
+	if bytes.Equal(data, w.last) {
+		return
+	}
+	w.last = data
+
+	if err := w.limiter.updateConfigJSON(data); err != nil {
+		w.limiter.logger.Error("failed to reload redis config", F("error", err), F("key", w.key))
+	}
+}
+
+func (l *Limiter) updateConfigJSON(data []byte) error {
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("bad config: %w", err)
+	}
+	return l.UpdateConfig(cfg)
+}
@@ -0,0 +1,74 @@
+package gincage
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+)
+
+// States of take function
+const (
+	functionUnknown int32 = iota
+	functionLoaded
+	functionUnsupported
+)
+
+var (
+	// Name of take function carries hash of script, so instances
+	// of other releases keep their own functions
+	takeFunctionName = "gincage_take_" + takeManyScript.Hash()[:12]
+	// Library of take function, arguments have names of script globals
+	takeFunctionLibrary = "#!lua name=" + takeFunctionName + "\n" +
+		"redis.register_function('" + takeFunctionName + "', function(KEYS, ARGV)" +
+		takeManyLua +
+		"end)\n"
+)
+
+// State of take function shared by copies of bucket
+type redisFunctions struct {
+	state atomic.Int32
+}
+
+// Returns state of functions enabled by cfg, nil if they aren't
+func newRedisFunctions(cfg BucketConfigs) *redisFunctions {
+	if !cfg.Functions {
+		return nil
+	}
+	return &redisFunctions{}
+}
+
+// Runs take script as redis function if functions are enabled,
+// otherwise (and if server doesn't support them) with EVALSHA
+func (b RedisBucket) runTake(ctx context.Context, names []string, args []any) ([]int64, error) {
+	if b.functions != nil && b.functions.ready(ctx, b) {
+		reply, err := b.core.FCall(ctx, takeFunctionName, names, args...).Int64Slice()
+		if err == nil || !strings.Contains(err.Error(), "Function not found") {
+			return reply, err
+		}
+		// functions were flushed, function is loaded again on next call
+		b.functions.state.CompareAndSwap(functionLoaded, functionUnknown)
+	}
+	return takeManyScript.Run(ctx, b.core, names, args...).Int64Slice()
+}
+
+// Returns true if take function is loaded, loads it on first call.
+// Concurrent first calls may load it twice, which is harmless
+func (f *redisFunctions) ready(ctx context.Context, b RedisBucket) bool {
+	switch f.state.Load() {
+	case functionLoaded:
+		return true
+	case functionUnsupported:
+		return false
+	}
+
+	err := b.core.FunctionLoad(ctx, takeFunctionLibrary).Err()
+	switch {
+	case err == nil, strings.Contains(err.Error(), "already exists"):
+		f.state.Store(functionLoaded)
+		return true
+	case strings.Contains(strings.ToLower(err.Error()), "unknown command"):
+		f.state.Store(functionUnsupported)
+	}
+	// other errors (e.g. timeouts) are retried on next call
+	return false
+}
@@ -0,0 +1,120 @@
+package gincage
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Lua script taking tokens of all keys or of none of them,
+// the same one RedisBucket runs. Called with RedisLayout.TakeKeys and
+// RedisLayout.TakeArgs, its reply is parsed by RedisLayout.TakeReply
+const RedisTakeScript = takeManyLua
+
+// Lua script counting violation of key, the same one RedisBucket runs.
+// Called with RedisLayout.ViolationsKey and window in milliseconds,
+// replies count of violations in window
+const RedisViolationScript = addViolationLua
+
+// RedisLayout: how RedisBucket stores keys without Codec. Buckets on other
+// redis clients (e.g. gincagerueidis) use it to share storage with RedisBucket
+type RedisLayout struct {
+	schema    int
+	ttlJitter time.Duration
+}
+
+// Returns layout of keys written in schema and with TTLJitter of cfg
+func NewRedisLayout(cfg BucketConfigs) RedisLayout {
+	return RedisLayout{schema: schemaOf(cfg), ttlJitter: cfg.TTLJitter}
+}
+
+// Returns redis key keeping tokens of key
+func (RedisLayout) Key(key string) string {
+	return keyPrefix + key
+}
+
+// Returns redis key keeping ban of key
+func (RedisLayout) BanKey(key string) string {
+	return banKeyPrefix + key
+}
+
+// Returns redis key counting violations of key
+func (RedisLayout) ViolationsKey(key string) string {
+	return violationsKeyPrefix + key
+}
+
+// Returns KEYS of RedisTakeScript taking keys
+func (l RedisLayout) TakeKeys(keys []KeyedCost) []string {
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = l.Key(k.Key)
+	}
+	return names
+}
+
+// Returns ARGV of RedisTakeScript taking keys with rates at now
+func (l RedisLayout) TakeArgs(now time.Time, keys []KeyedCost, rates []Rate) []string {
+	args := make([]string, 0, 4+4*len(keys))
+	args = append(args,
+		strconv.FormatInt(now.Unix(), 10),
+		strconv.Itoa(now.Nanosecond()),
+		strconv.Itoa(l.schema),
+		strconv.Itoa(LatestSchema),
+	)
+	for i, k := range keys {
+		ttl := withJitter(rates[i].TTL, l.ttlJitter)
+		args = append(args,
+			strconv.Itoa(takeCount(k.Cost)),
+			strconv.Itoa(rates[i].Capacity),
+			strconv.FormatInt(int64(rates[i].Refill), 10),
+			strconv.FormatInt(max(ttl.Milliseconds(), 1), 10),
+		)
+	}
+	return args
+}
+
+// Returns tokens left of keys taken by RedisTakeScript, or
+// *LimitExceededError of key without enough tokens.
+// Returns ErrBadSyntaxInStorage if key was written by RedisBucket with Codec
+func (RedisLayout) TakeReply(reply []int64, keys []KeyedCost, rates []Rate) ([]int64, error) {
+	left, err := takeManyReply(reply, keys, rates)
+	if errors.Is(err, errOtherLayout) {
+		return nil, ErrBadSyntaxInStorage
+	}
+	return left, err
+}
+
+// Returns state of key from HGETALL fields and PTTL (ms) of its redis key,
+// with tokens refilled till now. Not existing key has full capacity
+func (RedisLayout) KeyState(key string, fields map[string]string, pttl int64, rate Rate, now time.Time) (KeyState, error) {
+	st, err := hashState(fields, nil)
+	if err != nil {
+		return KeyState{}, err
+	}
+	st = st.refill(rate, now)
+	state := KeyState{
+		Key:        key,
+		Tokens:     st.Tokens,
+		RefilledAt: st.RefilledAt,
+		Exists:     st.exists,
+	}
+	// negative ttl: key expired or has no expiry
+	if st.exists && pttl > 0 {
+		state.ExpiresAt = now.Add(time.Duration(pttl) * time.Millisecond)
+	}
+	return state, nil
+}
+
+// Returns value of ban key for ban ending at until
+func (RedisLayout) BanValue(until time.Time) string {
+	return until.Format(time.RFC3339Nano)
+}
+
+// Parses value of ban key
+func (RedisLayout) ParseBan(v string) (time.Time, error) {
+	until, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}, ErrBadSyntaxInStorage
+	}
+	return until, nil
+}
@@ -0,0 +1,151 @@
+package gincage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Replies of takeManyScript
+const (
+	scriptTaken = iota
+	scriptDenied
+	scriptOtherLayout
+	scriptUnknownSchema
+	scriptBadSyntax
+)
+
+// errOtherLayout: some key isn't stored as hash, so script can't take it
+var errOtherLayout = errors.New("key stored in other layout")
+
+// Takes tokens of all KEYS or of none of them.
+//
+// ARGV: now seconds, now nanoseconds, schema of bucket, max known schema,
+// then cost, capacity, refill (ns) and ttl (ms) of every key.
+// Unix nanoseconds don't fit in Lua numbers exactly,
+// so times are kept as seconds and nanoseconds.
+//
+// Refill is the same as refill in bucket.go.
+// Replies {taken, tokens left of every key},
+// {denied, key index, tokens, refilled seconds, refilled ns}
+// or {error code, key index}
+const takeManyLua = `
+local now_sec, now_ns = tonumber(ARGV[1]), tonumber(ARGV[2])
+local schema, max_schema = tonumber(ARGV[3]), tonumber(ARGV[4])
+local states = {}
+for i, key in ipairs(KEYS) do
+	local a = 4 + (i - 1) * 4
+	local cost, cap, refill = tonumber(ARGV[a + 1]), tonumber(ARGV[a + 2]), tonumber(ARGV[a + 3])
+	local kind = redis.call('TYPE', key)['ok']
+	if kind ~= 'hash' and kind ~= 'none' then
+		return {2, i}
+	end
+
+	local tokens, sec, ns, version, stored = cap, now_sec, now_ns, 0, nil
+	if kind == 'hash' then
+		local f = redis.call('HMGET', key, 'tokens', 'refilled_at', 'version', 'schema')
+		stored = 1
+		if f[4] then
+			stored = tonumber(f[4])
+			if not stored or stored < 1 then
+				return {4, i}
+			end
+			if stored > max_schema then
+				return {3, i}
+			end
+		end
+		tokens = tonumber(f[1])
+		local r = f[2]
+		if not tokens or not r or not tonumber(r) then
+			return {4, i}
+		end
+		if #r > 9 then
+			sec, ns = tonumber(string.sub(r, 1, -10)), tonumber(string.sub(r, -9))
+		else
+			sec, ns = 0, tonumber(r)
+		end
+		version = tonumber(f[3]) or 0
+
+		-- written by instance with clock ahead of ours
+		if sec > now_sec or (sec == now_sec and ns > now_ns) then
+			sec, ns = now_sec, now_ns
+		end
+		if tokens < cap then
+			local p = (now_sec - sec) * 1e9 + (now_ns - ns)
+			if p >= refill then
+				tokens = tokens + math.min(math.floor(p / refill), cap - tokens)
+				if tokens == cap then
+					sec, ns = now_sec, now_ns
+				else
+					local add = ns + math.floor(p / refill) * refill
+					sec, ns = sec + math.floor(add / 1e9), add % 1e9
+				end
+			end
+		end
+	end
+
+	if tokens < cost then
+		return {1, i, tokens, sec, ns}
+	end
+	states[i] = {tokens - cost, sec, ns, version + 1, stored}
+end
+
+local reply = {0}
+for i, key in ipairs(KEYS) do
+	local st = states[i]
+	local ttl = tonumber(ARGV[4 + i * 4])
+	local fields = {'tokens', st[1], 'refilled_at', string.format('%d%09d', st[2], st[3]), 'version', st[4]}
+	if schema > 1 then
+		table.insert(fields, 'schema')
+		table.insert(fields, schema)
+	elseif st[5] and st[5] > 1 then
+		redis.call('HDEL', key, 'schema')
+	end
+	redis.call('HSET', key, unpack(fields))
+	redis.call('PEXPIRE', key, ttl)
+	table.insert(reply, st[1])
+end
+return reply
+`
+
+var takeManyScript = redis.NewScript(takeManyLua)
+
+// Takes tokens of keys with one script call, so keys are never taken
+// partially and hot keys (e.g. global one) don't fail WATCH of transactions.
+// Returns errOtherLayout if some key isn't stored as hash
+func (b RedisBucket) walkScript(ctx context.Context, keys []KeyedCost, names []string, rates []Rate) error {
+	now := b.clock.Now()
+	args := make([]any, 0, 4+4*len(keys))
+	args = append(args, now.Unix(), now.Nanosecond(), b.schema, LatestSchema)
+	for i, k := range keys {
+		ttl := withJitter(rates[i].TTL, b.ttlJitter)
+		args = append(args, k.Cost, rates[i].Capacity, int64(rates[i].Refill), max(ttl.Milliseconds(), 1))
+	}
+
+	reply, err := b.runTake(ctx, names, args)
+	if err != nil {
+		return err
+	}
+	_, err = takeManyReply(reply, keys, rates)
+	return err
+}
+
+// Returns tokens left of keys taken by takeManyLua
+// or error of its reply
+func takeManyReply(reply []int64, keys []KeyedCost, rates []Rate) ([]int64, error) {
+	switch reply[0] {
+	case scriptTaken:
+		return reply[1:], nil
+	case scriptDenied:
+		i := reply[1] - 1
+		return nil, limitExceeded(keys[i].Key, rates[i], int(reply[2]), time.Unix(reply[3], reply[4]))
+	case scriptOtherLayout:
+		return nil, errOtherLayout
+	case scriptUnknownSchema:
+		return nil, ErrUnknownSchema
+	default:
+		return nil, ErrBadSyntaxInStorage
+	}
+}
@@ -0,0 +1,137 @@
+package gincage
+
+import (
+	"context"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Default time slots of crashed instances are kept
+var DefaultSemaphoreTTL = time.Duration(30 * time.Second)
+
+// Takes slot of KEYS[1] if there are fewer than max unexpired slots.
+// Slots are members of sorted set scored with their expiry time.
+//
+// ARGV: now (ms), expiry of slot (ms), max, slot id, ttl (ms).
+// Replies 1 if slot is taken, 0 otherwise
+var acquireScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[3]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[4])
+redis.call('PEXPIRE', KEYS[1], ARGV[5])
+return 1
+`)
+
+// RedisSemaphore: Semaphore shared by instances through redis.
+//
+// Held slots are refreshed every third of TTL until they are released,
+// so slots of crashed instances expire after TTL instead of leaking forever
+type RedisSemaphore struct {
+	core *redis.Client
+	ttl  time.Duration
+
+	mu   sync.Mutex
+	held map[string]context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+// Creates semaphore storing slots in redis.
+// If ttl <= 0, uses DefaultSemaphoreTTL
+func NewRedisSemaphore(c *redis.Client, ttl time.Duration) *RedisSemaphore {
+	if ttl <= 0 {
+		ttl = DefaultSemaphoreTTL
+	}
+	return &RedisSemaphore{
+		core: c,
+		ttl:  ttl,
+		held: make(map[string]context.CancelFunc),
+	}
+}
+
+// Takes slot of key if fewer than max slots are held by all instances
+func (s *RedisSemaphore) Acquire(ctx context.Context, key string, max int) (string, error) {
+	if s.core == nil {
+		return "", errNilCore
+	}
+
+	id := strconv.FormatUint(rand.Uint64(), 36)
+	now := time.Now()
+	ok, err := acquireScript.Run(ctx, s.core, []string{connsKeyPrefix + key},
+		now.UnixMilli(), now.Add(s.ttl).UnixMilli(), max, id, s.ttl.Milliseconds()).Bool()
+	if err != nil || !ok {
+		return "", err
+	}
+
+	hctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.held[key+"|"+id] = cancel
+	s.mu.Unlock()
+	s.wg.Add(1)
+	go s.heartbeat(hctx, key, id)
+	return id, nil
+}
+
+// Refreshes expiry of slot until ctx is done or slot is lost
+func (s *RedisSemaphore) heartbeat(ctx context.Context, key, id string) {
+	defer s.wg.Done()
+	t := time.NewTicker(s.ttl / 3)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		expires := float64(time.Now().Add(s.ttl).UnixMilli())
+		var refreshed *redis.IntCmd
+		_, err := s.core.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			refreshed = p.ZAddArgs(ctx, connsKeyPrefix+key, redis.ZAddArgs{
+				XX:      true,
+				Ch:      true,
+				Members: []redis.Z{{Score: expires, Member: id}},
+			})
+			p.PExpire(ctx, connsKeyPrefix+key, s.ttl)
+			return nil
+		})
+		// failed refresh is retried on next tick, slot is still valid until ttl
+		if err == nil && refreshed.Val() == 0 {
+			// slot expired (e.g. redis was unreachable longer than ttl)
+			return
+		}
+	}
+}
+
+// Returns slot of key and stops its refreshes
+func (s *RedisSemaphore) Release(ctx context.Context, key, id string) error {
+	s.mu.Lock()
+	if cancel, ok := s.held[key+"|"+id]; ok {
+		cancel()
+		delete(s.held, key+"|"+id)
+	}
+	s.mu.Unlock()
+
+	if s.core == nil {
+		return errNilCore
+	}
+	return s.core.ZRem(ctx, connsKeyPrefix+key, id).Err()
+}
+
+// Stops refreshes of held slots, so they expire after ttl.
+// Redis client isn't closed
+func (s *RedisSemaphore) Close() error {
+	s.mu.Lock()
+	for k, cancel := range s.held {
+		cancel()
+		delete(s.held, k)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
@@ -0,0 +1,127 @@
+package gincage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Implements Bucket interface with connection given by URL,
+// as most platforms hand out connection strings:
+//
+//	redis://[user:password@]host:port/db
+//	rediss://[user:password@]host:port/db (TLS)
+//	redis+sentinel://[user:password@]host:port[,host:port...]/db?master=name
+//	redis+cluster://[user:password@]host:port[,host:port...]
+//
+// Query params of redis and cluster URLs (dial_timeout, pool_size, ...)
+// are applied. Limits and other settings are taken from cfg, its connection
+// settings are ignored, except TLS files used by rediss.
+// In cluster keys taken together should be in one hash slot, see NewRedisClusterBucket
+func NewRedisBucketFromURL(rawURL string, cfg BucketConfigs) (Bucket, error) {
+	var c *redis.Client
+	switch {
+	case strings.HasPrefix(rawURL, "redis://"), strings.HasPrefix(rawURL, "rediss://"):
+		opts, err := redis.ParseURL(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		if opts.TLSConfig != nil && cfg.TLS != nil {
+			if opts.TLSConfig, err = cfg.TLS.config(opts.TLSConfig.ServerName); err != nil {
+				return nil, err
+			}
+		}
+		opts.ContextTimeoutEnabled = true
+		c = redis.NewClient(opts)
+	case strings.HasPrefix(rawURL, "redis+sentinel://"):
+		opts, err := parseSentinelURL(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		c = redis.NewFailoverClient(opts)
+	case strings.HasPrefix(rawURL, "redis+cluster://"):
+		opts, err := parseClusterURL(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		c := redis.NewClusterClient(opts)
+		if err := c.Ping(context.Background()).Err(); err != nil {
+			c.Close()
+			return nil, err
+		}
+		return NewRedisClusterBucket(cfg, c), nil
+	default:
+		return nil, errors.New("redis url: scheme should be redis, rediss, redis+sentinel or redis+cluster")
+	}
+
+	if err := c.Ping(context.Background()).Err(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return NewRedisBucketWithClient(cfg, c), nil
+}
+
+// Parses redis+sentinel://[user:password@]host:port[,host:port...]/db?master=name
+func parseSentinelURL(rawURL string) (*redis.FailoverOptions, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("redis url: %w", err)
+	}
+	opts := &redis.FailoverOptions{
+		MasterName:            u.Query().Get("master"),
+		ContextTimeoutEnabled: true,
+	}
+	if opts.MasterName == "" {
+		return nil, errors.New("redis url: master should be set for sentinel")
+	}
+	for _, addr := range strings.Split(u.Host, ",") {
+		if addr == "" {
+			return nil, errors.New("redis url: sentinel address should not be empty")
+		}
+		opts.SentinelAddrs = append(opts.SentinelAddrs, addr)
+	}
+	if u.User != nil {
+		opts.Username = u.User.Username()
+		opts.Password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if opts.DB, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("redis url: invalid database %q", db)
+		}
+	}
+	return opts, nil
+}
+
+// Parses redis+cluster://[user:password@]host:port[,host:port...]?params
+func parseClusterURL(rawURL string) (*redis.ClusterOptions, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("redis url: %w", err)
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		return nil, errors.New("redis url: cluster has no databases")
+	}
+	addrs := strings.Split(u.Host, ",")
+	q := u.Query()
+	for i, addr := range addrs {
+		if addr == "" {
+			return nil, errors.New("redis url: cluster address should not be empty")
+		}
+		// first address stays host of url, others are passed as addr params
+		if i > 0 {
+			q.Add("addr", addr)
+		}
+	}
+	u.Scheme, u.Host, u.RawQuery = "redis", addrs[0], q.Encode()
+	opts, err := redis.ParseClusterURL(u.String())
+	if err != nil {
+		return nil, fmt.Errorf("redis url: %w", err)
+	}
+	opts.ContextTimeoutEnabled = true
+	return opts, nil
+}
@@ -0,0 +1,88 @@
+package gincage
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Takes request id from header name (e.g. "X-Request-ID") in gin
+// and net/http middlewares, so log lines, hook events and trace spans
+// of request can be correlated with logs of service
+func WithRequestIDHeader(name string) Option {
+	return func(l *Limiter) {
+		l.requestIDHeader = name
+	}
+}
+
+// Takes request id from gin context key set by request id middleware
+// before limiter. Header set by WithRequestIDHeader is used if key isn't set
+func WithRequestIDKey(key string) Option {
+	return func(l *Limiter) {
+		l.requestIDKey = key
+	}
+}
+
+type requestIDKey struct{}
+
+// Returns copy of ctx carrying request id. Allow attaches
+// Request.RequestID to context passed to bucket, so bucket
+// decorators (e.g. tracing) can read it with RequestIDFromContext
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// Returns request id attached to ctx or empty string
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Returns request id of gin request or empty string if ids aren't used
+func (l *Limiter) ginRequestID(ctx *gin.Context) string {
+	if l.requestIDKey != "" {
+		if id := ctx.GetString(l.requestIDKey); id != "" {
+			return id
+		}
+	}
+	return l.headerRequestID(ctx.Request)
+}
+
+// Returns request id from header of r
+func (l *Limiter) headerRequestID(r *http.Request) string {
+	if l.requestIDHeader == "" || r == nil {
+		return ""
+	}
+	return r.Header.Get(l.requestIDHeader)
+}
+
+// Returns logger adding request id to log lines of request
+func (l *Limiter) log(id string) Logger {
+	if id == "" {
+		return l.logger
+	}
+	return requestLogger{l: l.logger, id: F("request_id", id)}
+}
+
+// requestLogger: Logger adding request id field to every line
+type requestLogger struct {
+	l  Logger
+	id Field
+}
+
+func (r requestLogger) Debug(msg string, fields ...Field) {
+	r.l.Debug(msg, append(fields, r.id)...)
+}
+
+func (r requestLogger) Info(msg string, fields ...Field) {
+	r.l.Info(msg, append(fields, r.id)...)
+}
+
+func (r requestLogger) Warn(msg string, fields ...Field) {
+	r.l.Warn(msg, append(fields, r.id)...)
+}
+
+func (r requestLogger) Error(msg string, fields ...Field) {
+	r.l.Error(msg, append(fields, r.id)...)
+}
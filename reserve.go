@@ -0,0 +1,100 @@
+package gincage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// States of reservation
+const (
+	reservationPending = iota
+	reservationCommitted
+	reservationCanceled
+)
+
+// Reservation: tokens of key booked ahead of time by Limiter.Reserve,
+// like rate.Reservation of golang.org/x/time/rate, but shared by instances.
+//
+// Tokens are taken when reservation is made. Holder should wait for
+// Delay before acting, then Commit reservation, or Cancel it
+// to return tokens
+type Reservation struct {
+	// Storage key
+	Key string
+	// Count of booked tokens
+	Tokens int
+	// Time booked tokens may be used, not before notBefore of Reserve
+	TimeToAct time.Time
+
+	l    *Limiter
+	rate Rate
+
+	mu    sync.Mutex
+	state int
+}
+
+// Books n tokens of key (client key or route-scoped key like
+// "/login|10.0.0.1", see StorageKey) to be used not before notBefore,
+// e.g. by schedulers and batch jobs. Tokens of key may go below zero
+// until they are refilled, so requests of key are rejected meanwhile.
+// Limit of key is taken the same way as by Credit.
+//
+// If key doesn't have n tokens by notBefore, nothing is booked and
+// *LimitExceededError is returned with time key has them.
+// Returns errors.ErrUnsupported if bucket doesn't implement Booker
+func (l *Limiter) Reserve(ctx context.Context, key string, n int, notBefore time.Time) (*Reservation, error) {
+	b, ok := BucketAs[Booker](l.bucket)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	key = l.StorageKey(key)
+	rate := l.config.Load().keyRate(key)
+	ctx = ContextWithWalkOptions(ctx, WalkOptions{Key: key, Rate: rate})
+	ctx, cancel := l.storageContext(ctx)
+	defer cancel()
+	t, err := b.Book(ctx, key, n, notBefore)
+	if err != nil {
+		return nil, err
+	}
+	return &Reservation{Key: key, Tokens: n, TimeToAct: t, l: l, rate: rate}, nil
+}
+
+// Returns time holder should wait before acting on reservation
+func (r *Reservation) Delay() time.Duration {
+	return max(time.Until(r.TimeToAct), 0)
+}
+
+// Marks booked tokens as used, so Cancel doesn't return them.
+// Returns ErrReservationCanceled if reservation was canceled
+func (r *Reservation) Commit() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.state == reservationCanceled {
+		return ErrReservationCanceled
+	}
+	r.state = reservationCommitted
+	return nil
+}
+
+// Returns booked tokens to key unless reservation is committed,
+// tokens of key don't go above capacity. Tokens aren't returned
+// if bucket doesn't implement Syncer
+func (r *Reservation) Cancel(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.state != reservationPending {
+		return nil
+	}
+	r.state = reservationCanceled
+
+	s, ok := BucketAs[Syncer](r.l.bucket)
+	if !ok {
+		return nil
+	}
+	ctx, cancel := r.l.storageContext(ctx)
+	defer cancel()
+	_, err := s.Sync(ctx, []SyncUpdate{{Object: r.Key, Tokens: -r.Tokens, Timestamp: time.Now(), Rate: r.rate}})
+	return err
+}
@@ -0,0 +1,112 @@
+package gincage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Response: HTTP response of rejected request, see Render
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Writes response to w
+func (r Response) Write(w http.ResponseWriter) {
+	for k, v := range r.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(r.Status)
+	w.Write(r.Body)
+}
+
+const (
+	RateLimitLimitHeader     = "RateLimit-Limit"
+	RateLimitRemainingHeader = "RateLimit-Remaining"
+	RateLimitPolicyHeader    = "RateLimit-Policy"
+)
+
+// Builds response of rejected request with statuses and bodies
+// set by options (WithErrorBody, WithProblemDetails, ...)
+func (l *Limiter) Render(d Decision) Response {
+	var r Response
+	if d.Challenged && l.challenge != nil {
+		r = l.challenge.Challenge(d)
+		if r.Header == nil {
+			r.Header = make(http.Header)
+		}
+	} else if d.Limited() {
+		r = l.response(l.tooManyRequestsStatus, l.tooManyRequestsError, "too many requests, try again later", d.RetryAfter)
+	} else if errors.Is(d.Err, ErrMaintenance) {
+		r = l.response(http.StatusServiceUnavailable, DefaultMaintenanceError, "service is under maintenance", 0)
+	} else {
+		r = l.response(l.serverErrorStatus, l.serverError, "server error occured", 0)
+	}
+	for k, v := range l.Header(d) {
+		r.Header[k] = v
+	}
+	return r
+}
+
+// Returns rate limit headers of decision if they are enabled
+// with WithRateLimitHeaders and shadow header (see WithShadowHeader).
+// Adapters add them to allowed responses, Render adds them to rejected ones
+func (l *Limiter) Header(d Decision) http.Header {
+	h := http.Header{}
+	if l.rateLimitHeaders && d.Limit > 0 {
+		h.Set(RateLimitLimitHeader, strconv.Itoa(d.Limit))
+		h.Set(RateLimitRemainingHeader, strconv.Itoa(d.Remaining))
+	}
+	if l.rateLimitHeaders && d.Policy != "" {
+		h.Set(RateLimitPolicyHeader, d.Policy)
+	}
+	if d.Shadow && l.shadowHeader != "" {
+		h.Set(l.shadowHeader, "limited")
+	}
+	if len(h) == 0 {
+		return nil
+	}
+	return h
+}
+
+// Returns quotas of rates as "capacity;w=seconds" list, where window
+// is time full capacity is refilled in. Zero fields are taken from bucket
+func (l *Limiter) policy(rates ...Rate) string {
+	var bucket Rate
+	if r, ok := BucketAs[RateReporter](l.bucket); ok {
+		bucket = r.Rate()
+	}
+	var b strings.Builder
+	for _, rate := range rates {
+		rate = rate.withDefaults(bucket)
+		if rate.Capacity <= 0 || rate.Refill <= 0 {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString(", ")
+		}
+		window := time.Duration(rate.Capacity) * rate.Refill
+		fmt.Fprintf(&b, "%d;w=%d", rate.Capacity, max(int64(window.Round(time.Second)/time.Second), 1))
+	}
+	return b.String()
+}
+
+func (l *Limiter) response(status int, body any, detail string, retryAfter time.Duration) Response {
+	if l.problem != nil {
+		return l.problem.render(status, detail, retryAfter)
+	}
+	r := Response{Status: status, Header: make(http.Header)}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return r
+	}
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	r.Body = data
+	return r
+}
@@ -0,0 +1,148 @@
+package gincage
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// Actions of rules, see Rule.Action
+const (
+	// Limits matching requests with rate of rule
+	RuleLimit = "limit"
+	// Matching requests bypass limiter
+	RuleSkip = "skip"
+	// Rejects matching requests without storage calls
+	RuleDeny = "deny"
+)
+
+// Rule: action applied to requests matching all matchers of rule,
+// see Config.Rules. Empty matchers match all requests
+type Rule struct {
+	// Rule name reported in stats, scopes keys of limit rules.
+	// If empty, "rules[i]" is used
+	Name string `json:"name,omitempty"`
+	// Request paths: exact paths, globs, "/**" prefixes
+	// or "re:" regular expressions (see WithSkipPaths)
+	Paths []string `json:"paths,omitempty"`
+	// HTTP methods
+	Methods []string `json:"methods,omitempty"`
+	// Allowed values by header name. Request should have every header
+	// with one of values, empty values match any non-empty header.
+	// Adapters without headers (see Request.Header) never match
+	Headers map[string][]string `json:"headers,omitempty"`
+	// Client ips and CIDRs
+	CIDRs []string `json:"cidrs,omitempty"`
+	// Client plans, see Request.Plan
+	Plans []string `json:"plans,omitempty"`
+	// RuleLimit, RuleSkip or RuleDeny
+	Action string `json:"action"`
+	// Limit of limit rule. Matching requests of client share tokens
+	// of rule, route rules don't apply to them.
+	// Zero fields are taken from key limits, plan and Config.Rate
+	Rate Rate `json:"rate"`
+
+	// parsed Paths and CIDRs
+	paths *pathMatcher
+	cidrs []netip.Prefix
+}
+
+// Returns first rule matching request
+func (c *Config) matchRule(req Request) (Rule, bool) {
+	if len(c.Rules) == 0 {
+		return Rule{}, false
+	}
+	var addr netip.Addr
+	if a, err := netip.ParseAddr(req.IP); err == nil {
+		addr = a.Unmap()
+	}
+	for _, r := range c.Rules {
+		if r.match(req, addr) {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+func (r Rule) match(req Request, addr netip.Addr) bool {
+	if r.paths != nil && !r.paths.match(req.Path) {
+		return false
+	}
+	if len(r.Methods) > 0 && !slices.Contains(r.Methods, req.Method) {
+		return false
+	}
+	if len(r.Plans) > 0 && !slices.Contains(r.Plans, req.Plan) {
+		return false
+	}
+	if len(r.cidrs) > 0 && !slices.ContainsFunc(r.cidrs, func(p netip.Prefix) bool {
+		return addr.IsValid() && p.Contains(addr)
+	}) {
+		return false
+	}
+	for name, values := range r.Headers {
+		if req.Header == nil {
+			return false
+		}
+		v := req.Header(name)
+		if v == "" || (len(values) > 0 && !slices.Contains(values, v)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Counts request matched by rule
+func (l *Limiter) ruleMatched(r Rule) {
+	l.stats.rule(r.Name)
+}
+
+// Parses paths and CIDRs of r
+func (r *Rule) compile() error {
+	r.paths, r.cidrs = nil, nil
+	if len(r.Paths) > 0 {
+		m, err := newPathMatcher(r.Paths)
+		if err != nil {
+			return fmt.Errorf("paths%w", err)
+		}
+		r.paths = m
+	}
+	for i, c := range r.CIDRs {
+		p, err := parsePrefix(c)
+		if err != nil {
+			return fmt.Errorf("cidrs[%d]: %w", i, err)
+		}
+		r.cidrs = append(r.cidrs, p)
+	}
+	return nil
+}
+
+func (r Rule) validate() error {
+	if strings.HasPrefix(r.Name, "/") || strings.Contains(r.Name, "|") {
+		return errors.New("name: should not start with / or contain |")
+	}
+	switch r.Action {
+	case RuleLimit:
+	case RuleSkip, RuleDeny:
+		if r.Rate != (Rate{}) {
+			return fmt.Errorf("rate: should be empty for %s action", r.Action)
+		}
+	case "":
+		return errors.New("action: should not be empty")
+	default:
+		return fmt.Errorf("action: unknown action %q", r.Action)
+	}
+	for name := range r.Headers {
+		if name == "" {
+			return errors.New("headers: names should not be empty")
+		}
+	}
+	if err := r.compile(); err != nil {
+		return err
+	}
+	if err := r.Rate.validate(); err != nil {
+		return fmt.Errorf("rate.%w", err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package gincage
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Schedule: limits applied during window of day, e.g. stricter ones
+// during business-hours peak. First active schedule of Config wins
+type Schedule struct {
+	// Schedule name reported in admin API. If empty, "schedules[i]" is used
+	Name string `json:"name,omitempty"`
+	// Days of week ("mon", "tue", ...). Empty matches every day.
+	// Window spanning midnight belongs to day it starts
+	Days []string `json:"days,omitempty"`
+	// Start and end of window ("09:00", "18:00") in Timezone.
+	// Window ending before start spans midnight, equal ones last whole day
+	From string `json:"from"`
+	To   string `json:"to"`
+	// IANA time zone ("Europe/Berlin"). If empty, UTC is used
+	Timezone string `json:"timezone,omitempty"`
+	// Limit of all routes without rule during window, replaces Config.Rate.
+	// Zero fields are taken from Config.Rate
+	Rate Rate `json:"rate"`
+	// Limit of all requests during window, replaces Config.Global.
+	// Zero fields are taken from Config.Global
+	Global Rate `json:"global,omitzero"`
+
+	// parsed fields
+	loc      *time.Location
+	from, to int
+	days     uint8
+}
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Parses fields of s
+func (s *Schedule) compile() error {
+	var err error
+	if s.from, err = parseClock(s.From); err != nil {
+		return fmt.Errorf("from: %w", err)
+	}
+	if s.to, err = parseClock(s.To); err != nil {
+		return fmt.Errorf("to: %w", err)
+	}
+	if s.loc, err = time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("timezone: %w", err)
+	}
+	s.days = 0
+	for i, d := range s.Days {
+		day := slices.Index(weekdays, strings.ToLower(d))
+		if day < 0 {
+			return fmt.Errorf("days[%d]: unknown day %q", i, d)
+		}
+		s.days |= 1 << day
+	}
+	if err := s.Rate.validate(); err != nil {
+		return fmt.Errorf("rate.%w", err)
+	}
+	if err := s.Global.validate(); err != nil {
+		return fmt.Errorf("global.%w", err)
+	}
+	return nil
+}
+
+// Parses time of day "HH:MM" to minutes
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("should be time of day like 09:30")
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Returns true if now is in window of s
+func (s Schedule) active(now time.Time) bool {
+	t := now.In(s.loc)
+	m, day := t.Hour()*60+t.Minute(), t.Weekday()
+	switch {
+	case s.from < s.to:
+		if m < s.from || m >= s.to {
+			return false
+		}
+	case m < s.to:
+		// part of window after midnight
+		day = (day + 6) % 7
+	case m < s.from:
+		return false
+	}
+	return s.days == 0 || s.days&(1<<day) != 0
+}
+
+// Returns config with limits of schedule active at now,
+// c itself if there is none
+func (c *Config) at(now time.Time) *Config {
+	for _, s := range c.Schedules {
+		if !s.active(now) {
+			continue
+		}
+		scheduled := *c
+		scheduled.Rate = s.Rate.withDefaults(c.Rate)
+		if s.Global != (Rate{}) {
+			scheduled.Global = s.Global.withDefaults(c.Global)
+		}
+		return &scheduled
+	}
+	return c
+}
+
+// Returns name of schedule active at now, empty if there is none
+func (c *Config) activeSchedule(now time.Time) string {
+	for _, s := range c.Schedules {
+		if s.active(now) {
+			return s.Name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,95 @@
+package gincage
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+)
+
+// Schema versions of keys stored in redis:
+//
+// - 1: hash with tokens, refilled_at and version fields, or value
+// encoded by codec. Values of older releases ("tokens|RFC3339") are read as 1
+//
+// - 2: same layouts marked with version: hash has schema field,
+// value is prefixed with "v2:"
+//
+// Keys of every known version are read and rewritten in schema
+// of bucket on next write. Format changes get new version, so instances
+// don't misread keys written by newer ones.
+//
+// Keys are written in SchemaV1 by default, so releases unaware of versions
+// read them. Newer schema is written if BucketConfigs.Schema sets it,
+// after every instance reads it
+const (
+	SchemaV1 = 1
+	SchemaV2 = 2
+	// Schema of keys written by default
+	StorageSchema = SchemaV1
+	// Newest schema read by buckets
+	LatestSchema = SchemaV2
+)
+
+// Returned when key was written in schema newer than known by bucket
+// (by instance of newer release)
+var ErrUnknownSchema = errors.New("unknown schema of stored key")
+
+const fieldSchema = "schema"
+
+// Returns schema of bucket cfg, SchemaV1 <= schema <= LatestSchema
+func schemaOf(cfg BucketConfigs) int {
+	if cfg.Schema <= 0 {
+		return StorageSchema
+	}
+	return min(cfg.Schema, LatestSchema)
+}
+
+// Splits stored value into schema version and payload.
+// Values without prefix have SchemaV1
+func splitSchema(data []byte) (int, []byte, error) {
+	rest, ok := bytes.CutPrefix(data, []byte("v"))
+	if !ok {
+		return SchemaV1, data, nil
+	}
+	v, payload, ok := bytes.Cut(rest, []byte(":"))
+	if !ok {
+		return SchemaV1, data, nil
+	}
+	version, err := strconv.Atoi(string(v))
+	if err != nil || version <= SchemaV1 {
+		// payload of custom codec
+		return SchemaV1, data, nil
+	}
+	if version > LatestSchema {
+		return 0, nil, ErrUnknownSchema
+	}
+	return version, payload, nil
+}
+
+// Returns value of schema with payload
+func appendSchema(schema int, payload []byte) []byte {
+	if schema <= SchemaV1 {
+		return payload
+	}
+	b := make([]byte, 0, len(payload)+4)
+	b = append(b, 'v')
+	b = strconv.AppendInt(b, int64(schema), 10)
+	b = append(b, ':')
+	return append(b, payload...)
+}
+
+// Returns schema of stored hash. Hashes without schema field have SchemaV1
+func hashSchema(fields map[string]string) (int, error) {
+	s, ok := fields[fieldSchema]
+	if !ok {
+		return SchemaV1, nil
+	}
+	version, err := strconv.Atoi(s)
+	if err != nil || version < SchemaV1 {
+		return 0, ErrBadSyntaxInStorage
+	}
+	if version > LatestSchema {
+		return 0, ErrUnknownSchema
+	}
+	return version, nil
+}
@@ -0,0 +1,78 @@
+package gincage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ScopeRule: limit of clients whose token has matching OAuth scope,
+// see Config.Scopes
+type ScopeRule struct {
+	// Scope like "admin:users" or glob like "read:*" (see path.Match)
+	Scope string `json:"scope"`
+	// Limit of matching clients. Zero fields are taken from plan.
+	// Key limits, geo and agent rules override it
+	Rate Rate `json:"rate"`
+}
+
+// ScopeMetrics can be implemented by Metrics to count
+// requests by matched scope rule
+type ScopeMetrics interface {
+	ScopeMatched(scope string, allowed bool)
+}
+
+type scopesKey struct{}
+
+// Returns copy of ctx carrying OAuth scopes of client token.
+// Auth middleware sets them, so limiter applies Config.Scopes
+func ContextWithScopes(ctx context.Context, scopes []string) context.Context {
+	return context.WithValue(ctx, scopesKey{}, scopes)
+}
+
+// Returns scopes attached to ctx or nil
+func ScopesFromContext(ctx context.Context) []string {
+	s, _ := ctx.Value(scopesKey{}).([]string)
+	return s
+}
+
+// Attaches OAuth scopes of client token to request handled by gin,
+// should be called by auth middleware before limiter
+func SetScopes(ctx *gin.Context, scopes ...string) {
+	ctx.Request = ctx.Request.WithContext(ContextWithScopes(ctx.Request.Context(), scopes))
+}
+
+// Returns first scope rule matching any of scopes
+func (c *Config) matchScope(scopes []string) (ScopeRule, bool) {
+	for _, r := range c.Scopes {
+		for _, s := range scopes {
+			if ok, _ := path.Match(r.Scope, s); ok {
+				return r, true
+			}
+		}
+	}
+	return ScopeRule{}, false
+}
+
+// Counts request matched by scope rule
+func (l *Limiter) scopeMatched(r ScopeRule, allowed bool) {
+	if m, ok := l.metrics.(ScopeMetrics); ok {
+		m.ScopeMatched(r.Scope, allowed)
+	}
+}
+
+func (r ScopeRule) validate() error {
+	if r.Scope == "" {
+		return errors.New("scope: should not be empty")
+	}
+	if _, err := path.Match(r.Scope, ""); err != nil {
+		return fmt.Errorf("scope: %w", err)
+	}
+	if err := r.Rate.validate(); err != nil {
+		return fmt.Errorf("rate.%w", err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package gincage
+
+import "hash/fnv"
+
+// Default header marking requests which would be rejected in shadow mode
+const DefaultShadowHeader = "X-RateLimit-Shadow"
+
+// ShadowMetrics can be implemented by Metrics to count
+// requests which would be rejected in shadow mode (see Config.Shadow)
+type ShadowMetrics interface {
+	ShadowRejected()
+}
+
+// Marks responses to requests which would be rejected in shadow mode
+// with header name ("limited" value). If name is empty, DefaultShadowHeader is used
+func WithShadowHeader(name string) Option {
+	return func(l *Limiter) {
+		if name == "" {
+			name = DefaultShadowHeader
+		}
+		l.shadowHeader = name
+	}
+}
+
+// Lets request rejected in shadow mode go on.
+// Would-be rejection is logged and counted, storage errors pass silently
+func (l *Limiter) shadowed(req Request, d Decision) Decision {
+	if !d.Limited() {
+		return Decision{Allowed: true}
+	}
+	l.metrics.Allowed()
+	l.stats.allowed.Add(1)
+	l.stats.shadowed.Add(1)
+	l.track(req.Key, true)
+	if m, ok := l.metrics.(ShadowMetrics); ok {
+		m.ShadowRejected()
+	}
+	l.log(req.RequestID).Info("request would be rejected",
+		F("key", req.Key),
+		F("path", req.Path),
+		F("retry_after", d.RetryAfter),
+	)
+	l.hooks.emit(hookAllow, l.newEvent(req))
+	return Decision{
+		Allowed:    true,
+		Shadow:     true,
+		RetryAfter: d.RetryAfter,
+		Limit:      d.Limit,
+		Remaining:  d.Remaining,
+		Policy:     d.Policy,
+	}
+}
+
+// Returns true if limits of key are enforced, see Config.EnforcePercent
+func (c *Config) enforced(key string) bool {
+	if c.EnforcePercent <= 0 || c.EnforcePercent >= 100 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	// hundredths of percent
+	return float64(h.Sum64()%10000) < c.EnforcePercent*100
+}
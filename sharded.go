@@ -0,0 +1,303 @@
+package gincage
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"time"
+)
+
+// ShardedBucket: keys spread over independent buckets (e.g. single-node
+// redises) by rendezvous hashing, so storage scales horizontally without
+// redis cluster. Adding or removing shard moves only keys of that shard,
+// moved keys start with full capacity.
+//
+// Every key lives on one shard, so extensions (Peeker, Syncer, Banner, ...)
+// work if shards implement them, otherwise errors.ErrUnsupported is returned.
+// Batch walks aren't atomic across shards, so keys are taken one by one
+type ShardedBucket struct {
+	shards []bucketShard
+}
+
+type bucketShard struct {
+	name   string
+	seed   uint64
+	bucket Bucket
+}
+
+// Returns bucket spreading keys over shards by their names.
+// Names should be stable (e.g. host:port), same names
+// on all instances place keys on same shards
+func NewShardedBucket(shards map[string]Bucket) (Bucket, error) {
+	if len(shards) == 0 {
+		return nil, errors.New("sharded bucket: no shards")
+	}
+	b := &ShardedBucket{}
+	for name, s := range shards {
+		b.shards = append(b.shards, bucketShard{name: name, seed: hashString(name), bucket: s})
+	}
+	// stable order of Scan and Bans
+	slices.SortFunc(b.shards, func(x, y bucketShard) int {
+		return cmp.Compare(x.name, y.name)
+	})
+	return b, nil
+}
+
+// Returns shard of key
+func (b *ShardedBucket) shard(key string) Bucket {
+	return b.shards[b.shardIndex(key)].bucket
+}
+
+// Returns index of shard with highest score for key
+func (b *ShardedBucket) shardIndex(key string) int {
+	h := hashString(key)
+	best, score := 0, uint64(0)
+	for i, s := range b.shards {
+		if v := mix64(h ^ s.seed); i == 0 || v > score {
+			best, score = i, v
+		}
+	}
+	return best
+}
+
+// Returns extension T of shard of key
+func shardAs[T any](b *ShardedBucket, key string) (T, error) {
+	t, ok := BucketAs[T](b.shard(key))
+	if !ok {
+		return t, errors.ErrUnsupported
+	}
+	return t, nil
+}
+
+// Takes n tokens of key from its shard
+func (b *ShardedBucket) Take(ctx context.Context, key string, n int) (Result, error) {
+	return b.shard(key).Take(ctx, key, n)
+}
+
+// Closes all shards
+func (b *ShardedBucket) Close() error {
+	var errs []error
+	for _, s := range b.shards {
+		errs = append(errs, s.bucket.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Returns limits of first shard
+func (b *ShardedBucket) Rate() Rate {
+	if r, ok := BucketAs[RateReporter](b.shards[0].bucket); ok {
+		return r.Rate()
+	}
+	return Rate{}
+}
+
+// Returns tokens of key from its shard
+func (b *ShardedBucket) Peek(ctx context.Context, key string) (KeyState, error) {
+	p, err := shardAs[Peeker](b, key)
+	if err != nil {
+		return KeyState{}, err
+	}
+	return p.Peek(ctx, key)
+}
+
+// Restores full capacity of key on its shard
+func (b *ShardedBucket) Reset(ctx context.Context, key string) error {
+	r, err := shardAs[Resetter](b, key)
+	if err != nil {
+		return err
+	}
+	return r.Reset(ctx, key)
+}
+
+// Adds n tokens to key on its shard
+func (b *ShardedBucket) Credit(ctx context.Context, key string, n int) (KeyState, error) {
+	c, err := shardAs[Crediter](b, key)
+	if err != nil {
+		return KeyState{}, err
+	}
+	return c.Credit(ctx, key, n)
+}
+
+// Books n tokens of key on its shard
+func (b *ShardedBucket) Book(ctx context.Context, key string, n int, at time.Time) (time.Time, error) {
+	bk, err := shardAs[Booker](b, key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return bk.Book(ctx, key, n, at)
+}
+
+// Reserves pacing slot of key on its shard
+func (b *ShardedBucket) Reserve(ctx context.Context, key string, interval, maxDelay time.Duration) (time.Duration, error) {
+	p, err := shardAs[Pacer](b, key)
+	if err != nil {
+		return 0, err
+	}
+	return p.Reserve(ctx, key, interval, maxDelay)
+}
+
+// Applies updates on their shards, one call per shard
+func (b *ShardedBucket) Sync(ctx context.Context, updates []SyncUpdate) ([]KeyState, error) {
+	byShard := make(map[int][]int)
+	for i, u := range updates {
+		s := b.shardIndex(u.Object)
+		byShard[s] = append(byShard[s], i)
+	}
+
+	result := make([]KeyState, len(updates))
+	for s, idx := range byShard {
+		syncer, ok := BucketAs[Syncer](b.shards[s].bucket)
+		if !ok {
+			return nil, errors.ErrUnsupported
+		}
+		batch := make([]SyncUpdate, len(idx))
+		for j, i := range idx {
+			batch[j] = updates[i]
+		}
+		states, err := syncer.Sync(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		for j, i := range idx {
+			result[i] = states[j]
+		}
+	}
+	return result, nil
+}
+
+// Registers violation of key on its shard
+func (b *ShardedBucket) AddViolation(ctx context.Context, key string, window time.Duration) (int, error) {
+	bn, err := shardAs[Banner](b, key)
+	if err != nil {
+		return 0, err
+	}
+	return bn.AddViolation(ctx, key, window)
+}
+
+// Bans key on its shard
+func (b *ShardedBucket) Ban(ctx context.Context, key string, d time.Duration) error {
+	bn, err := shardAs[Banner](b, key)
+	if err != nil {
+		return err
+	}
+	return bn.Ban(ctx, key, d)
+}
+
+// Removes ban of key on its shard
+func (b *ShardedBucket) Unban(ctx context.Context, key string) error {
+	bn, err := shardAs[Banner](b, key)
+	if err != nil {
+		return err
+	}
+	return bn.Unban(ctx, key)
+}
+
+// Returns time when ban of key ends from its shard
+func (b *ShardedBucket) BannedUntil(ctx context.Context, key string) (time.Time, error) {
+	bn, err := shardAs[Banner](b, key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return bn.BannedUntil(ctx, key)
+}
+
+// Returns bans of all shards
+func (b *ShardedBucket) Bans(ctx context.Context) ([]BanInfo, error) {
+	var bans []BanInfo
+	for _, s := range b.shards {
+		bn, ok := BucketAs[Banner](s.bucket)
+		if !ok {
+			return nil, errors.ErrUnsupported
+		}
+		sb, err := bn.Bans(ctx)
+		if err != nil {
+			return nil, err
+		}
+		bans = append(bans, sb...)
+	}
+	return bans, nil
+}
+
+// Returns count of keys of all shards
+func (b *ShardedBucket) ActiveKeys(ctx context.Context) (int, error) {
+	total := 0
+	for _, s := range b.shards {
+		c, ok := BucketAs[KeyCounter](s.bucket)
+		if !ok {
+			return 0, errors.ErrUnsupported
+		}
+		n, err := c.ActiveKeys(ctx)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// Pings all shards, shards without HealthChecker are healthy
+func (b *ShardedBucket) Ping(ctx context.Context) error {
+	var errs []error
+	for _, s := range b.shards {
+		h, ok := BucketAs[HealthChecker](s.bucket)
+		if !ok {
+			continue
+		}
+		if err := h.Ping(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("shard %s: %w", s.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Scans shards one by one
+func (b *ShardedBucket) Scan(ctx context.Context, fn func(e SnapshotEntry) error) error {
+	for _, s := range b.shards {
+		sc, ok := BucketAs[Scanner](s.bucket)
+		if !ok {
+			return errors.ErrUnsupported
+		}
+		if err := sc.Scan(ctx, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes entries to their shards
+func (b *ShardedBucket) Restore(ctx context.Context, entries []SnapshotEntry) error {
+	byShard := make(map[int][]SnapshotEntry)
+	for _, e := range entries {
+		s := b.shardIndex(e.Key)
+		byShard[s] = append(byShard[s], e)
+	}
+	for s, batch := range byShard {
+		r, ok := BucketAs[Restorer](b.shards[s].bucket)
+		if !ok {
+			return errors.ErrUnsupported
+		}
+		if err := r.Restore(ctx, batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// Finalizer of splitmix64, spreads close hashes over all bits
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,142 @@
+package gincage
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Prefix of skip patterns holding regular expressions
+const regexpPrefix = "re:"
+
+// Matches request paths bypassing limiter
+type pathMatcher struct {
+	exact  map[string]bool
+	globs  []string
+	prefix []string
+	res    []*regexp.Regexp
+}
+
+// Parses skip patterns:
+//
+// - "/healthz": exact path
+//
+// - "/static/*.css": glob (see path.Match), "*" doesn't match "/"
+//
+// - "/static/**": every path with "/static/" prefix
+//
+// - "re:^/api/v[0-9]+/health$": regular expression
+func newPathMatcher(patterns []string) (*pathMatcher, error) {
+	m := &pathMatcher{exact: make(map[string]bool)}
+	for i, p := range patterns {
+		switch {
+		case strings.HasPrefix(p, regexpPrefix):
+			re, err := regexp.Compile(strings.TrimPrefix(p, regexpPrefix))
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			m.res = append(m.res, re)
+		case strings.HasSuffix(p, "/**"):
+			m.prefix = append(m.prefix, strings.TrimSuffix(p, "**"))
+		case strings.ContainsAny(p, "*?["):
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			m.globs = append(m.globs, p)
+		case p == "":
+			return nil, fmt.Errorf("[%d]: should not be empty", i)
+		default:
+			m.exact[p] = true
+		}
+	}
+	return m, nil
+}
+
+// Returns true if p matches any pattern
+func (m *pathMatcher) match(p string) bool {
+	if m == nil {
+		return false
+	}
+	if m.exact[p] {
+		return true
+	}
+	for _, prefix := range m.prefix {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	for _, g := range m.globs {
+		if ok, _ := path.Match(g, p); ok {
+			return true
+		}
+	}
+	for _, re := range m.res {
+		if re.MatchString(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Makes requests with matching paths bypass limiter without storage calls
+// (health checks, metrics, static assets). Patterns are exact paths,
+// globs ("/static/*.css", "/static/**") or regular expressions
+// with "re:" prefix. Invalid patterns are ignored with error log.
+//
+// Paths can also be set with Config.SkipPaths and changed at runtime
+func WithSkipPaths(patterns ...string) Option {
+	return func(l *Limiter) {
+		m, err := newPathMatcher(patterns)
+		if err != nil {
+			l.logger.Error("invalid skip paths, ignored", F("error", err))
+			return
+		}
+		l.skip = m
+	}
+}
+
+// SkipFunc: returns true if request should bypass limiter
+type SkipFunc func(ctx *gin.Context) bool
+
+// HTTPSkipFunc: returns true if net/http request should bypass limiter
+type HTTPSkipFunc func(r *http.Request) bool
+
+// Makes requests bypass limiter when f returns true (internal service
+// headers, authenticated admins, ...). Can be set several times,
+// request is skipped if any function returns true.
+// Used by gin middleware, see WithHTTPSkipFunc for net/http
+func WithSkipFunc(f SkipFunc) Option {
+	return func(l *Limiter) {
+		if f != nil {
+			l.skipFuncs = append(l.skipFuncs, f)
+		}
+	}
+}
+
+// Same as WithSkipFunc for net/http middleware (see Middleware)
+func WithHTTPSkipFunc(f HTTPSkipFunc) Option {
+	return func(l *Limiter) {
+		if f != nil {
+			l.httpSkipFuncs = append(l.httpSkipFuncs, f)
+		}
+	}
+}
+
+// Skips CORS preflight requests, they are sent by browsers
+// in addition to real requests
+func SkipPreflight(ctx *gin.Context) bool {
+	return ctx.Request != nil && isPreflight(ctx.Request)
+}
+
+// Same as SkipPreflight for net/http requests
+func SkipHTTPPreflight(r *http.Request) bool {
+	return isPreflight(r)
+}
+
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+}
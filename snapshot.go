@@ -0,0 +1,121 @@
+package gincage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// SnapshotEntry: stored state of key, one line of snapshot (see Export)
+type SnapshotEntry struct {
+	Key    string `json:"key"`
+	Tokens int    `json:"tokens"`
+	// Time of last tokens append, tokens earned since then aren't added
+	RefilledAt time.Time `json:"refilled_at"`
+	// Zero if key doesn't expire
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+// Scanner can be implemented by Bucket to list stored keys
+type Scanner interface {
+	// Calls fn for every stored key until fn returns error.
+	// Keys changed while scanning may be reported with old or new state
+	Scan(ctx context.Context, fn func(e SnapshotEntry) error) error
+}
+
+// Restorer can be implemented by Bucket to write keys of snapshot
+type Restorer interface {
+	// Replaces state of keys. Entries which are expired are skipped,
+	// entries without expiration time get TTL of bucket
+	Restore(ctx context.Context, entries []SnapshotEntry) error
+}
+
+// Count of snapshot entries restored at once
+const restoreBatch = 500
+
+// Writes state of all keys of bucket to w as JSON lines:
+//
+//	{"key":"10.0.0.1","tokens":3,"refilled_at":"2024-05-01T10:00:00Z","expires_at":"2024-05-01T10:30:00Z"}
+//
+// so it can be inspected, backed up or moved to other backend.
+// Bucket should implement Scanner, otherwise errors.ErrUnsupported is returned
+func (l *Limiter) Export(ctx context.Context, w io.Writer) error {
+	scanner, ok := BucketAs[Scanner](l.bucket)
+	if !ok {
+		return fmt.Errorf("bucket doesn't list keys: %w", errors.ErrUnsupported)
+	}
+	// include tokens buffered by write-behind and broadcast buckets
+	if f, ok := BucketAs[Flusher](l.bucket); ok {
+		if err := f.Flush(ctx); err != nil {
+			return err
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	var n int
+	err := scanner.Scan(ctx, func(e SnapshotEntry) error {
+		n++
+		return enc.Encode(e)
+	})
+	if err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	l.logger.Info("bucket state exported", F("keys", n))
+	return nil
+}
+
+// Restores keys from snapshot written by Export, e.g. to warm up new
+// backend after failover, so clients don't get full burst at once.
+// Stored keys are replaced by keys of snapshot, other keys are kept.
+//
+// Keys keep time of their last refill, so tokens earned between export
+// and import are appended on next take; keys expired meanwhile are skipped.
+// Bucket should implement Restorer, otherwise errors.ErrUnsupported is returned
+func (l *Limiter) Import(ctx context.Context, r io.Reader) error {
+	restorer, ok := BucketAs[Restorer](l.bucket)
+	if !ok {
+		return fmt.Errorf("bucket doesn't restore keys: %w", errors.ErrUnsupported)
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+	batch := make([]SnapshotEntry, 0, restoreBatch)
+	var n int
+	for i := 1; ; i++ {
+		var e SnapshotEntry
+		err := dec.Decode(&e)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("entry %d: %w", i, err)
+		}
+		if e.Key == "" {
+			return fmt.Errorf("entry %d: key should not be empty", i)
+		}
+		batch = append(batch, e)
+		if len(batch) < restoreBatch {
+			continue
+		}
+		if err := restorer.Restore(ctx, batch); err != nil {
+			return err
+		}
+		n += len(batch)
+		batch = batch[:0]
+	}
+	if len(batch) > 0 {
+		if err := restorer.Restore(ctx, batch); err != nil {
+			return err
+		}
+		n += len(batch)
+	}
+	l.logger.Info("bucket state imported", F("keys", n))
+	return nil
+}
@@ -0,0 +1,195 @@
+package gincage
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WalkStats: details of one Walk call filled by bucket implementations.
+//
+// Decorators (tracing, metrics) attach it to request context
+// with ContextWithWalkStats before calling underlying bucket
+type WalkStats struct {
+	// Storage backend name (redis, memory, ...)
+	Backend string
+	// Count of retries caused by concurrent updates of the same key
+	Retries int
+}
+
+type walkStatsKey struct{}
+
+// Returns copy of ctx carrying s
+func ContextWithWalkStats(ctx context.Context, s *WalkStats) context.Context {
+	return context.WithValue(ctx, walkStatsKey{}, s)
+}
+
+// Returns stats attached to ctx or nil
+func WalkStatsFromContext(ctx context.Context) *WalkStats {
+	s, _ := ctx.Value(walkStatsKey{}).(*WalkStats)
+	return s
+}
+
+// Stats: limiter totals since start
+type Stats struct {
+	// Time of limiter creation
+	Since    time.Time
+	Allowed  uint64
+	Rejected uint64
+	Errored  uint64
+	// Health of storage backends by name
+	Backends map[string]BackendStats
+	// True if storage isn't called because of circuit breaker
+	CircuitOpen bool
+	// True if limits are tightened because of process load, see WithLoadShedding
+	Overloaded bool
+	// True if requests are limited by fallback or secondary bucket
+	// because storage failed, see FailLocal and WithSecondary
+	Degraded bool
+	// Requests matched by agent rules by rule name
+	AgentRules map[string]uint64
+	// Requests matched by rules by rule name, see Config.Rules
+	Rules map[string]uint64
+	// Requests of network classes by class name, see Config.Networks
+	Networks map[string]uint64
+	// Allowed requests which would be rejected, see Config.Shadow
+	Shadowed uint64
+	// Rejected requests not checked by storage, see WithAdmissionFilter.
+	// Counted in Rejected too
+	AdmissionRejected uint64
+	// Count of switches to local limiting because storage
+	// was unavailable, see FailLocal
+	Fallbacks uint64
+	// Rejections delayed by tarpit, see WithTarpit
+	Tarpitted uint64
+}
+
+// BackendStats: storage backend health
+type BackendStats struct {
+	Calls  uint64
+	Errors uint64
+	// Retries on concurrent updates of keys
+	Retries uint64
+	// Calls timed out, counted in Errors too
+	Timeouts      uint64
+	LastError     string
+	LastErrorAt   time.Time
+	LastSuccessAt time.Time
+	// False if last call failed
+	Healthy bool
+}
+
+type statsCounter struct {
+	since    time.Time
+	allowed  atomic.Uint64
+	rejected atomic.Uint64
+	errored  atomic.Uint64
+	shadowed atomic.Uint64
+	// requests rejected by admission filter
+	admission atomic.Uint64
+	// switches to local limiting
+	fallbacks atomic.Uint64
+	// rejections delayed by tarpit
+	tarpitted atomic.Uint64
+
+	mu       sync.Mutex
+	backends map[string]*BackendStats
+	agents   map[string]uint64
+	rules    map[string]uint64
+	networks map[string]uint64
+}
+
+func newStatsCounter() *statsCounter {
+	return &statsCounter{
+		since:    time.Now(),
+		backends: make(map[string]*BackendStats),
+		agents:   make(map[string]uint64),
+		rules:    make(map[string]uint64),
+		networks: make(map[string]uint64),
+	}
+}
+
+// Counts request matched by agent rule
+func (s *statsCounter) agentRule(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.agents[name]++
+}
+
+// Counts request matched by rule
+func (s *statsCounter) rule(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules[name]++
+}
+
+// Counts request of network class
+func (s *statsCounter) network(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.networks[name]++
+}
+
+// Records storage call result. Rejection isn't failure of backend
+func (s *statsCounter) backendCall(c StorageCall, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.backends[c.Backend]
+	if !ok {
+		b = &BackendStats{}
+		s.backends[c.Backend] = b
+	}
+	b.Calls++
+	b.Retries += uint64(c.Retries)
+	if c.Timeout {
+		b.Timeouts++
+	}
+	if c.Failed {
+		b.Errors++
+		b.LastError = err.Error()
+		b.LastErrorAt = time.Now()
+		b.Healthy = false
+		return
+	}
+	b.LastSuccessAt = time.Now()
+	b.Healthy = true
+}
+
+func (s *statsCounter) snapshot() Stats {
+	st := Stats{
+		Since:    s.since,
+		Allowed:  s.allowed.Load(),
+		Rejected: s.rejected.Load(),
+		Errored:  s.errored.Load(),
+		Shadowed: s.shadowed.Load(),
+
+		AdmissionRejected: s.admission.Load(),
+		Fallbacks:         s.fallbacks.Load(),
+		Tarpitted:         s.tarpitted.Load(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st.Backends = make(map[string]BackendStats, len(s.backends))
+	for name, b := range s.backends {
+		st.Backends[name] = *b
+	}
+	st.AgentRules = maps.Clone(s.agents)
+	st.Rules = maps.Clone(s.rules)
+	st.Networks = maps.Clone(s.networks)
+	return st
+}
+
+// Returns totals of requests processed by limiter since its creation
+// and health of storage backends.
+//
+// Counters are monotonic, diff two snapshots to get rates
+func (l *Limiter) Stats() Stats {
+	st := l.stats.snapshot()
+	st.CircuitOpen = l.breaker.isOpen()
+	st.Overloaded = l.load.isOverloaded()
+	st.Degraded = l.degraded.Load()
+	return st
+}
@@ -0,0 +1,141 @@
+package gincage
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var (
+	// Default rejections of key in window enabling tarpit
+	DefaultTarpitThreshold = 10
+	// Default window of rejection counters of tarpit
+	DefaultTarpitWindow = time.Duration(time.Minute)
+	// Default delay of tarpitted responses
+	DefaultTarpitDelay = time.Duration(5 * time.Second)
+	// Default max count of responses delayed at once
+	DefaultTarpitMaxConcurrent = 100
+	// Default max count of keys tracked by tarpit
+	DefaultTarpitMaxKeys = 10000
+)
+
+// TarpitPolicy: delayed rejections of keys far past their limits.
+//
+// Scrapers retry rejected requests at once, so rejections cost them
+// nothing. Tarpit holds connection of key rejected Threshold times
+// in window for Delay before returning 429, which slows them down.
+// Rejections are counted by process
+type TarpitPolicy struct {
+	// Rejections of key in window after which its responses are delayed.
+	// If <= 0, uses DefaultTarpitThreshold
+	Threshold int
+	// Window of rejection counters. If <= 0, uses DefaultTarpitWindow
+	Window time.Duration
+	// Delay of rejected responses, cut by request context.
+	// If <= 0, uses DefaultTarpitDelay
+	Delay time.Duration
+	// Max count of responses delayed at once, other rejections are
+	// returned at once, so held connections can't exhaust service.
+	// If <= 0, uses DefaultTarpitMaxConcurrent
+	MaxConcurrent int
+	// Max count of tracked keys, new keys aren't tracked when it's reached.
+	// If <= 0, uses DefaultTarpitMaxKeys
+	MaxKeys int
+}
+
+// Enables tarpit delaying rejections of keys far past their limits,
+// see TarpitPolicy. Requests in shadow mode aren't delayed
+func WithTarpit(p TarpitPolicy) Option {
+	return func(l *Limiter) {
+		if p.Threshold <= 0 {
+			p.Threshold = DefaultTarpitThreshold
+		}
+		if p.Window <= 0 {
+			p.Window = DefaultTarpitWindow
+		}
+		if p.Delay <= 0 {
+			p.Delay = DefaultTarpitDelay
+		}
+		if p.MaxConcurrent <= 0 {
+			p.MaxConcurrent = DefaultTarpitMaxConcurrent
+		}
+		if p.MaxKeys <= 0 {
+			p.MaxKeys = DefaultTarpitMaxKeys
+		}
+		l.tarpits = &tarpit{
+			policy: p,
+			slots:  make(chan struct{}, p.MaxConcurrent),
+			keys:   make(map[string]*tarpitCounter),
+		}
+	}
+}
+
+type tarpit struct {
+	policy TarpitPolicy
+	// taken by delayed responses
+	slots chan struct{}
+
+	mu   sync.Mutex
+	keys map[string]*tarpitCounter
+}
+
+// Rejections of key in window starting at start
+type tarpitCounter struct {
+	start    time.Time
+	rejected int
+}
+
+// Counts rejection of key. Returns true if key is far past its limit
+func (t *tarpit) record(key string) bool {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.keys[key]
+	if !ok {
+		if len(t.keys) >= t.policy.MaxKeys && !t.sweep(now) {
+			return false
+		}
+		c = &tarpitCounter{start: now}
+		t.keys[key] = c
+	}
+	if now.Sub(c.start) >= t.policy.Window {
+		c.start, c.rejected = now, 0
+	}
+	c.rejected++
+	return c.rejected > t.policy.Threshold
+}
+
+// Drops keys whose windows passed. Returns true if keys were dropped.
+// Should be called with lock held
+func (t *tarpit) sweep(now time.Time) bool {
+	n := len(t.keys)
+	for key, c := range t.keys {
+		if now.Sub(c.start) >= t.policy.Window {
+			delete(t.keys, key)
+		}
+	}
+	return len(t.keys) < n
+}
+
+// Counts rejection of request and delays response if its key
+// is far past limit and tarpit has free slot
+func (l *Limiter) holdTarpit(ctx context.Context, req Request) {
+	t := l.tarpits
+	if t == nil || req.shadow || !t.record(req.Key) {
+		return
+	}
+	select {
+	case t.slots <- struct{}{}:
+		defer func() { <-t.slots }()
+	default:
+		return
+	}
+
+	l.stats.tarpitted.Add(1)
+	timer := time.NewTimer(t.policy.Delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
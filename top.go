@@ -0,0 +1,160 @@
+package gincage
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// Default window of top consumers tracking
+	DefaultTopWindow = time.Duration(5 * time.Minute)
+	// Default max count of keys tracked in one window
+	DefaultTopMaxKeys = 10000
+)
+
+// Consumer: key with its recent usage
+type Consumer struct {
+	Key string `json:"key"`
+	// Requests walked through limiter
+	Consumed int `json:"consumed"`
+	// Requests rejected by limiter
+	Rejected int `json:"rejected"`
+}
+
+type consumerCounts struct {
+	consumed int
+	rejected int
+}
+
+// Counts requests per key locally in two rotating windows,
+// so top is built from last one or two windows
+type topTracker struct {
+	mu       sync.Mutex
+	window   time.Duration
+	maxKeys  int
+	start    time.Time
+	current  map[string]*consumerCounts
+	previous map[string]*consumerCounts
+}
+
+// Enables tracking of top consumers (TopConsumers, GET /top admin route).
+//
+// Usage is tracked locally in this process, so in multi-instance
+// deployments every instance reports its own traffic.
+// New keys aren't tracked when maxKeys is reached until window ends.
+// If window <= 0, uses DefaultTopWindow. If maxKeys <= 0, uses DefaultTopMaxKeys
+func WithTopConsumers(window time.Duration, maxKeys int) Option {
+	return func(l *Limiter) {
+		if window <= 0 {
+			window = DefaultTopWindow
+		}
+		if maxKeys <= 0 {
+			maxKeys = DefaultTopMaxKeys
+		}
+		l.top = &topTracker{
+			window:   window,
+			maxKeys:  maxKeys,
+			start:    time.Now(),
+			current:  make(map[string]*consumerCounts),
+			previous: make(map[string]*consumerCounts),
+		}
+	}
+}
+
+func (t *topTracker) add(key string, consumed, rejected int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rotate()
+	c, ok := t.current[key]
+	if !ok {
+		if len(t.current) >= t.maxKeys {
+			return
+		}
+		c = &consumerCounts{}
+		t.current[key] = c
+	}
+	c.consumed += consumed
+	c.rejected += rejected
+}
+
+func (t *topTracker) rotate() {
+	switch p := time.Since(t.start); {
+	case p >= 2*t.window:
+		t.previous = make(map[string]*consumerCounts)
+		t.current = make(map[string]*consumerCounts)
+		t.start = time.Now()
+	case p >= t.window:
+		t.previous = t.current
+		t.current = make(map[string]*consumerCounts)
+		t.start = t.start.Add(t.window)
+	}
+}
+
+func (t *topTracker) top(n int, byRejected bool) []Consumer {
+	t.mu.Lock()
+	t.rotate()
+	all := make(map[string]Consumer, len(t.current)+len(t.previous))
+	for _, m := range []map[string]*consumerCounts{t.previous, t.current} {
+		for key, c := range m {
+			s := all[key]
+			s.Key = key
+			s.Consumed += c.consumed
+			s.Rejected += c.rejected
+			all[key] = s
+		}
+	}
+	t.mu.Unlock()
+
+	consumers := make([]Consumer, 0, len(all))
+	for _, c := range all {
+		consumers = append(consumers, c)
+	}
+	sort.Slice(consumers, func(i, j int) bool {
+		a, b := consumers[i], consumers[j]
+		if byRejected && a.Rejected != b.Rejected {
+			return a.Rejected > b.Rejected
+		}
+		if a.Consumed+a.Rejected != b.Consumed+b.Rejected {
+			return a.Consumed+a.Rejected > b.Consumed+b.Rejected
+		}
+		return a.Key < b.Key
+	})
+	if n > 0 && len(consumers) > n {
+		consumers = consumers[:n]
+	}
+	return consumers
+}
+
+// Returns top n keys by recent requests (consumed + rejected).
+// If byRejected, keys are sorted by rejections first.
+// Returns nil if tracking isn't enabled with WithTopConsumers
+func (l *Limiter) TopConsumers(n int, byRejected bool) []Consumer {
+	if l.top == nil {
+		return nil
+	}
+	return l.top.top(n, byRejected)
+}
+
+// GET /top?n=10&by=rejected
+func (l *Limiter) adminTop(ctx *gin.Context) {
+	if l.top == nil {
+		ctx.AbortWithStatusJSON(http.StatusNotImplemented, adminError{Error: "top consumers tracking is disabled"})
+		return
+	}
+
+	n := 10
+	if v := ctx.Query("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n <= 0 {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, adminError{Error: "n should be positive integer"})
+			return
+		}
+	}
+	ctx.JSON(http.StatusOK, l.TopConsumers(n, ctx.Query("by") == "rejected"))
+}
@@ -0,0 +1,241 @@
+package gincage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Checks limits like UpdateConfig does and also rejects contradictory
+// ones: refill longer than ttl (keys expire before tokens are appended)
+// and global refill without capacity (global limit is disabled).
+// Errors name offending field, e.g. "routes[0].rate.refill: ..."
+func (c Config) Validate() error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	if c.Global.Capacity == 0 && c.Global.Refill > 0 {
+		return errors.New("global.capacity: should be set with refill, global limit is disabled otherwise")
+	}
+	if err := c.Rate.validateWindow(); err != nil {
+		return fmt.Errorf("rate.%w", err)
+	}
+	if err := c.Global.validateWindow(); err != nil {
+		return fmt.Errorf("global.%w", err)
+	}
+	for i, r := range c.Routes {
+		if err := r.Rate.withDefaults(c.Rate).validateWindow(); err != nil {
+			return fmt.Errorf("routes[%d].rate.%w", i, err)
+		}
+	}
+	for name, rate := range c.Groups {
+		if err := rate.withDefaults(c.Rate).validateWindow(); err != nil {
+			return fmt.Errorf("groups.%s.%w", name, err)
+		}
+	}
+	for name, rate := range c.Plans {
+		if err := rate.withDefaults(c.Rate).validateWindow(); err != nil {
+			return fmt.Errorf("plans.%s.%w", name, err)
+		}
+	}
+	for i, r := range c.GeoRules {
+		if err := r.Rate.withDefaults(c.Rate).validateWindow(); err != nil {
+			return fmt.Errorf("geo_rules[%d].rate.%w", i, err)
+		}
+	}
+	for i, r := range c.AgentRules {
+		if err := r.Rate.withDefaults(c.Rate).validateWindow(); err != nil {
+			return fmt.Errorf("agent_rules[%d].rate.%w", i, err)
+		}
+	}
+	for i, n := range c.Networks {
+		if err := n.Rate.withDefaults(c.Rate).validateWindow(); err != nil {
+			return fmt.Errorf("networks[%d].rate.%w", i, err)
+		}
+	}
+	for i, r := range c.Scopes {
+		if err := r.Rate.withDefaults(c.Rate).validateWindow(); err != nil {
+			return fmt.Errorf("scopes[%d].rate.%w", i, err)
+		}
+	}
+	for i, r := range c.Rules {
+		if err := r.Rate.withDefaults(c.Rate).validateWindow(); err != nil {
+			return fmt.Errorf("rules[%d].rate.%w", i, err)
+		}
+	}
+	for i, s := range c.Schedules {
+		if err := s.Rate.withDefaults(c.Rate).validateWindow(); err != nil {
+			return fmt.Errorf("schedules[%d].rate.%w", i, err)
+		}
+		if err := s.Global.withDefaults(c.Global).validateWindow(); err != nil {
+			return fmt.Errorf("schedules[%d].global.%w", i, err)
+		}
+	}
+	return nil
+}
+
+// Returns error if keys of rate expire before token is appended
+func (r Rate) validateWindow() error {
+	if r.Refill > 0 && r.TTL > 0 && r.Refill > r.TTL {
+		return fmt.Errorf("refill: %s is longer than ttl %s, keys would expire before tokens are appended", r.Refill, r.TTL)
+	}
+	return nil
+}
+
+// Checks settings constructors would silently replace with defaults
+// or which contradict each other. Zero fields mean defaults and are valid.
+// Errors name offending field, e.g. "tokens_exist: should not be negative"
+func (cfg BucketConfigs) Validate() error {
+	ints := []struct {
+		name  string
+		value int
+	}{
+		{"port", cfg.Port},
+		{"db", cfg.DB},
+		{"pool_size", cfg.PoolSize},
+		{"min_idle_conns", cfg.MinIdleConns},
+		{"capability", cfg.Capability},
+		{"max_retries", cfg.MaxRetries},
+		{"shards", cfg.Shards},
+		{"max_keys", cfg.MaxKeys},
+		{"schema", cfg.Schema},
+	}
+	for _, f := range ints {
+		if f.value < 0 {
+			return fmt.Errorf("%s: %w", f.name, errNegative)
+		}
+	}
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"dial_timeout", cfg.DialTimeout},
+		{"read_timeout", cfg.ReadTimeout},
+		{"write_timeout", cfg.WriteTimeout},
+		{"tokens_exist", cfg.TokensExist},
+		{"tokens_append_duration", cfg.TokensAppendDuration},
+		{"ttl_jitter", cfg.TTLJitter},
+	}
+	for _, f := range durations {
+		if f.value < 0 {
+			return fmt.Errorf("%s: %w", f.name, errNegative)
+		}
+	}
+
+	if cfg.Port > 65535 {
+		return errors.New("port: should be in [0, 65535]")
+	}
+	if cfg.Schema > LatestSchema {
+		return fmt.Errorf("schema: unknown version %d, use 1-%d", cfg.Schema, LatestSchema)
+	}
+	if cfg.PoolSize > 0 && cfg.MinIdleConns > cfg.PoolSize {
+		return fmt.Errorf("min_idle_conns: %d idle connections don't fit in pool of %d", cfg.MinIdleConns, cfg.PoolSize)
+	}
+	rate := Rate{Capacity: cfg.Capability, Refill: cfg.TokensAppendDuration, TTL: cfg.TokensExist}.withDefaults(Rate{
+		Capacity: DefaultTokensCap,
+		Refill:   DefaultTokensAppendDuration,
+		TTL:      DefaultTokensExist,
+	})
+	if rate.Refill > rate.TTL {
+		return fmt.Errorf("tokens_append_duration: %s is longer than tokens_exist %s, keys would expire before tokens are appended", rate.Refill, rate.TTL)
+	}
+	if cfg.TTLJitter > rate.TTL {
+		return fmt.Errorf("ttl_jitter: %s is longer than tokens_exist %s", cfg.TTLJitter, rate.TTL)
+	}
+	if t := cfg.TLS; t != nil && (t.CertFile == "") != (t.KeyFile == "") {
+		return errors.New("tls: cert_file and key_file should be set together")
+	}
+	if r := cfg.Replica; r != nil {
+		if r.Host == "" {
+			return errors.New("replica.host: should not be empty")
+		}
+		if r.Port < 0 || r.Port > 65535 {
+			return errors.New("replica.port: should be in [0, 65535]")
+		}
+	}
+	return nil
+}
+
+// Returns error if fields of policy are negative, or if threshold
+// is set without window or duration
+func (p BanPolicy) Validate() error {
+	switch {
+	case p.Threshold < 0:
+		return fmt.Errorf("threshold: %w", errNegative)
+	case p.Window < 0:
+		return fmt.Errorf("window: %w", errNegative)
+	case p.Duration < 0:
+		return fmt.Errorf("duration: %w", errNegative)
+	case p.Threshold > 0 && p.Window == 0:
+		return errors.New("window: should be set with threshold")
+	case p.Threshold > 0 && p.Duration == 0:
+		return errors.New("duration: should be set with threshold")
+	}
+	return nil
+}
+
+// Returns error if fields of policy are negative or penalties aren't positive
+func (p GreylistPolicy) Validate() error {
+	if p.Memory < 0 {
+		return fmt.Errorf("memory: %w", errNegative)
+	}
+	if p.Tarpit < 0 {
+		return fmt.Errorf("tarpit: %w", errNegative)
+	}
+	for i, d := range p.Penalties {
+		if d <= 0 {
+			return fmt.Errorf("penalties[%d]: should be positive", i)
+		}
+	}
+	return nil
+}
+
+// Returns error if fields of breaker are negative
+func (cb CircuitBreaker) Validate() error {
+	if cb.Threshold < 0 {
+		return fmt.Errorf("threshold: %w", errNegative)
+	}
+	if cb.Cooldown < 0 {
+		return fmt.Errorf("cooldown: %w", errNegative)
+	}
+	return nil
+}
+
+// Returns error if fields of policy are negative, or if threshold
+// is set without window or delay
+func (p TarpitPolicy) Validate() error {
+	switch {
+	case p.Threshold < 0:
+		return fmt.Errorf("threshold: %w", errNegative)
+	case p.Window < 0:
+		return fmt.Errorf("window: %w", errNegative)
+	case p.Delay < 0:
+		return fmt.Errorf("delay: %w", errNegative)
+	case p.Threshold > 0 && p.Window == 0:
+		return errors.New("window: should be set with threshold")
+	case p.Threshold > 0 && p.Delay == 0:
+		return errors.New("delay: should be set with threshold")
+	case p.MaxConcurrent < 0:
+		return fmt.Errorf("max_concurrent: %w", errNegative)
+	case p.MaxKeys < 0:
+		return fmt.Errorf("max_keys: %w", errNegative)
+	}
+	return nil
+}
+
+// Returns error if thresholds are out of range or factor doesn't tighten limits
+func (p LoadPolicy) Validate() error {
+	switch {
+	case p.MaxCPU < 0 || p.MaxCPU > 1:
+		return errors.New("max_cpu: should be in [0, 1]")
+	case p.MaxGoroutines < 0:
+		return fmt.Errorf("max_goroutines: %w", errNegative)
+	case p.Factor < 0 || p.Factor >= 1:
+		return errors.New("factor: should be in [0, 1)")
+	case p.Interval < 0:
+		return fmt.Errorf("interval: %w", errNegative)
+	case p.MaxCPU == 0 && p.MaxHeap == 0 && p.MaxGoroutines == 0:
+		return errors.New("max_cpu, max_heap, max_goroutines: at least one threshold should be set")
+	}
+	return nil
+}
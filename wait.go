@@ -0,0 +1,81 @@
+package gincage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Min pause between walks of waiting request, so waiting requests
+// don't hammer storage when tokens are taken by others
+const minWaitStep = time.Duration(5 * time.Millisecond)
+
+// Makes limiter wait for next token of key up to d instead of rejecting
+// request at once. Waiting requests are retried when next token is expected
+// and rejected when it isn't expected in time, so clients see smoothed
+// latency instead of errors. It fits internal APIs.
+//
+// Exact time of next token is known if bucket reports Result.RetryAfter
+// or implements Peeker, otherwise refill interval is waited. If d <= 0, requests aren't delayed
+func WithMaxWait(d time.Duration) Option {
+	return func(l *Limiter) {
+		l.maxWait = d
+	}
+}
+
+// Walks through bucket until token is taken, max wait passes or ctx is done.
+// Returns result of last walk
+func (l *Limiter) wait(ctx context.Context, opts WalkOptions, stats *WalkStats, res Result, latency time.Duration, err error) (*WalkStats, Result, time.Duration, error) {
+	deadline := time.Now().Add(l.maxWait)
+	for errors.Is(err, ErrNoTokensAwailable) {
+		// reset time reported by bucket saves storage call
+		d, ok := retryAfterOf(err)
+		if ok {
+			d = max(d, minWaitStep)
+		} else {
+			d, ok = l.nextToken(ctx, opts)
+		}
+		if !ok || time.Now().Add(d).After(deadline) {
+			return stats, res, latency, err
+		}
+
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return stats, res, latency, err
+		case <-t.C:
+		}
+		stats, res, latency, err = l.walk(ctx, l.bucket, opts)
+	}
+	return stats, res, latency, err
+}
+
+// Returns time after next token of key is expected
+func (l *Limiter) nextToken(ctx context.Context, opts WalkOptions) (time.Duration, bool) {
+	rate := opts.Rate
+	if r, ok := BucketAs[RateReporter](l.bucket); ok {
+		rate = rate.withDefaults(r.Rate())
+	}
+	if r, ok := BucketAs[RefillIntervaler](l.bucket); ok && rate.Refill <= 0 {
+		rate.Refill = r.RefillInterval()
+	}
+	if rate.Refill <= 0 {
+		return 0, false
+	}
+
+	peeker, ok := BucketAs[Peeker](l.bucket)
+	if !ok {
+		return rate.Refill, true
+	}
+	sctx, cancel := l.storageContext(ctx)
+	defer cancel()
+	state, err := peeker.Peek(sctx, opts.Key)
+	if err != nil {
+		return rate.Refill, true
+	}
+	if state.Tokens > 0 {
+		return minWaitStep, true
+	}
+	return max(time.Until(state.RefilledAt.Add(rate.Refill)), minWaitStep), true
+}
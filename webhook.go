@@ -0,0 +1,306 @@
+package gincage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// Default max count of retries of failed webhook delivery
+	DefaultWebhookMaxRetries = 3
+	// Default delay before first retry. Doubles after every retry
+	DefaultWebhookRetryBackoff = time.Duration(time.Second)
+	// Default timeout of one webhook request
+	DefaultWebhookTimeout = time.Duration(5 * time.Second)
+	// Default size of webhook events queue
+	DefaultWebhookQueueSize = 1024
+	// Default window for counting rejections of key
+	DefaultWebhookViolationWindow = time.Duration(time.Minute)
+)
+
+const (
+	// Header with HMAC-SHA256 signature of "<timestamp>.<body>" in form "sha256=<hex>"
+	WebhookSignatureHeader = "X-Gincage-Signature"
+	// Header with unix timestamp of delivery used in signature
+	WebhookTimestampHeader = "X-Gincage-Timestamp"
+)
+
+// Types of webhook events
+const (
+	WebhookEventBan   = "ban"
+	WebhookEventAbuse = "abuse"
+)
+
+type WebhookConfig struct {
+	// Endpoint receiving POST requests with WebhookEvent json
+	URL string
+	// Secret for signing requests. If empty, requests aren't signed
+	Secret string
+
+	// Count of rejections of one key in ViolationWindow after which
+	// abuse event is sent. If <= 0, only bans are reported
+	ViolationThreshold int
+	// Window for counting rejections. If <= 0, uses DefaultWebhookViolationWindow
+	ViolationWindow time.Duration
+
+	// Max count of retries of network errors, 5xx and 429 responses.
+	// If < 0, failed deliveries aren't retried. If 0, uses DefaultWebhookMaxRetries
+	MaxRetries int
+	// Delay before first retry. If <= 0, uses DefaultWebhookRetryBackoff
+	RetryBackoff time.Duration
+	// Timeout of one request. If <= 0, uses DefaultWebhookTimeout
+	Timeout time.Duration
+	// Size of events queue. Events are dropped when queue is full.
+	// If <= 0, uses DefaultWebhookQueueSize
+	QueueSize int
+	// HTTP client. If nil, http.DefaultClient is used
+	Client *http.Client
+}
+
+// WebhookEvent: json body of webhook request
+type WebhookEvent struct {
+	Type   string    `json:"type"`
+	Key    string    `json:"key"`
+	Method string    `json:"method,omitempty"`
+	Path   string    `json:"path,omitempty"`
+	Time   time.Time `json:"time"`
+	// Id of request triggering event, see Request.RequestID
+	RequestID string `json:"request_id,omitempty"`
+	// Set for ban events
+	BanDurationSeconds int `json:"ban_duration_seconds,omitempty"`
+	// Set for abuse events
+	Violations int `json:"violations,omitempty"`
+}
+
+// WebhookNotifier: sends limiter events to webhook asynchronously.
+//
+// Register its methods as limiter hooks:
+//
+//	notifier := gincage.NewWebhookNotifier(cfg, logger)
+//	defer notifier.Close()
+//	limiter.OnBan(notifier.Ban)
+//	limiter.OnReject(notifier.Reject)
+type WebhookNotifier struct {
+	cfg    WebhookConfig
+	logger Logger
+
+	queue   chan WebhookEvent
+	done    chan struct{}
+	closeMu sync.RWMutex
+	closed  bool
+	// cancelled by Close to stop waiting for retries
+	closing context.Context
+	stop    context.CancelFunc
+
+	mu          sync.Mutex
+	violations  map[string]int
+	windowStart time.Time
+}
+
+// Creates notifier and starts delivery goroutine.
+// If logger is nil, logs are discarded
+func NewWebhookNotifier(cfg WebhookConfig, logger Logger) *WebhookNotifier {
+	if cfg.ViolationWindow <= 0 {
+		cfg.ViolationWindow = DefaultWebhookViolationWindow
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = DefaultWebhookMaxRetries
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = DefaultWebhookRetryBackoff
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultWebhookTimeout
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultWebhookQueueSize
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	if logger == nil {
+		logger = NopLogger{}
+	}
+
+	closing, stop := context.WithCancel(context.Background())
+	n := &WebhookNotifier{
+		cfg:         cfg,
+		logger:      logger,
+		queue:       make(chan WebhookEvent, cfg.QueueSize),
+		done:        make(chan struct{}),
+		violations:  make(map[string]int),
+		windowStart: time.Now(),
+		closing:     closing,
+		stop:        stop,
+	}
+	go n.run()
+	return n
+}
+
+// Hook for limiter.OnBan
+func (n *WebhookNotifier) Ban(e Event) {
+	n.enqueue(WebhookEvent{
+		Type:               WebhookEventBan,
+		Key:                e.Key,
+		Method:             e.Method,
+		Path:               e.Path,
+		Time:               e.Time,
+		RequestID:          e.RequestID,
+		BanDurationSeconds: int(e.BanDuration.Seconds()),
+	})
+}
+
+// Hook for limiter.OnReject. Sends abuse event once per window
+// when key reaches ViolationThreshold
+func (n *WebhookNotifier) Reject(e Event) {
+	if n.cfg.ViolationThreshold <= 0 {
+		return
+	}
+
+	n.mu.Lock()
+	// counters are dropped every window, so map doesn't grow unboundedly
+	if time.Since(n.windowStart) >= n.cfg.ViolationWindow {
+		n.violations = make(map[string]int)
+		n.windowStart = time.Now()
+	}
+	n.violations[e.Key]++
+	count := n.violations[e.Key]
+	n.mu.Unlock()
+
+	if count != n.cfg.ViolationThreshold {
+		return
+	}
+	n.enqueue(WebhookEvent{
+		Type:       WebhookEventAbuse,
+		Key:        e.Key,
+		Method:     e.Method,
+		Path:       e.Path,
+		Time:       e.Time,
+		RequestID:  e.RequestID,
+		Violations: count,
+	})
+}
+
+// Stops accepting events and waits until queued events are sent.
+// Failed deliveries aren't retried after Close
+func (n *WebhookNotifier) Close() error {
+	n.closeMu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.closeMu.Unlock()
+	n.stop()
+
+	<-n.done
+	return nil
+}
+
+func (n *WebhookNotifier) enqueue(e WebhookEvent) {
+	n.closeMu.RLock()
+	defer n.closeMu.RUnlock()
+	if n.closed {
+		n.logger.Warn("webhook notifier is closed, event dropped", F("type", e.Type), F("key", e.Key))
+		return
+	}
+
+	select {
+	case n.queue <- e:
+	default:
+		n.logger.Warn("webhook queue is full, event dropped", F("type", e.Type), F("key", e.Key))
+	}
+}
+
+func (n *WebhookNotifier) run() {
+	defer close(n.done)
+	for e := range n.queue {
+		if err := n.deliver(e); err != nil {
+			n.logger.Error("webhook delivery failed",
+				F("error", err),
+				F("type", e.Type),
+				F("key", e.Key),
+			)
+		}
+	}
+}
+
+func (n *WebhookNotifier) deliver(e WebhookEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	backoff := n.cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err = n.post(body)
+		if err == nil || attempt >= n.cfg.MaxRetries || !retryableWebhook(err) {
+			return err
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-n.closing.Done():
+			t.Stop()
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// webhookStatusError: webhook responded with not 2xx status
+type webhookStatusError struct {
+	status int
+}
+
+func (e *webhookStatusError) Error() string {
+	return fmt.Sprintf("webhook responded with status %d", e.status)
+}
+
+// Returns true if delivery failed with network error, 5xx or 429 status.
+// Other statuses would be returned again
+func retryableWebhook(err error) bool {
+	var e *webhookStatusError
+	if !errors.As(err, &e) {
+		return true
+	}
+	return e.status >= 500 || e.status == http.StatusTooManyRequests
+}
+
+func (n *WebhookNotifier) post(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), n.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.cfg.Secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(n.cfg.Secret))
+		mac.Write([]byte(ts + "."))
+		mac.Write(body)
+		req.Header.Set(WebhookTimestampHeader, ts)
+		req.Header.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := n.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &webhookStatusError{status: resp.StatusCode}
+	}
+	return nil
+}
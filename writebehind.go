@@ -0,0 +1,295 @@
+package gincage
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// Default time between flushes of write-behind bucket
+	DefaultFlushInterval = time.Duration(time.Second)
+	// Default count of local takes causing flush before interval ends
+	DefaultFlushThreshold = 1000
+)
+
+// WriteBehindConfigs: settings of write-behind bucket
+type WriteBehindConfigs struct {
+	// Time between flushes. If <= 0, uses DefaultFlushInterval
+	FlushInterval time.Duration
+	// Count of local takes of all keys causing flush.
+	// If <= 0, uses DefaultFlushThreshold
+	FlushThreshold int
+	// Logs failed flushes. If nil, logs are discarded
+	Logger Logger
+	// Source of time for local refill math. If nil, uses SystemClock
+	Clock Clock
+	// Max count of keys kept locally, approximately least recently used
+	// keys are evicted to make room for new ones (see EvictionCounter).
+	// Keys with tokens not flushed yet aren't evicted, flush is started
+	// instead. If <= 0, count of keys isn't bounded
+	MaxKeys int
+}
+
+type writeBehindEntry struct {
+	// local estimate of tokens
+	tokens     int
+	refilledAt time.Time
+	expiresAt  time.Time
+	rate       Rate
+
+	// taken since last flush
+	pending int
+	takenAt time.Time
+	// sent by flush in progress, kept until it ends,
+	// so failed flush can return them to pending
+	flushing int
+}
+
+type writeBehindBucket struct {
+	bucket Bucket
+	syncer Syncer
+	rate   Rate
+	cfg    WriteBehindConfigs
+
+	mu      sync.Mutex
+	entries map[string]*writeBehindEntry
+	pending int
+
+	// serializes flushes, so entry is sent by one flush at a time
+	flushMu sync.Mutex
+
+	evictions atomic.Uint64
+
+	flushNow  chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Wraps bucket, so tokens are taken locally and taken tokens are flushed
+// to bucket on interval or when threshold is reached.
+//
+// It trades exactness for load of storage: instances don't see tokens taken
+// by each other until flush, so key can exceed its limit by tokens taken
+// on other instances during one flush interval.
+// After flush local tokens of key are replaced by tokens from storage.
+//
+// Bucket should implement Syncer, otherwise it is returned as is.
+// Close flushes taken tokens and closes wrapped bucket
+func NewWriteBehindBucket(b Bucket, cfg WriteBehindConfigs) Bucket {
+	syncer, ok := BucketAs[Syncer](b)
+	if !ok {
+		return b
+	}
+
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = DefaultFlushInterval
+	}
+	if cfg.FlushThreshold <= 0 {
+		cfg.FlushThreshold = DefaultFlushThreshold
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = NopLogger{}
+	}
+	cfg.Clock = clockOrDefault(cfg.Clock)
+
+	rate := Rate{
+		Capacity: DefaultTokensCap,
+		Refill:   DefaultTokensAppendDuration,
+		TTL:      DefaultTokensExist,
+	}
+	if r, ok := BucketAs[RateReporter](b); ok {
+		rate = r.Rate()
+	}
+
+	wb := &writeBehindBucket{
+		bucket:   b,
+		syncer:   syncer,
+		rate:     rate,
+		cfg:      cfg,
+		entries:  make(map[string]*writeBehindEntry),
+		flushNow: make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go wb.run()
+	return wb
+}
+
+// Returns wrapped bucket
+func (b *writeBehindBucket) Unwrap() Bucket {
+	return b.bucket
+}
+
+// Takes n tokens of key locally if awailable
+func (b *writeBehindBucket) Take(ctx context.Context, key string, n int) (Result, error) {
+	if stats := WalkStatsFromContext(ctx); stats != nil {
+		stats.Backend = "write-behind"
+	}
+	opts, _ := WalkOptionsFromContext(ctx)
+	rate := opts.Rate.withDefaults(b.rate)
+	now := b.cfg.Clock.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e, ok := b.entries[key]
+	if !ok || (e.idle() && !now.Before(e.expiresAt)) {
+		if !ok {
+			b.evict(now)
+		}
+		e = &writeBehindEntry{tokens: rate.Capacity, refilledAt: now}
+		b.entries[key] = e
+	} else {
+		e.tokens, e.refilledAt = refill(e.tokens, e.refilledAt, rate, now)
+	}
+	n = takeCount(n)
+	if e.tokens < n+opts.reserved(rate) {
+		return denied(rate, e.tokens, e.refilledAt, now), nil
+	}
+
+	e.tokens -= n
+	e.pending += n
+	e.takenAt = now
+	e.expiresAt = now.Add(rate.TTL)
+	e.rate = rate
+
+	b.pending += n
+	if b.pending >= b.cfg.FlushThreshold {
+		b.requestFlush()
+	}
+	return allowed(rate, e.tokens), nil
+}
+
+// Returns true if entry has no tokens to flush
+func (e *writeBehindEntry) idle() bool {
+	return e.pending == 0 && e.flushing == 0
+}
+
+// Makes room for new key if MaxKeys is reached. Should be called with lock held
+func (b *writeBehindBucket) evict(now time.Time) {
+	if b.cfg.MaxKeys <= 0 || len(b.entries) < b.cfg.MaxKeys {
+		return
+	}
+	victim, ok := evictionVictim(b.entries, now,
+		func(e *writeBehindEntry) time.Time { return e.expiresAt },
+		func(e *writeBehindEntry) bool { return e.idle() },
+	)
+	if !ok {
+		// keys are evictable after flush
+		b.requestFlush()
+		return
+	}
+	delete(b.entries, victim)
+	b.evictions.Add(1)
+}
+
+// Starts flush without waiting for interval
+func (b *writeBehindBucket) requestFlush() {
+	select {
+	case b.flushNow <- struct{}{}:
+	default:
+	}
+}
+
+// Returns count of keys evicted because of WriteBehindConfigs.MaxKeys
+func (b *writeBehindBucket) Evictions() uint64 {
+	return b.evictions.Load()
+}
+
+// Flushes taken tokens and closes wrapped bucket
+func (b *writeBehindBucket) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.stop)
+		<-b.done
+	})
+	return b.bucket.Close()
+}
+
+func (b *writeBehindBucket) run() {
+	defer close(b.done)
+
+	t := time.NewTicker(b.cfg.FlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-b.flushNow:
+		case <-b.stop:
+			b.flushInterval()
+			return
+		}
+		b.flushInterval()
+	}
+}
+
+// Flushes taken tokens, flush takes at most flush interval
+func (b *writeBehindBucket) flushInterval() {
+	ctx, cancel := context.WithTimeout(context.Background(), b.cfg.FlushInterval)
+	defer cancel()
+	b.Flush(ctx)
+}
+
+// Sends tokens taken since last flush to bucket
+// and replaces local tokens by tokens from bucket.
+// Tokens which failed to flush are kept for next flush
+func (b *writeBehindBucket) Flush(ctx context.Context) error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	now := b.cfg.Clock.Now()
+
+	b.mu.Lock()
+	var updates []SyncUpdate
+	for key, e := range b.entries {
+		if e.pending == 0 {
+			if e.idle() && !now.Before(e.expiresAt) {
+				delete(b.entries, key)
+			}
+			continue
+		}
+		updates = append(updates, SyncUpdate{
+			Object:    key,
+			Tokens:    e.pending,
+			Timestamp: e.takenAt,
+			Rate:      e.rate,
+		})
+		e.flushing = e.pending
+		e.pending = 0
+	}
+	b.pending = 0
+	b.mu.Unlock()
+
+	if len(updates) == 0 {
+		return nil
+	}
+
+	states, err := b.syncer.Sync(ctx, updates)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, u := range updates {
+		e := b.entries[u.Object]
+		e.flushing = 0
+		if err != nil {
+			// keep tokens for next flush
+			e.pending += u.Tokens
+			b.pending += u.Tokens
+		}
+	}
+	if err != nil {
+		b.cfg.Logger.Error("failed to flush taken tokens", F("error", err), F("keys", len(updates)))
+		return err
+	}
+	for _, st := range states {
+		e, ok := b.entries[st.Key]
+		if !ok {
+			continue
+		}
+		// tokens taken while flushing are still local
+		e.tokens = max(st.Tokens-e.pending, 0)
+		e.refilledAt = st.RefilledAt
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package gincage_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gincage "github.com/fyx1t/gin-cage"
+)
+
+var errSyncFailed = errors.New("sync failed")
+
+// flakySyncer: memory bucket which syncs fail while err is set.
+// If entered is set, next sync signals it and waits for release
+type flakySyncer struct {
+	gincage.Bucket
+	syncer gincage.Syncer
+
+	mu      sync.Mutex
+	err     error
+	entered chan struct{}
+	release chan struct{}
+}
+
+func newFlakySyncer(capacity int) *flakySyncer {
+	b := gincage.NewMemoryBucket(gincage.BucketConfigs{Capability: capacity, TokensAppendDuration: time.Hour})
+	syncer, _ := gincage.BucketAs[gincage.Syncer](b)
+	return &flakySyncer{Bucket: b, syncer: syncer}
+}
+
+func (b *flakySyncer) Unwrap() gincage.Bucket {
+	return b.Bucket
+}
+
+func (b *flakySyncer) Sync(ctx context.Context, updates []gincage.SyncUpdate) ([]gincage.KeyState, error) {
+	b.mu.Lock()
+	entered, release := b.entered, b.release
+	b.entered = nil
+	b.mu.Unlock()
+	if entered != nil {
+		close(entered)
+		<-release
+	}
+
+	b.mu.Lock()
+	err := b.err
+	b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return b.syncer.Sync(ctx, updates)
+}
+
+func (b *flakySyncer) setErr(err error) {
+	b.mu.Lock()
+	b.err = err
+	b.mu.Unlock()
+}
+
+// Returns tokens of key in wrapped memory bucket
+func (b *flakySyncer) tokens(t *testing.T, key string) int {
+	t.Helper()
+	peeker, _ := gincage.BucketAs[gincage.Peeker](b.Bucket)
+	st, err := peeker.Peek(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Peek(%q) = %v", key, err)
+	}
+	return st.Tokens
+}
+
+func TestWriteBehindBucket(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		takes    int
+		// count of failed flushes before successful one
+		failures int
+	}{
+		{name: "enough tokens", capacity: 100, takes: 60},
+		{name: "tokens end", capacity: 30, takes: 100},
+		{name: "failed flushes keep tokens", capacity: 100, takes: 60, failures: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFlakySyncer(tt.capacity)
+			b := gincage.NewWriteBehindBucket(storage, gincage.WriteBehindConfigs{
+				FlushInterval:  time.Hour,
+				FlushThreshold: tt.takes + 1,
+			})
+			defer b.Close()
+			flusher, _ := b.(interface{ Flush(context.Context) error })
+
+			var allowed atomic.Int64
+			var wg sync.WaitGroup
+			for range tt.takes {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					res, err := b.Take(context.Background(), "k", 1)
+					if err != nil {
+						t.Errorf("Take() = %v", err)
+					}
+					if res.Allowed {
+						allowed.Add(1)
+					}
+				}()
+			}
+			// flushes run along with takes
+			storage.setErr(errSyncFailed)
+			for range tt.failures {
+				flusher.Flush(context.Background())
+			}
+			wg.Wait()
+			storage.setErr(nil)
+			if err := flusher.Flush(context.Background()); err != nil {
+				t.Fatalf("Flush() = %v", err)
+			}
+
+			if got, want := allowed.Load(), int64(min(tt.capacity, tt.takes)); got != want {
+				t.Errorf("allowed %d takes, want %d", got, want)
+			}
+			if got, want := storage.tokens(t, "k"), tt.capacity-int(allowed.Load()); got != want {
+				t.Errorf("storage has %d tokens, want %d", got, want)
+			}
+		})
+	}
+}
+
+// Key being flushed isn't evicted, so failed flush doesn't drop its tokens
+func TestWriteBehindBucketEvictionDuringFlush(t *testing.T) {
+	storage := newFlakySyncer(10)
+	b := gincage.NewWriteBehindBucket(storage, gincage.WriteBehindConfigs{
+		FlushInterval: time.Hour,
+		MaxKeys:       1,
+	})
+	defer b.Close()
+	flusher, _ := b.(interface{ Flush(context.Context) error })
+	ctx := context.Background()
+
+	for range 3 {
+		b.Take(ctx, "a", 1)
+	}
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	storage.mu.Lock()
+	storage.err, storage.entered, storage.release = errSyncFailed, entered, release
+	storage.mu.Unlock()
+	done := make(chan error)
+	go func() {
+		done <- flusher.Flush(ctx)
+	}()
+	<-entered
+
+	// new key makes room while tokens of a are in flight
+	b.Take(ctx, "b", 1)
+	close(release)
+	if err := <-done; !errors.Is(err, errSyncFailed) {
+		t.Fatalf("Flush() = %v, want %v", err, errSyncFailed)
+	}
+
+	storage.setErr(nil)
+	if err := flusher.Flush(ctx); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+	if got := storage.tokens(t, "a"); got != 7 {
+		t.Errorf("storage has %d tokens of a, want 7", got)
+	}
+}